@@ -5,7 +5,6 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io"
-	"log"
 	"math"
 	"strconv"
 	"strings"
@@ -20,19 +19,18 @@ import (
 //
 // TODO: Conceal from outside use
 type Asm struct {
-	Instructions []*Instruction `@@*`
+	Instructions []*Instruction `EOL* (@@ EOL*)*`
 }
 
 // Arg holds all parsed argument elements of a single line of assembly code.
 //
 // TODO: Conceal from outside use
 type Arg struct {
-	Sym      *string `(@Sym Whitespace?)?`
+	Sym      *string `(@(Sym | String) Whitespace?)?`
 	Size     *uint32 `(@Size Whitespace?)?`
 	Flag     *uint8  `(@Size Whitespace?)?`
-	Selector *string `(@Sym Whitespace?)?`
-	Desc     *string `(@Sym Whitespace?)?`
-	//Desc *string `(Quote ((@Sym | @Size) @Whitespace?)+ Quote Whitespace?)?`
+	Selector *string `(@(Sym | String) Whitespace?)?`
+	Desc     *string `(@(Sym | String) Whitespace?)?`
 }
 
 // writes the parsed instruction bytes to output.
@@ -157,6 +155,13 @@ func parseFlagged(b *bytes.Buffer, arg Arg) (int, error) {
 
 }
 
+func parseFlag(b *bytes.Buffer, arg Arg) (int, error) {
+	if arg.Size == nil || arg.Sym != nil || arg.Flag != nil {
+		return 0, fmt.Errorf("expected single flag argument")
+	}
+	return writeSize(b, *arg.Size)
+}
+
 func parseOne(op vm.Opcode, instruction *Instruction, w io.Writer) (int, error) {
 	a := instruction.OpArg
 	var n_buf int
@@ -170,9 +175,19 @@ func parseOne(op vm.Opcode, instruction *Instruction, w io.Writer) (int, error)
 		return n_out, err
 	}
 
+	// Catch FSET and FRESET
+	if op == vm.FSET || op == vm.FRESET {
+		n, err := parseFlag(b, a)
+		n_buf += n
+		if err != nil {
+			return n_out, err
+		}
+		return flush(b, w)
+	}
+
 	// Catch
 	if a.Selector != nil {
-		log.Printf("have selector %v", instruction)
+		logg.Tracef("have selector", "instruction", instruction)
 		var n int
 		var err error
 		if op == vm.MOUT {
@@ -189,7 +204,7 @@ func parseOne(op vm.Opcode, instruction *Instruction, w io.Writer) (int, error)
 
 	// Catch CATCH, LOAD and twosyms with integer-as-string
 	if a.Size != nil {
-		log.Printf("have size %v (%v)", instruction, *a.Size)
+		logg.Tracef("have size", "instruction", instruction, "size", *a.Size)
 		if a.Sym == nil {
 			n, err := parseFlagged(b, a)
 			n_buf += n
@@ -231,6 +246,80 @@ func parseOne(op vm.Opcode, instruction *Instruction, w io.Writer) (int, error)
 	return flush(b, w)
 }
 
+// the arguments of an instruction, by which fields of Arg are set.
+//
+// Fields are named in the order they are defined in Arg, with Selector as "sym".
+func argShape(a Arg) string {
+	var s []string
+	if a.Sym != nil {
+		s = append(s, "sym")
+	}
+	if a.Size != nil {
+		s = append(s, "size")
+	}
+	if a.Flag != nil {
+		s = append(s, "flag")
+	}
+	if a.Selector != nil {
+		s = append(s, "sym")
+	}
+	if a.Desc != nil {
+		s = append(s, "sym")
+	}
+	return strings.Join(s, " ")
+}
+
+// argSpec defines the valid arguments of an opcode.
+type argSpec struct {
+	// argument shapes as returned by argShape.
+	shapes []string
+	// argument syntax, for error messages.
+	syntax string
+}
+
+var (
+	argSpecs = map[vm.Opcode]argSpec{
+		vm.NOOP:   {[]string{""}, ""},
+		vm.CATCH:  {[]string{"sym size flag"}, "<symbol> <signal> <matchmode>"},
+		vm.CROAK:  {[]string{"size flag"}, "<signal> <matchmode>"},
+		vm.LOAD:   {[]string{"sym size"}, "<symbol> <size>"},
+		vm.RELOAD: {[]string{"sym"}, "<symbol>"},
+		vm.MAP:    {[]string{"sym"}, "<symbol>"},
+		vm.MOVE:   {[]string{"sym"}, "<symbol>"},
+		vm.HALT:   {[]string{""}, ""},
+		vm.INCMP:  {[]string{"sym size", "sym sym"}, "<symbol> <selector>"},
+		vm.MSINK:  {[]string{""}, ""},
+		vm.MOUT:   {[]string{"sym size", "sym sym"}, "<label> <selector>"},
+		vm.MNEXT:  {[]string{"sym size", "sym sym"}, "<label> <selector>"},
+		vm.MPREV:  {[]string{"sym size", "sym sym"}, "<label> <selector>"},
+		vm.JUMP:   {[]string{"sym"}, "<label>"},
+		vm.JUMPIF: {[]string{"sym size flag"}, "<label> <signal> <matchmode>"},
+		vm.FSET:   {[]string{"size"}, "<flag>"},
+		vm.FRESET: {[]string{"size"}, "<flag>"},
+		vm.INPAT:  {[]string{"sym size", "sym sym"}, "<symbol> <pattern>"},
+		vm.CALL:   {[]string{"sym"}, "<symbol>"},
+		vm.RET:    {[]string{""}, ""},
+	}
+)
+
+// check that the arguments of an instruction are valid for the opcode.
+func checkArgs(op vm.Opcode, a Arg) error {
+	spec, ok := argSpecs[op]
+	if !ok {
+		return nil
+	}
+	shape := argShape(a)
+	for _, v := range spec.shapes {
+		if v == shape {
+			return nil
+		}
+	}
+	if spec.syntax == "" {
+		return fmt.Errorf("expected no arguments")
+	}
+	return fmt.Errorf("expected arguments %s", spec.syntax)
+}
+
 // String implements the String interface.
 func (a Arg) String() string {
 	s := "[Arg]"
@@ -255,15 +344,22 @@ func (a Arg) String() string {
 
 // Instruction represents one full line of assembly code.
 //
+// A line may alternatively define a label, which is the target of a jump instruction.
+//
 // TODO: Conceal from outside use
 type Instruction struct {
-	OpCode  string `@Ident`
-	OpArg   Arg    `(Whitespace @@)?`
-	Comment string `Comment? EOL`
+	Pos     lexer.Position
+	Label   *string `( @Sym Colon Comment? EOL`
+	OpCode  string  `| @Ident`
+	OpArg   Arg     `(Whitespace @@)?`
+	Comment string  `Comment? EOL )`
 }
 
 // String implements the String interface.
 func (i Instruction) String() string {
+	if i.Label != nil {
+		return *i.Label + ":"
+	}
 	return fmt.Sprintf("%s %s", i.OpCode, i.OpArg)
 }
 
@@ -275,7 +371,9 @@ var (
 		{"Sym", `[a-zA-Z_\*\.\^\<\>][a-zA-Z0-9_]*`},
 		{"Whitespace", `[ \t]+`},
 		{"EOL", `[\n\r]+`},
+		{"String", `"(?:\\.|[^"\\\n])*"`},
 		{"Quote", `["']`},
+		{"Colon", `:`},
 	})
 	asmParser = participle.MustBuild[Asm](
 		participle.Lexer(asmLexer),
@@ -323,6 +421,7 @@ func writeSize(w *bytes.Buffer, n uint32) (int, error) {
 type Batcher struct {
 	menuProcessor MenuProcessor
 	inMenu        bool
+	positions     []location
 }
 
 // NewBatcher creates a new Batcher objcet.
@@ -339,15 +438,42 @@ func (bt *Batcher) MenuExit(w io.Writer) (int, error) {
 	}
 	bt.inMenu = false
 	b := bt.menuProcessor.ToLines()
+	bt.menuProcessor = NewMenuProcessor()
 	return w.Write(b)
 }
 
+// like MenuExit, and also calls fn with the offset in the output and the source position of each generated instruction.
+func (bt *Batcher) menuExitMapped(out *bytes.Buffer, fn func(int, location)) error {
+	if !bt.inMenu {
+		return nil
+	}
+	bt.inMenu = false
+	start := out.Len()
+	b, lines := bt.menuProcessor.toLines()
+	bt.menuProcessor = NewMenuProcessor()
+	_, err := out.Write(b)
+	if err != nil {
+		return err
+	}
+	for _, v := range lines {
+		fn(start+v.offset, bt.positions[v.item])
+	}
+	bt.positions = []location{}
+	return nil
+}
+
 // MenuAdd adds a new menu instruction to the batcher.
 func (bt *Batcher) MenuAdd(w io.Writer, code string, arg Arg) (int, error) {
-	bt.inMenu = true
 	var selector string
 	var sym string
 	var display string
+	_, ok := batchCode[code]
+	if !ok {
+		return 0, fmt.Errorf("unknown instruction: %s", code)
+	}
+	if arg.Selector == nil || (arg.Sym == nil && arg.Size == nil) || arg.Flag != nil {
+		return 0, fmt.Errorf("%s: expected arguments [<target>] <selector> <label>", code)
+	}
 	if arg.Desc != nil {
 		sym = *arg.Sym
 		display = *arg.Desc
@@ -362,9 +488,13 @@ func (bt *Batcher) MenuAdd(w io.Writer, code string, arg Arg) (int, error) {
 		selector = *arg.Sym
 		display = *arg.Selector
 	}
-	log.Printf("menu processor add %v '%v' '%v' '%v'", code, selector, display, sym)
+	logg.Tracef("menu processor add", "code", code, "selector", selector, "display", display, "sym", sym)
 	err := bt.menuProcessor.Add(code, selector, display, sym)
-	return 0, err
+	if err != nil {
+		return 0, fmt.Errorf("%s: %v", code, err)
+	}
+	bt.inMenu = true
+	return 0, nil
 }
 
 // Exit is a synonym for MenuExit
@@ -372,46 +502,207 @@ func (bt *Batcher) Exit(w io.Writer) (int, error) {
 	return bt.MenuExit(w)
 }
 
+// jumpRef is a jump instruction whose target label has not yet been resolved.
+type jumpRef struct {
+	label string
+	// position of the offset argument in the bytecode.
+	pos int
+	// position of the end of the jump instruction in the bytecode.
+	end int
+	// source position of the jump instruction.
+	src location
+}
+
+// writes a jump instruction with a placeholder offset to be resolved when all labels are known.
+//
+// The offset is always written with two bytes, so that the size of the instruction does not depend on the offset.
+func parseJump(op vm.Opcode, instruction *Instruction, b *bytes.Buffer) (jumpRef, error) {
+	var j jumpRef
+	a := instruction.OpArg
+	if a.Sym == nil {
+		return j, fmt.Errorf("missing label for %s", instruction.OpCode)
+	}
+	j.label = *a.Sym
+	_, err := writeOpcode(b, op)
+	if err != nil {
+		return j, err
+	}
+	j.pos = b.Len() + 1
+	b.Write([]byte{0x02, 0x00, 0x00})
+	if op == vm.JUMPIF {
+		if a.Size == nil || a.Flag == nil {
+			return j, fmt.Errorf("missing signal or matchmode for %s", instruction.OpCode)
+		}
+		_, err = parseFlagged(b, a)
+		if err != nil {
+			return j, err
+		}
+	}
+	j.end = b.Len()
+	return j, nil
+}
+
+// writes the offsets of jump instructions to their target labels.
+//
+// Only forward jumps are allowed. Jumps that cannot be resolved are recorded in the diagnostics.
+func resolveJumps(b []byte, labels map[string]int, jumps []jumpRef, diags *Diagnostics) {
+	for _, j := range jumps {
+		target, ok := labels[j.label]
+		if !ok {
+			diags.errorf(j.src, "undefined label: %s", j.label)
+			continue
+		}
+		if target < j.end {
+			diags.errorf(j.src, "backward jump to label %s not supported", j.label)
+			continue
+		}
+		offset := target - j.end
+		if offset > math.MaxUint16 {
+			diags.errorf(j.src, "jump to label %s too far: %v bytes", j.label, offset)
+			continue
+		}
+		binary.BigEndian.PutUint16(b[j.pos:], uint16(offset))
+	}
+}
+
 // Parse one or more lines of assembly code, and write assembled bytecode to the provided writer.
+//
+// Labels are resolved to offsets in the assembled bytecode, so output is only written after all lines have been parsed.
 func Parse(s string, w io.Writer) (int, error) {
-	rd := strings.NewReader(s)
-	ast, err := asmParser.Parse("file", rd)
-	if err != nil {
-		return 0, err
+	return ParseWithSourceMap(s, w, "file", nil)
+}
+
+// ParseWithSourceMap parses assembly code like Parse, and records the source position of each assembled instruction in the given source map.
+//
+// The file name is used for the source positions, and in parse errors.
+//
+// Instructions generated from a menu batch are mapped to the line of the menu command they were generated from.
+//
+// If assembly fails, the returned error is a Diagnostics with all errors found. Warnings are only logged.
+func ParseWithSourceMap(s string, w io.Writer, file string, sm *vm.SourceMap) (int, error) {
+	n, diags := ParseWithDiagnostics(s, w, file, sm)
+	for _, v := range diags.Warnings() {
+		logg.Debugf("assembler warning", "warning", v)
+	}
+	return n, diags.Err()
+}
+
+// ParseWithDiagnostics parses assembly code like ParseWithSourceMap, and returns all errors and warnings found.
+//
+// Parsing continues after errors, so that as many as possible are reported at once. Output is only written if there are no errors.
+func ParseWithDiagnostics(s string, w io.Writer, file string, sm *vm.SourceMap) (int, Diagnostics) {
+	return assemble(s, w, file, sm, fileLocator(file), nil)
+}
+
+// ParseSource parses assembly code expanded by a Preprocessor like ParseWithDiagnostics.
+//
+// Source positions in the source map and diagnostics refer to the files and lines the expanded code was produced from.
+//
+// Quoted menu labels are replaced by generated keys if the Preprocessor has been set up with WithMenuLabels.
+func ParseSource(src *Source, w io.Writer, sm *vm.SourceMap) (int, Diagnostics) {
+	return assemble(src.String(), w, src.file, sm, src.locate, src.labels)
+}
+
+// assembles source, with source positions resolved by the given locator.
+//
+// If ml is not nil, quoted menu labels are registered in it, and replaced by their keys.
+func assemble(s string, w io.Writer, file string, sm *vm.SourceMap, loc locator, ml *MenuLabels) (int, Diagnostics) {
+	var diags Diagnostics
+	ast := parseSource(s, file, loc, &diags)
+	if ast == nil {
+		return 0, diags
 	}
 
 	batch := Batcher{}
+	out := bytes.NewBuffer(nil)
+	labels := make(map[string]int)
+	labelPos := make(map[string]location)
+	var jumps []jumpRef
+	check := newChecker(&diags)
+	emit := func(offset int, pos location) {
+		if sm != nil {
+			sm.Add(offset, pos.SourcePos)
+		}
+		check.instruction(out.Bytes()[offset:], pos)
+	}
+	exitBatch := func() {
+		err := batch.menuExitMapped(out, emit)
+		if err != nil {
+			diags.errorf(location{SourcePos: vm.SourcePos{File: file}}, "%v", err)
+		}
+	}
 
-	var rn int
 	for _, v := range ast.Instructions {
-		log.Printf("parsing line %v: %v", v.OpCode, v.OpArg)
+		pos := loc(v.Pos.Line, v.Pos.Column)
+		if v.Label != nil {
+			exitBatch()
+			p, ok := labelPos[*v.Label]
+			if ok {
+				diags.errorf(pos, "duplicate label: %s, first defined at %s", *v.Label, p.ref(pos))
+				continue
+			}
+			logg.Tracef("label", "label", *v.Label, "offset", out.Len())
+			labels[*v.Label] = out.Len()
+			labelPos[*v.Label] = pos
+			check.label()
+			continue
+		}
+		logg.Tracef("parsing line", "opcode", v.OpCode, "arg", v.OpArg)
+		err := menuLabelArg(v.OpCode, &v.OpArg, ml)
+		if err != nil {
+			diags.errorf(pos, "%s: %v", v.OpCode, err)
+			continue
+		}
 		op, ok := vm.OpcodeIndex[v.OpCode]
 		if !ok {
-			n, err := batch.MenuAdd(w, v.OpCode, v.OpArg)
-			rn += n
-			if err != nil {
-				return rn, err
-			}
-		} else {
-			n, err := batch.MenuExit(w)
+			_, err := batch.MenuAdd(out, v.OpCode, v.OpArg)
 			if err != nil {
-				return rn, err
+				diags.errorf(pos, "%v", err)
+				continue
 			}
-			rn += n
-			n, err = parseOne(op, v, w)
-			rn += n
+			batch.positions = append(batch.positions, pos)
+			continue
+		}
+		exitBatch()
+		err = checkArgs(op, v.OpArg)
+		if err != nil {
+			diags.errorf(pos, "%s: %v", v.OpCode, err)
+			continue
+		}
+		start := out.Len()
+		if op == vm.JUMP || op == vm.JUMPIF {
+			j, err := parseJump(op, v, out)
 			if err != nil {
-				return rn, err
+				out.Truncate(start)
+				diags.errorf(pos, "%s: %v", v.OpCode, err)
+				continue
 			}
-			log.Printf("wrote %v bytes for %v", n, v.OpArg)
+			j.src = pos
+			jumps = append(jumps, j)
+			emit(start, pos)
+			continue
 		}
+		n, err := parseOne(op, v, out)
+		if err != nil {
+			out.Truncate(start)
+			diags.errorf(pos, "%s: %v", v.OpCode, err)
+			continue
+		}
+		logg.Tracef("wrote instruction", "bytes", n, "arg", v.OpArg)
+		emit(start, pos)
 	}
-	n, err := batch.Exit(w)
-	rn += n
+	exitBatch()
+	check.finish()
+
+	b := out.Bytes()
+	resolveJumps(b, labels, jumps, &diags)
+	diags.sort()
+	if len(diags.Errors()) > 0 || w == nil {
+		return 0, diags
+	}
+	n, err := w.Write(b)
 	if err != nil {
-		return rn, err
+		diags.errorf(location{SourcePos: vm.SourcePos{File: file}}, "%v", err)
 	}
-	rn += n
-
-	return rn, err
+	return n, diags
 }
@@ -388,6 +388,82 @@ func TestParseNoarg(t *testing.T) {
 	}
 }
 
+func TestParseFlag(t *testing.T) {
+	var b []byte
+	ph := vm.NewParseHandler().WithDefaultHandlers()
+	b = vm.NewLine(b, vm.FSET, nil, []byte{0x08}, nil)
+	b = vm.NewLine(b, vm.FRESET, nil, []byte{0x01, 0x02}, nil)
+	s, err := ph.ToString(b)
+	log.Printf("parsing:\n%s\n", s)
+
+	r := bytes.NewBuffer(nil)
+	n, err := Parse(s, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 9 {
+		t.Fatalf("expected 9 byte write count, got %v", n)
+	}
+	rb := r.Bytes()
+	expect := []byte{0x00, vm.FSET, 0x01, 0x08, 0x00, vm.FRESET, 0x02, 0x01, 0x02}
+	if !bytes.Equal(rb, expect) {
+		t.Fatalf("expected %x, got %x", expect, rb)
+	}
+
+	_, err = Parse("FSET foo\n", nil)
+	if err == nil {
+		t.Fatal("expected error for symbol flag")
+	}
+}
+
+func TestParseInPat(t *testing.T) {
+	var b []byte
+	ph := vm.NewParseHandler().WithDefaultHandlers()
+	b = vm.NewLine(b, vm.INPAT, []string{"foo", "pin"}, nil, nil)
+	b = vm.NewLine(b, vm.INPAT, []string{"bar", "2"}, nil, nil)
+	s, err := ph.ToString(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := "INPAT foo pin\nINPAT bar 2\n"
+	if s != expect {
+		t.Fatalf("expected:\n\t%v\ngot:\n\t%v", expect, s)
+	}
+
+	r := bytes.NewBuffer(nil)
+	_, err = Parse(s, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(r.Bytes(), b) {
+		t.Fatalf("expected %x, got %x", b, r.Bytes())
+	}
+}
+
+func TestParseCall(t *testing.T) {
+	var b []byte
+	ph := vm.NewParseHandler().WithDefaultHandlers()
+	b = vm.NewLine(b, vm.CALL, []string{"foo"}, nil, nil)
+	b = vm.NewLine(b, vm.RET, nil, nil, nil)
+	s, err := ph.ToString(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := "CALL foo\nRET\n"
+	if s != expect {
+		t.Fatalf("expected:\n\t%v\ngot:\n\t%v", expect, s)
+	}
+
+	r := bytes.NewBuffer(nil)
+	_, err = Parse(s, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(r.Bytes(), b) {
+		t.Fatalf("expected %x, got %x", b, r.Bytes())
+	}
+}
+
 func TestParserWriteMultiple(t *testing.T) {
 	var b []byte
 	b = vm.NewLine(b, vm.HALT, nil, nil, nil)
@@ -444,3 +520,103 @@ func TestParserCapQuote(t *testing.T) {
 	}
 	_ = n
 }
+
+func TestParseJump(t *testing.T) {
+	s := `LOAD foo 0
+JUMPIF other 8 1
+MAP foo
+JUMP done
+other:
+MAP bar
+done:
+HALT
+`
+	r := bytes.NewBuffer(nil)
+	n, err := Parse(s, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b []byte
+	b = vm.NewLine(b, vm.LOAD, []string{"foo"}, []byte{0x00}, nil)
+	b = vm.NewLine(b, vm.JUMPIF, nil, []byte{0x00, 0x0b}, nil)
+	b = append(b, 0x01, 0x08, 0x01)
+	b = vm.NewLine(b, vm.MAP, []string{"foo"}, nil, nil)
+	b = vm.NewLine(b, vm.JUMP, nil, []byte{0x00, 0x06}, nil)
+	b = vm.NewLine(b, vm.MAP, []string{"bar"}, nil, nil)
+	b = vm.NewLine(b, vm.HALT, nil, nil, nil)
+	if n != len(b) {
+		t.Fatalf("expected %d bytes, got %d", len(b), n)
+	}
+	if !bytes.Equal(r.Bytes(), b) {
+		t.Fatalf("expected:\n\t%x\ngot:\n\t%x", b, r.Bytes())
+	}
+
+	ph := vm.NewParseHandler().WithDefaultHandlers()
+	ss, err := ph.ToString(r.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := `LOAD foo 0
+JUMPIF l_27 8 1
+MAP foo
+JUMP l_33
+l_27:
+MAP bar
+l_33:
+HALT
+`
+	if ss != expect {
+		t.Fatalf("expected:\n%s\ngot:\n%s", expect, ss)
+	}
+
+	rr := bytes.NewBuffer(nil)
+	_, err = Parse(ss, rr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(rr.Bytes(), r.Bytes()) {
+		t.Fatalf("expected:\n\t%x\ngot:\n\t%x", r.Bytes(), rr.Bytes())
+	}
+}
+
+func TestParseJumpInvalid(t *testing.T) {
+	for _, s := range []string{
+		"JUMP nowhere\nHALT\n",
+		"back:\nHALT\nJUMP back\n",
+		"JUMP twice\ntwice:\nHALT\ntwice:\n",
+	} {
+		_, err := Parse(s, nil)
+		if err == nil {
+			t.Fatalf("expected error for:\n%s", s)
+		}
+	}
+}
+
+func TestParseSourceMap(t *testing.T) {
+	var b []byte
+	b = vm.NewLine(nil, vm.LOAD, []string{"foo"}, []byte{0x0a}, nil)
+	b = vm.NewLine(b, vm.MOUT, []string{"bar", "1"}, nil, nil)
+	b = vm.NewLine(b, vm.HALT, nil, nil, nil)
+	b = vm.NewLine(b, vm.INCMP, []string{"to_bar", "1"}, nil, nil)
+	expect := b
+
+	s := `
+# load
+LOAD foo 10
+  DOWN to_bar 1 bar
+`
+	sm := vm.NewSourceMap()
+	r := bytes.NewBuffer(nil)
+	_, err := ParseWithSourceMap(s, r, "root.vis", sm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(r.Bytes(), expect) {
+		t.Fatalf("expected:\n\t%x\ngot:\n\t%x\n", expect, r)
+	}
+
+	expectMap := "0 root.vis:3:1\n8 root.vis:4:3\n16 root.vis:4:3\n18 root.vis:4:3\n"
+	if string(sm.Bytes()) != expectMap {
+		t.Fatalf("expected source map:\n%s\ngot:\n%s", expectMap, sm.Bytes())
+	}
+}
@@ -0,0 +1,314 @@
+package asm
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/alecthomas/participle/v2"
+
+	"git.defalsify.org/vise.git/vm"
+)
+
+// Severity is the severity of a Diagnostic.
+type Severity uint8
+
+const (
+	// The source cannot be assembled.
+	SEVERITY_ERROR Severity = iota
+	// The source can be assembled, but probably does not do what was intended.
+	SEVERITY_WARNING
+)
+
+// String implements the String interface.
+func (s Severity) String() string {
+	if s == SEVERITY_WARNING {
+		return "warning"
+	}
+	return "error"
+}
+
+// Expansion is an include directive or a macro invocation that a line of assembly source was produced by.
+type Expansion struct {
+	// Name of the macro, or empty if the line was included from a file.
+	Macro string
+	// Position of the include directive or macro invocation.
+	Pos vm.SourcePos
+}
+
+// String implements the String interface.
+func (e Expansion) String() string {
+	if e.Macro == "" {
+		return fmt.Sprintf("included from %s", e.Pos)
+	}
+	return fmt.Sprintf("in macro %s expanded at %s", e.Macro, e.Pos)
+}
+
+// Diagnostic is a single error or warning found when assembling, with the source position it applies to.
+type Diagnostic struct {
+	Severity Severity
+	Pos      vm.SourcePos
+	Msg      string
+	// Expansions the source line was produced by, innermost first. Empty if the line was not produced by the Preprocessor.
+	Trace []Expansion
+	// line in the expanded source, for ordering.
+	line int
+}
+
+// Error implements the Error interface.
+//
+// The format is "<file>:<line>:<column>: <severity>: <message>", followed by one indented line for each expansion in the trace.
+func (d Diagnostic) Error() string {
+	s := fmt.Sprintf("%s: %s: %s", d.Pos, d.Severity, d.Msg)
+	for _, v := range d.Trace {
+		s += "\n\t" + v.String()
+	}
+	return s
+}
+
+// Diagnostics is a list of diagnostics, in the order they were found.
+type Diagnostics []Diagnostic
+
+// Errors returns only the diagnostics with SEVERITY_ERROR.
+func (d Diagnostics) Errors() Diagnostics {
+	return d.filter(SEVERITY_ERROR)
+}
+
+// Warnings returns only the diagnostics with SEVERITY_WARNING.
+func (d Diagnostics) Warnings() Diagnostics {
+	return d.filter(SEVERITY_WARNING)
+}
+
+// Err returns the errors of the list as an error, or nil if there are none.
+func (d Diagnostics) Err() error {
+	r := d.Errors()
+	if len(r) == 0 {
+		return nil
+	}
+	return r
+}
+
+// Error implements the Error interface.
+//
+// Each diagnostic is on a separate line.
+func (d Diagnostics) Error() string {
+	var s []string
+	for _, v := range d {
+		s = append(s, v.Error())
+	}
+	return strings.Join(s, "\n")
+}
+
+// sort diagnostics by position in the expanded source.
+func (d Diagnostics) sort() {
+	sort.SliceStable(d, func(i, j int) bool {
+		if d[i].line != d[j].line {
+			return d[i].line < d[j].line
+		}
+		return d[i].Pos.Column < d[j].Pos.Column
+	})
+}
+
+func (d Diagnostics) filter(severity Severity) Diagnostics {
+	var r Diagnostics
+	for _, v := range d {
+		if v.Severity == severity {
+			r = append(r, v)
+		}
+	}
+	return r
+}
+
+func (d *Diagnostics) add(severity Severity, l location, s string, args ...any) {
+	*d = append(*d, Diagnostic{
+		Severity: severity,
+		Pos:      l.SourcePos,
+		Msg:      fmt.Sprintf(s, args...),
+		Trace:    l.trace,
+		line:     l.line,
+	})
+}
+
+func (d *Diagnostics) errorf(l location, s string, args ...any) {
+	d.add(SEVERITY_ERROR, l, s, args...)
+}
+
+func (d *Diagnostics) warnf(l location, s string, args ...any) {
+	d.add(SEVERITY_WARNING, l, s, args...)
+}
+
+// location is a position in assembly source, with the expansions that produced it.
+type location struct {
+	vm.SourcePos
+	trace []Expansion
+	// line in the expanded source.
+	line int
+}
+
+// refer to a location in a message about another location.
+//
+// The file is left out if it is the same for both.
+func (l location) ref(at location) string {
+	if l.File == at.File {
+		return fmt.Sprintf("line %d", l.Line)
+	}
+	return l.SourcePos.String()
+}
+
+// locator resolves a line and column in the source given to the parser to a location.
+type locator func(line int, column int) location
+
+// locator for source that has not been expanded.
+func fileLocator(file string) locator {
+	return func(line int, column int) location {
+		return location{
+			SourcePos: vm.SourcePos{
+				File:   file,
+				Line:   line,
+				Column: column,
+			},
+			line: line,
+		}
+	}
+}
+
+// parse assembly source, recording syntax errors in the diagnostics.
+//
+// The parser stops at the first syntax error. To find more than one, the offending line is blanked out and parsing is retried. Line numbers remain the same, since the line itself is kept.
+func parseSource(s string, file string, loc locator, diags *Diagnostics) *Asm {
+	lines := strings.Split(s, "\n")
+	for {
+		ast, err := asmParser.ParseString(file, strings.Join(lines, "\n"))
+		if err == nil {
+			return ast
+		}
+		var perr participle.Error
+		if !errors.As(err, &perr) {
+			diags.errorf(location{SourcePos: vm.SourcePos{File: file}}, "%v", err)
+			return nil
+		}
+		p := perr.Position()
+		diags.errorf(loc(p.Line, p.Column), "%s", perr.Message())
+		i := p.Line - 1
+		if i < 0 || i >= len(lines) || lines[i] == "" {
+			return nil
+		}
+		lines[i] = ""
+	}
+}
+
+// checker finds instruction sequences that are valid bytecode, but probably not what was intended.
+//
+// Instructions are passed to the checker in the order they appear in the bytecode, including those generated by menu batches.
+type checker struct {
+	diags *Diagnostics
+	// opcode after which the following instructions are not reached, or not run in this node, if any.
+	end       string
+	endWarned bool
+	// first menu instruction not yet followed by HALT.
+	menu   *location
+	menuOp string
+	// INCMP selectors since the last HALT.
+	selectors map[string]location
+	// symbols loaded with size 0.
+	sinks map[string]location
+	// symbol loaded with size 0 mapped since the last MOVE.
+	sink string
+}
+
+func newChecker(diags *Diagnostics) *checker {
+	return &checker{
+		diags:     diags,
+		selectors: make(map[string]location),
+		sinks:     make(map[string]location),
+	}
+}
+
+// label marks the following instruction as a jump target.
+func (c *checker) label() {
+	c.end = ""
+	c.endWarned = false
+}
+
+// instruction checks a single assembled instruction, where b is the bytecode from the start of the instruction.
+func (c *checker) instruction(b []byte, pos location) {
+	op, b, err := vm.ParseOp(b)
+	if err != nil {
+		return
+	}
+	if c.end != "" && !c.endWarned {
+		if c.end == vm.OpcodeString[vm.MOVE] {
+			c.diags.warnf(pos, "instruction after MOVE runs in the context of the next node")
+		} else {
+			c.diags.warnf(pos, "unreachable instruction after %s", c.end)
+		}
+		c.endWarned = true
+	}
+	switch op {
+	case vm.LOAD:
+		sym, size, _, err := vm.ParseLoad(b)
+		if err == nil && size == 0 {
+			c.sinks[sym] = pos
+		}
+	case vm.MAP:
+		sym, _, err := vm.ParseMap(b)
+		if err != nil {
+			return
+		}
+		p, ok := c.sinks[sym]
+		if !ok {
+			return
+		}
+		if c.sink != "" && c.sink != sym {
+			c.diags.warnf(pos, "MAP of symbol %s loaded with size 0 at %s, but %s is already mapped as the content sink; rendering will fail", sym, p.ref(pos), c.sink)
+			return
+		}
+		c.diags.warnf(pos, "MAP of symbol %s loaded with size 0 at %s; it will be rendered as the content sink", sym, p.ref(pos))
+		c.sink = sym
+	case vm.INCMP:
+		_, sel, _, err := vm.ParseInCmp(b)
+		if err != nil {
+			return
+		}
+		p, ok := c.selectors[sel]
+		if ok {
+			c.diags.warnf(pos, "duplicate INCMP selector %s, first used at %s; it will never match", sel, p.ref(pos))
+			return
+		}
+		c.selectors[sel] = pos
+	case vm.MOUT, vm.MNEXT, vm.MPREV:
+		if c.menu == nil {
+			c.menu = &pos
+			c.menuOp = vm.OpcodeString[op]
+		}
+	case vm.HALT:
+		c.menu = nil
+		c.selectors = make(map[string]location)
+	case vm.MOVE:
+		c.menuEnd()
+		c.sink = ""
+		// Code after MOVE runs before the bytecode of the next node, but after the move has been made.
+		c.terminate(op)
+	case vm.JUMP, vm.RET:
+		c.terminate(op)
+	}
+}
+
+// finish checks for problems that can only be known when all instructions have been seen.
+func (c *checker) finish() {
+	c.menuEnd()
+}
+
+func (c *checker) terminate(op vm.Opcode) {
+	c.end = vm.OpcodeString[op]
+	c.endWarned = false
+}
+
+// warn if a menu has been defined, but execution never halts to show it.
+func (c *checker) menuEnd() {
+	if c.menu != nil {
+		c.diags.warnf(*c.menu, "%s without HALT; menu will not be shown", c.menuOp)
+	}
+	c.menu = nil
+}
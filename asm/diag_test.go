@@ -0,0 +1,104 @@
+package asm
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestDiagnosticsErrors(t *testing.T) {
+	s := `LOAD foo
+HALT 1
+CATCH bar foo 1
+FOO bar
+JUMP nowhere
+MAP foo
+`
+	r := bytes.NewBuffer(nil)
+	n, diags := ParseWithDiagnostics(s, r, "root.vis", nil)
+	if n != 0 || r.Len() > 0 {
+		t.Fatalf("expected no output, got %x", r.Bytes())
+	}
+	expect := []string{
+		"root.vis:1:1: error: LOAD: expected arguments <symbol> <size>",
+		"root.vis:2:1: error: HALT: expected no arguments",
+		"root.vis:3:15: error: unexpected token \"1\" (expected <eol>)",
+		"root.vis:4:1: error: unknown instruction: FOO",
+		"root.vis:5:1: error: undefined label: nowhere",
+	}
+	errs := diags.Errors()
+	if len(errs) != len(expect) {
+		t.Fatalf("expected %d errors, got:\n%v", len(expect), diags)
+	}
+	for i, v := range errs {
+		if v.Error() != expect[i] {
+			t.Fatalf("expected '%s', got '%s'", expect[i], v.Error())
+		}
+	}
+
+	_, err := Parse(s, nil)
+	errs = Diagnostics{}
+	if !errors.As(err, &errs) {
+		t.Fatalf("expected diagnostics error, got %v", err)
+	}
+	if len(errs) != len(expect) {
+		t.Fatalf("expected %d errors, got %d", len(expect), len(errs))
+	}
+}
+
+func TestDiagnosticsWarnings(t *testing.T) {
+	s := `LOAD foo 0
+MAP foo
+MOUT quit 0
+INCMP bar 1
+INCMP baz 1
+MOVE bar
+HALT
+skip:
+CALL baz
+RET
+MAP bar
+`
+	r := bytes.NewBuffer(nil)
+	_, diags := ParseWithDiagnostics(s, r, "root.vis", nil)
+	if diags.Err() != nil {
+		t.Fatal(diags.Err())
+	}
+	if r.Len() == 0 {
+		t.Fatal("expected output")
+	}
+	expect := []string{
+		"root.vis:2:1: warning: MAP of symbol foo loaded with size 0 at line 1",
+		"root.vis:3:1: warning: MOUT without HALT",
+		"root.vis:5:1: warning: duplicate INCMP selector 1, first used at line 4",
+		"root.vis:7:1: warning: instruction after MOVE runs in the context of the next node",
+		"root.vis:11:1: warning: unreachable instruction after RET",
+	}
+	if len(diags) != len(expect) {
+		t.Fatalf("expected %d diagnostics, got:\n%v", len(expect), diags)
+	}
+	for i, v := range diags {
+		if !strings.HasPrefix(v.Error(), expect[i]) {
+			t.Fatalf("expected '%s', got '%s'", expect[i], v.Error())
+		}
+	}
+	if len(diags.Warnings()) != len(expect) {
+		t.Fatalf("expected only warnings, got %v", diags)
+	}
+}
+
+func TestDiagnosticsMenuBatch(t *testing.T) {
+	s := `DOWN foo 1 to_foo
+UP 1 to_back
+NEXT 11 to_next
+`
+	_, diags := ParseWithDiagnostics(s, nil, "root.vis", nil)
+	if len(diags) != 1 {
+		t.Fatalf("expected 1 diagnostic, got:\n%v", diags)
+	}
+	expect := "root.vis:2:1: warning: duplicate INCMP selector 1, first used at line 1; it will never match"
+	if diags[0].Error() != expect {
+		t.Fatalf("expected '%s', got '%s'", expect, diags[0].Error())
+	}
+}
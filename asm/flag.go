@@ -124,7 +124,7 @@ func (pp *FlagParser) Load(fp string) (int, error) {
 				logg.Debugf("added flag translation", "from", v[1], "to", v[2])
 			}
 			if pp.debug {
-				state.FlagDebugger.Register(fl, v[2])
+				state.FlagDebugger.Register(fl, v[1])
 			}
 		}
 	}
@@ -0,0 +1,96 @@
+package asm
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// Prefix of generated menu resource keys for quoted menu labels.
+	MENU_LABEL_PREFIX = "menu_"
+)
+
+// MenuLabels collects quoted menu labels, and assigns each a generated menu resource key.
+//
+// The key is derived from the label text, so the same label always gets the same key.
+type MenuLabels struct {
+	labels map[string]string
+}
+
+// NewMenuLabels creates a new, empty MenuLabels.
+func NewMenuLabels() *MenuLabels {
+	return &MenuLabels{
+		labels: make(map[string]string),
+	}
+}
+
+// Add registers a menu label, and returns the menu resource key for it.
+func (ml *MenuLabels) Add(label string) string {
+	h := sha256.Sum256([]byte(label))
+	k := fmt.Sprintf("%s%x", MENU_LABEL_PREFIX, h[:6])
+	ml.labels[k] = label
+	return k
+}
+
+// Get returns the menu label registered for the given key.
+func (ml *MenuLabels) Get(key string) (string, bool) {
+	v, ok := ml.labels[key]
+	return v, ok
+}
+
+// Keys returns the keys of all registered menu labels, sorted.
+func (ml *MenuLabels) Keys() []string {
+	var r []string
+	for k := range ml.labels {
+		r = append(r, k)
+	}
+	sort.Strings(r)
+	return r
+}
+
+func isQuoted(s string) bool {
+	return strings.HasPrefix(s, `"`)
+}
+
+// resolve quoted strings in the arguments of an instruction.
+//
+// Quoted strings are only valid as menu labels, that is the first argument of MOUT, MNEXT and MPREV, and the last argument of menu batch commands. The label is used as is, or replaced by its key if ml is not nil.
+func menuLabelArg(code string, a *Arg, ml *MenuLabels) error {
+	var label **string
+	switch code {
+	case "MOUT", "MNEXT", "MPREV":
+		label = &a.Sym
+	default:
+		_, ok := batchCode[code]
+		if !ok {
+			break
+		}
+		label = &a.Selector
+		if a.Desc != nil {
+			label = &a.Desc
+		}
+	}
+	for _, p := range []**string{&a.Sym, &a.Selector, &a.Desc} {
+		if *p == nil || !isQuoted(**p) {
+			continue
+		}
+		if p != label {
+			return fmt.Errorf("quoted string %s is only valid as menu label", **p)
+		}
+		s, err := strconv.Unquote(**p)
+		if err != nil {
+			return fmt.Errorf("invalid quoted string %s", **p)
+		}
+		if s == "" {
+			return fmt.Errorf("empty menu label")
+		}
+		if ml != nil {
+			s = ml.Add(s)
+		}
+		*p = &s
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package asm
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"git.defalsify.org/vise.git/vm"
+)
+
+func TestMenuLabelInline(t *testing.T) {
+	s := `MOUT "Go back, now!" 0
+MNEXT "Next \"page\" #2" 11
+HALT
+`
+	r := bytes.NewBuffer(nil)
+	_, err := Parse(s, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := vm.NewLine(nil, vm.MOUT, []string{"Go back, now!", "0"}, nil, nil)
+	b = vm.NewLine(b, vm.MNEXT, []string{"Next \"page\" #2", "11"}, nil, nil)
+	b = vm.NewLine(b, vm.HALT, nil, nil, nil)
+	if !bytes.Equal(r.Bytes(), b) {
+		t.Fatalf("expected %x, got %x", b, r.Bytes())
+	}
+
+	ph := vm.NewParseHandler().WithDefaultHandlers()
+	ss, err := ph.ToString(r.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ss != s {
+		t.Fatalf("expected:\n%s\ngot:\n%s", s, ss)
+	}
+}
+
+func TestMenuLabelBatch(t *testing.T) {
+	s := `DOWN foo 1 "Visit foo"
+UP 0 "Back"
+`
+	r := bytes.NewBuffer(nil)
+	_, err := Parse(s, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := vm.NewLine(nil, vm.MOUT, []string{"Visit foo", "1"}, nil, nil)
+	b = vm.NewLine(b, vm.MOUT, []string{"Back", "0"}, nil, nil)
+	b = vm.NewLine(b, vm.HALT, nil, nil, nil)
+	b = vm.NewLine(b, vm.INCMP, []string{"foo", "1"}, nil, nil)
+	b = vm.NewLine(b, vm.INCMP, []string{"_", "0"}, nil, nil)
+	if !bytes.Equal(r.Bytes(), b) {
+		t.Fatalf("expected %x, got %x", b, r.Bytes())
+	}
+}
+
+func TestMenuLabelInvalid(t *testing.T) {
+	for _, s := range []string{
+		"LOAD \"foo\" 1\n",
+		"MOUT foo \"0\"\n",
+		"DOWN \"foo\" 1 bar\n",
+		"MOUT \"\" 0\n",
+	} {
+		_, err := Parse(s, nil)
+		if err == nil {
+			t.Fatalf("expected error for %s", s)
+		}
+	}
+}
+
+func TestMenuLabelGenerated(t *testing.T) {
+	s := `.macro back label
+MOUT $label 0
+.endm
+.back "Go back # now"
+UP 1 "Go back # now"
+`
+	ml := NewMenuLabels()
+	src, diags := NewPreprocessor().WithMenuLabels(ml).Process(s, "root.vis")
+	if len(diags) > 0 {
+		t.Fatal(diags)
+	}
+	r := bytes.NewBuffer(nil)
+	_, diags = ParseSource(src, r, nil)
+	if diags.Err() != nil {
+		t.Fatal(diags.Err())
+	}
+	keys := ml.Keys()
+	if len(keys) != 1 {
+		t.Fatalf("expected one label, got %v", keys)
+	}
+	if !strings.HasPrefix(keys[0], MENU_LABEL_PREFIX) {
+		t.Fatalf("unexpected key %s", keys[0])
+	}
+	v, ok := ml.Get(keys[0])
+	if !ok || v != "Go back # now" {
+		t.Fatalf("unexpected label '%s'", v)
+	}
+	b := vm.NewLine(nil, vm.MOUT, []string{keys[0], "0"}, nil, nil)
+	b = vm.NewLine(b, vm.MOUT, []string{keys[0], "1"}, nil, nil)
+	if !bytes.HasPrefix(r.Bytes(), b) {
+		t.Fatalf("expected prefix %x, got %x", b, r.Bytes())
+	}
+}
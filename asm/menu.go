@@ -67,12 +67,30 @@ func (mp *MenuProcessor) Add(bop string, choice string, display string, target s
 	return nil
 }
 
+// menuLine is the offset of a generated instruction, and the index of the menu item it was generated from.
+type menuLine struct {
+	offset int
+	item   int
+}
+
 // ToLines returns the generated bytecode from the added menu batch instructions.
 func (mp *MenuProcessor) ToLines() []byte {
+	b, _ := mp.toLines()
+	return b
+}
+
+// generates the bytecode, and the offset and menu item of each generated instruction.
+//
+// The HALT instruction is attributed to the last menu item.
+func (mp *MenuProcessor) toLines() ([]byte, []menuLine) {
+	var preMap []menuLine
+	var postMap []menuLine
 	preLines := []byte{}
 	postLines := []byte{}
 
-	for _, v := range mp.items {
+	for i, v := range mp.items {
+		preMap = append(preMap, menuLine{offset: len(preLines), item: i})
+		postMap = append(postMap, menuLine{offset: len(postLines), item: i})
 		switch v.code {
 		case _MENU_UP:
 			preLines = vm.NewLine(preLines, vm.MOUT, []string{v.display, v.choice}, nil, nil)
@@ -89,6 +107,11 @@ func (mp *MenuProcessor) ToLines() []byte {
 		}
 	}
 
+	preMap = append(preMap, menuLine{offset: len(preLines), item: len(mp.items) - 1})
 	preLines = vm.NewLine(preLines, vm.HALT, nil, nil, nil)
-	return append(preLines, postLines...)
+	for _, v := range postMap {
+		v.offset += len(preLines)
+		preMap = append(preMap, v)
+	}
+	return append(preLines, postLines...), preMap
 }
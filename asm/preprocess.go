@@ -0,0 +1,445 @@
+package asm
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"git.defalsify.org/vise.git/vm"
+)
+
+const (
+	// Maximum depth of nested includes and macro invocations.
+	MAX_EXPANSION_DEPTH = 32
+)
+
+var (
+	nameRe = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+	refRe  = regexp.MustCompile(`\$([a-zA-Z_][a-zA-Z0-9_]*)`)
+)
+
+// Source is assembly source expanded by the Preprocessor, which remembers where each line of the expanded source was produced from.
+type Source struct {
+	file    string
+	lines   []string
+	origins []location
+	labels  *MenuLabels
+}
+
+// String returns the expanded source.
+func (src *Source) String() string {
+	if len(src.lines) == 0 {
+		return ""
+	}
+	return strings.Join(src.lines, "\n") + "\n"
+}
+
+// Pos returns the source position a line and column of the expanded source was produced from, and the expansions that produced it, innermost first.
+//
+// Columns are kept as they are, and may be off if the line contained constant or macro parameter references before that column.
+func (src *Source) Pos(line int, column int) (vm.SourcePos, []Expansion) {
+	l := src.locate(line, column)
+	return l.SourcePos, l.trace
+}
+
+// WithText returns a copy of the Source with the expanded source replaced by the given text.
+//
+// This allows further preprocessing of the expanded source, such as translation of flag names, as long as each line of the text corresponds to the same line of the expanded source.
+func (src *Source) WithText(s string) *Source {
+	return &Source{
+		file:    src.file,
+		lines:   strings.Split(strings.TrimSuffix(s, "\n"), "\n"),
+		origins: src.origins,
+		labels:  src.labels,
+	}
+}
+
+func (src *Source) locate(line int, column int) location {
+	if line < 1 || line > len(src.origins) {
+		return fileLocator(src.file)(line, column)
+	}
+	l := src.origins[line-1]
+	l.Column = column
+	return l
+}
+
+// Preprocessor expands include directives, constants and macros in assembly source.
+//
+// Directives are lines starting with a dot:
+//
+//	.include <file>
+//	.const <name> <value>
+//	.macro <name> [<param>...]
+//	.endm
+//
+// A macro is invoked with its name prefixed by a dot, followed by the arguments:
+//
+//	.<name> [<arg>...]
+//
+// Constants and macro parameters are referred to as $<name>. Macro parameters take precedence over constants with the same name.
+//
+// Included file names are relative to the directory of the including file.
+type Preprocessor struct {
+	readFunc func(string) ([]byte, error)
+	consts   map[string]string
+	labels   *MenuLabels
+}
+
+// NewPreprocessor creates a new Preprocessor, reading included files from the filesystem.
+func NewPreprocessor() *Preprocessor {
+	return &Preprocessor{
+		readFunc: os.ReadFile,
+		consts:   make(map[string]string),
+	}
+}
+
+// WithReadFunc is a chainable function that sets the function used to read included files.
+func (pp *Preprocessor) WithReadFunc(fn func(string) ([]byte, error)) *Preprocessor {
+	pp.readFunc = fn
+	return pp
+}
+
+// WithConst is a chainable function that defines a constant for all sources processed.
+func (pp *Preprocessor) WithConst(name string, value string) *Preprocessor {
+	pp.consts[name] = value
+	return pp
+}
+
+// WithMenuLabels is a chainable function that makes the sources processed assemble quoted menu labels to generated menu resource keys, registered in ml.
+//
+// By default, quoted menu labels are assembled as they are, and used as the menu label directly if the resource does not resolve them.
+func (pp *Preprocessor) WithMenuLabels(ml *MenuLabels) *Preprocessor {
+	pp.labels = ml
+	return pp
+}
+
+// Process expands the assembly source read from the given file.
+//
+// Constants and macros defined in the source are only valid for the source itself. Lines that cannot be expanded are left out, and recorded as errors in the diagnostics.
+func (pp *Preprocessor) Process(s string, file string) (*Source, Diagnostics) {
+	ex := &expander{
+		pp:     pp,
+		src:    &Source{file: file, labels: pp.labels},
+		consts: make(map[string]string),
+		macros: make(map[string]*macro),
+		files:  []string{filepath.Clean(file)},
+	}
+	for k, v := range pp.consts {
+		ex.consts[k] = v
+	}
+	ex.text(s, file, nil)
+	return ex.src, ex.diags
+}
+
+// macro is a named block of source lines, with parameters substituted when invoked.
+type macro struct {
+	params []string
+	lines  []string
+	pos    []location
+	def    location
+}
+
+// expander holds the state of a single Preprocessor.Process call.
+type expander struct {
+	pp     *Preprocessor
+	src    *Source
+	diags  Diagnostics
+	consts map[string]string
+	// where constants were defined.
+	constPos map[string]location
+	macros   map[string]*macro
+	// macro currently being defined, if any.
+	def     *macro
+	defName string
+	// include stack.
+	files []string
+}
+
+// expand all lines of a file.
+func (ex *expander) text(s string, file string, trace []Expansion) {
+	for i, v := range strings.Split(strings.TrimSuffix(s, "\n"), "\n") {
+		l := location{
+			SourcePos: vm.SourcePos{
+				File:   file,
+				Line:   i + 1,
+				Column: 1,
+			},
+			trace: trace,
+		}
+		ex.line(v, l, nil)
+	}
+	if ex.def != nil {
+		ex.diags.errorf(ex.def.def, "missing .endm for macro %s", ex.defName)
+		ex.def = nil
+	}
+}
+
+// expand a single line, with the given macro parameters.
+func (ex *expander) line(s string, l location, params map[string]string) {
+	code, _, _ := cutComment(s)
+	fields := splitFields(code)
+	if len(fields) > 0 {
+		l.Column = strings.Index(s, fields[0]) + 1
+	}
+	if ex.def != nil {
+		if len(fields) > 0 && fields[0] == ".endm" {
+			ex.def = nil
+			return
+		}
+		if len(fields) > 0 && fields[0] == ".macro" {
+			ex.diags.errorf(l, "nested macro definition in macro %s", ex.defName)
+			return
+		}
+		ex.def.lines = append(ex.def.lines, s)
+		ex.def.pos = append(ex.def.pos, l)
+		return
+	}
+	if len(fields) == 0 || !isDirective(fields[0]) {
+		r, col, err := ex.substitute(s, params)
+		if err != nil {
+			l.Column = col
+			ex.diags.errorf(l, "%v", err)
+			return
+		}
+		ex.emit(r, l)
+		return
+	}
+	if fields[0] == ".macro" {
+		ex.define(fields[1:], l)
+		return
+	}
+	r, col, err := ex.substitute(code, params)
+	if err != nil {
+		l.Column = col
+		ex.diags.errorf(l, "%v", err)
+		return
+	}
+	fields = splitFields(r)
+	if len(fields) == 0 {
+		return
+	}
+	switch fields[0] {
+	case ".include":
+		ex.include(fields[1:], l)
+	case ".const":
+		ex.defineConst(fields[1:], l)
+	case ".endm":
+		ex.diags.errorf(l, ".endm without .macro")
+	default:
+		ex.invoke(fields[0][1:], fields[1:], l)
+	}
+}
+
+// add a line to the expanded source.
+func (ex *expander) emit(s string, l location) {
+	ex.src.lines = append(ex.src.lines, s)
+	l.line = len(ex.src.lines)
+	ex.src.origins = append(ex.src.origins, l)
+}
+
+// replace references to constants and macro parameters.
+//
+// On error, the column of the offending reference is returned.
+func (ex *expander) substitute(s string, params map[string]string) (string, int, error) {
+	code, comment, hasComment := cutComment(s)
+	var r string
+	var last int
+	for _, m := range refRe.FindAllStringSubmatchIndex(code, -1) {
+		k := code[m[2]:m[3]]
+		v, ok := params[k]
+		if !ok {
+			v, ok = ex.consts[k]
+		}
+		if !ok {
+			return "", m[0] + 1, fmt.Errorf("undefined constant: %s", k)
+		}
+		r += code[last:m[0]] + v
+		last = m[1]
+	}
+	r += code[last:]
+	if hasComment {
+		r += "#" + comment
+	}
+	return r, 0, nil
+}
+
+func (ex *expander) include(args []string, l location) {
+	if len(args) != 1 {
+		ex.diags.errorf(l, "expected .include <file>")
+		return
+	}
+	if len(l.trace) >= MAX_EXPANSION_DEPTH {
+		ex.diags.errorf(l, "expansion too deep")
+		return
+	}
+	fp := strings.Trim(args[0], `"'`)
+	if !filepath.IsAbs(fp) {
+		fp = filepath.Join(filepath.Dir(l.File), fp)
+	}
+	for _, v := range ex.files {
+		if v == fp {
+			ex.diags.errorf(l, "include cycle: %s", strings.Join(append(ex.files, fp), " -> "))
+			return
+		}
+	}
+	b, err := ex.pp.readFunc(fp)
+	if err != nil {
+		ex.diags.errorf(l, "cannot include %s: %v", fp, err)
+		return
+	}
+	ex.files = append(ex.files, fp)
+	ex.text(string(b), fp, expand(l, ""))
+	ex.files = ex.files[:len(ex.files)-1]
+}
+
+func (ex *expander) defineConst(args []string, l location) {
+	if len(args) != 2 {
+		ex.diags.errorf(l, "expected .const <name> <value>")
+		return
+	}
+	if !nameRe.MatchString(args[0]) {
+		ex.diags.errorf(l, "invalid constant name: %s", args[0])
+		return
+	}
+	p, ok := ex.constPos[args[0]]
+	if ok {
+		ex.diags.errorf(l, "constant %s already defined at %s", args[0], p.ref(l))
+		return
+	}
+	_, ok = ex.consts[args[0]]
+	if ok {
+		ex.diags.errorf(l, "constant %s already defined", args[0])
+		return
+	}
+	if ex.constPos == nil {
+		ex.constPos = make(map[string]location)
+	}
+	ex.consts[args[0]] = args[1]
+	ex.constPos[args[0]] = l
+}
+
+func (ex *expander) define(args []string, l location) {
+	if len(args) == 0 {
+		ex.diags.errorf(l, "expected .macro <name> [<param>...]")
+		return
+	}
+	name := args[0]
+	for _, v := range args {
+		if !nameRe.MatchString(v) {
+			ex.diags.errorf(l, "invalid macro or parameter name: %s", v)
+			return
+		}
+	}
+	if isReserved(name) {
+		ex.diags.errorf(l, "macro name %s is a directive", name)
+		return
+	}
+	m, ok := ex.macros[name]
+	if ok {
+		ex.diags.errorf(l, "macro %s already defined at %s", name, m.def.ref(l))
+		return
+	}
+	ex.def = &macro{
+		params: args[1:],
+		def:    l,
+	}
+	ex.defName = name
+	ex.macros[name] = ex.def
+}
+
+func (ex *expander) invoke(name string, args []string, l location) {
+	m, ok := ex.macros[name]
+	if !ok {
+		ex.diags.errorf(l, "undefined macro or directive: .%s", name)
+		return
+	}
+	if len(args) != len(m.params) {
+		ex.diags.errorf(l, "macro %s expects %d arguments, got %d", name, len(m.params), len(args))
+		return
+	}
+	if len(l.trace) >= MAX_EXPANSION_DEPTH {
+		ex.diags.errorf(l, "expansion too deep")
+		return
+	}
+	params := make(map[string]string)
+	for i, v := range m.params {
+		params[v] = args[i]
+	}
+	trace := expand(l, name)
+	for i, v := range m.lines {
+		ml := m.pos[i]
+		ml.trace = trace
+		ex.line(v, ml, params)
+	}
+}
+
+// the trace of lines produced by an include directive or macro invocation at the given location.
+func expand(l location, name string) []Expansion {
+	e := Expansion{
+		Macro: name,
+		Pos:   l.SourcePos,
+	}
+	return append([]Expansion{e}, l.trace...)
+}
+
+// split a line at the start of a comment, ignoring comment characters in quoted strings.
+func cutComment(s string) (string, string, bool) {
+	var quoted bool
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			if quoted {
+				i++
+			}
+		case '"':
+			quoted = !quoted
+		case '#':
+			if !quoted {
+				return s[:i], s[i+1:], true
+			}
+		}
+	}
+	return s, "", false
+}
+
+// split a line into fields separated by whitespace, keeping quoted strings with whitespace as a single field.
+func splitFields(s string) []string {
+	var r []string
+	var quoted bool
+	start := -1
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c == '\\' && quoted {
+			i++
+			continue
+		}
+		if c == '"' {
+			quoted = !quoted
+		}
+		space := !quoted && (c == ' ' || c == '\t' || c == '\r')
+		if space && start >= 0 {
+			r = append(r, s[start:i])
+			start = -1
+		} else if !space && start < 0 {
+			start = i
+		}
+	}
+	if start >= 0 {
+		r = append(r, s[start:])
+	}
+	return r
+}
+
+func isDirective(s string) bool {
+	return len(s) > 1 && s[0] == '.' && !strings.HasSuffix(s, ":")
+}
+
+func isReserved(s string) bool {
+	switch s {
+	case "include", "const", "macro", "endm":
+		return true
+	}
+	return false
+}
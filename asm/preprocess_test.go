@@ -0,0 +1,141 @@
+package asm
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"git.defalsify.org/vise.git/vm"
+)
+
+func newTestPreprocessor(files map[string]string) *Preprocessor {
+	return NewPreprocessor().WithReadFunc(func(fp string) ([]byte, error) {
+		v, ok := files[fp]
+		if !ok {
+			return nil, fmt.Errorf("not found")
+		}
+		return []byte(v), nil
+	})
+}
+
+func TestPreprocess(t *testing.T) {
+	files := map[string]string{
+		"lib/menu.vis": `.const BACK 0
+.macro nav back quit
+MOUT back $BACK
+MOUT quit $quit
+HALT
+INCMP _ $BACK
+INCMP $back $quit # $back is the node to leave to
+.endm
+`,
+	}
+	s := `.include "lib/menu.vis"
+.const SIZE 32
+LOAD foo $SIZE
+MAP foo
+.nav bar 9
+`
+	pp := newTestPreprocessor(files)
+	src, diags := pp.Process(s, "root.vis")
+	if len(diags) > 0 {
+		t.Fatal(diags)
+	}
+	expect := `LOAD foo 32
+MAP foo
+MOUT back 0
+MOUT quit 9
+HALT
+INCMP _ 0
+INCMP bar 9 # $back is the node to leave to
+`
+	if src.String() != expect {
+		t.Fatalf("expected:\n%s\ngot:\n%s", expect, src)
+	}
+
+	pos, trace := src.Pos(4, 1)
+	if pos.String() != "lib/menu.vis:4:1" {
+		t.Fatalf("unexpected position %v", pos)
+	}
+	if len(trace) != 1 || trace[0].String() != "in macro nav expanded at root.vis:5:1" {
+		t.Fatalf("unexpected trace %v", trace)
+	}
+
+	r := bytes.NewBuffer(nil)
+	sm := vm.NewSourceMap()
+	_, diags = ParseSource(src, r, sm)
+	if diags.Err() != nil {
+		t.Fatal(diags.Err())
+	}
+	rr := bytes.NewBuffer(nil)
+	_, err := Parse(expect, rr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(r.Bytes(), rr.Bytes()) {
+		t.Fatalf("expected %x, got %x", rr.Bytes(), r.Bytes())
+	}
+	p, ok := sm.Lookup(0)
+	if !ok || p.String() != "root.vis:3:1" {
+		t.Fatalf("unexpected source map position %v", p)
+	}
+}
+
+func TestPreprocessDiagnostics(t *testing.T) {
+	files := map[string]string{
+		"a.vis": ".include b.vis\n",
+		"b.vis": ".include a.vis\n",
+	}
+	s := `.const SIZE 32
+.const SIZE 64
+LOAD foo $NOSUCH
+.nosuch
+.macro sel s
+INCMP foo $s
+.endm
+.sel
+.include a.vis
+.macro open
+`
+	pp := newTestPreprocessor(files)
+	_, diags := pp.Process(s, "root.vis")
+	expect := []string{
+		"root.vis:2:1: error: constant SIZE already defined at line 1",
+		"root.vis:3:10: error: undefined constant: NOSUCH",
+		"root.vis:4:1: error: undefined macro or directive: .nosuch",
+		"root.vis:8:1: error: macro sel expects 1 arguments, got 0",
+		"b.vis:1:1: error: include cycle: root.vis -> a.vis -> b.vis -> a.vis\n\tincluded from a.vis:1:1\n\tincluded from root.vis:9:1",
+		"root.vis:10:1: error: missing .endm for macro open",
+	}
+	if len(diags) != len(expect) {
+		t.Fatalf("expected %d diagnostics, got:\n%v", len(expect), diags)
+	}
+	for i, v := range diags {
+		if v.Error() != expect[i] {
+			t.Fatalf("expected '%s', got '%s'", expect[i], v.Error())
+		}
+	}
+}
+
+func TestPreprocessAssemblerTrace(t *testing.T) {
+	s := `.macro choice sel target
+INCMP $target $sel
+.endm
+HALT
+.choice 1 foo
+.choice 1 bar
+`
+	src, diags := NewPreprocessor().Process(s, "root.vis")
+	if len(diags) > 0 {
+		t.Fatal(diags)
+	}
+	_, diags = ParseSource(src, nil, nil)
+	if len(diags) != 1 {
+		t.Fatalf("expected 1 diagnostic, got:\n%v", diags)
+	}
+	expect := "root.vis:2:1: warning: duplicate INCMP selector 1, first used at line 2; it will never match\n\tin macro choice expanded at root.vis:6:1"
+	if !strings.HasPrefix(diags[0].Error(), expect) {
+		t.Fatalf("expected '%s', got '%s'", expect, diags[0].Error())
+	}
+}
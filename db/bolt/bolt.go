@@ -0,0 +1,282 @@
+package bolt
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+
+	bbolt "go.etcd.io/bbolt"
+
+	"git.defalsify.org/vise.git/db"
+)
+
+var (
+	bucketName = []byte("vise")
+)
+
+// boltDb is a bbolt backend implementation of the Db interface.
+type boltDb struct {
+	*db.DbBase
+	conn     *bbolt.DB
+	readOnly bool
+	tx       *bbolt.Tx
+}
+
+// NewBoltDb creates a new bbolt backed Db implementation.
+func NewBoltDb() *boltDb {
+	db := &boltDb{
+		DbBase: db.NewDbBase(),
+	}
+	return db
+}
+
+// WithReadOnly sets database as read only.
+//
+// There may exist more than one instance of read-only
+// databases to the same file at the same time.
+// However, only one single write database.
+//
+// Readonly cannot be set when creating a new database.
+func (bdb *boltDb) WithReadOnly() *boltDb {
+	bdb.readOnly = true
+	return bdb
+}
+
+// String implements the string interface.
+func (bdb *boltDb) String() string {
+	return "boltdb: " + bdb.Connection()
+}
+
+// Connect implements Db
+func (bdb *boltDb) Connect(ctx context.Context, connStr string) error {
+	if bdb.conn != nil {
+		logg.WarnCtxf(ctx, "already connected", "conn", bdb.conn)
+		return nil
+	}
+	opts := &bbolt.Options{
+		ReadOnly: bdb.readOnly,
+	}
+	conn, err := bbolt.Open(connStr, 0600, opts)
+	if err != nil {
+		return fmt.Errorf("db open err: %v", err)
+	}
+	if bdb.readOnly {
+		err = conn.View(func(tx *bbolt.Tx) error {
+			if tx.Bucket(bucketName) == nil {
+				return fmt.Errorf("cannot open new database readonly")
+			}
+			return nil
+		})
+	} else {
+		err = conn.Update(func(tx *bbolt.Tx) error {
+			_, err := tx.CreateBucketIfNotExists(bucketName)
+			return err
+		})
+	}
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	logg.DebugCtxf(ctx, "bolt connected", "connstr", connStr)
+	bdb.conn = conn
+	bdb.DbBase.Connect(ctx, connStr)
+	return nil
+}
+
+// Start implements Db.
+func (bdb *boltDb) Start(ctx context.Context) error {
+	if bdb.tx != nil {
+		return db.ErrTxExist
+	}
+	tx, err := bdb.conn.Begin(!bdb.readOnly)
+	logg.TraceCtxf(ctx, "begin tx", "err", err)
+	if err != nil {
+		return err
+	}
+	bdb.tx = tx
+	return nil
+}
+
+// Stop implements Db.
+func (bdb *boltDb) Stop(ctx context.Context) error {
+	var err error
+	if bdb.tx == nil {
+		return db.ErrNoTx
+	}
+	if bdb.tx.Writable() {
+		err = bdb.tx.Commit()
+	} else {
+		err = bdb.tx.Rollback()
+	}
+	logg.TraceCtxf(ctx, "stop tx", "err", err)
+	bdb.tx = nil
+	return err
+}
+
+// Abort implements Db.
+func (bdb *boltDb) Abort(ctx context.Context) {
+	logg.InfoCtxf(ctx, "aborting tx", "tx", bdb.tx)
+	if bdb.tx != nil {
+		bdb.tx.Rollback()
+	}
+	bdb.tx = nil
+}
+
+// run a read operation, within the current transaction if one exists.
+func (bdb *boltDb) view(fn func(b *bbolt.Bucket) error) error {
+	if bdb.tx != nil {
+		return fn(bdb.tx.Bucket(bucketName))
+	}
+	return bdb.conn.View(func(tx *bbolt.Tx) error {
+		return fn(tx.Bucket(bucketName))
+	})
+}
+
+// run a write operation, within the current transaction if one exists.
+//
+// If the operation fails within a transaction, the transaction is aborted.
+func (bdb *boltDb) update(ctx context.Context, fn func(b *bbolt.Bucket) error) error {
+	if bdb.readOnly {
+		return errors.New("database is read only")
+	}
+	if bdb.tx != nil {
+		err := fn(bdb.tx.Bucket(bucketName))
+		if err != nil && !db.IsConflict(err) && !db.IsNotFound(err) {
+			bdb.Abort(ctx)
+		}
+		return err
+	}
+	return bdb.conn.Update(func(tx *bbolt.Tx) error {
+		return fn(tx.Bucket(bucketName))
+	})
+}
+
+// the key to write to for the current language context.
+func (bdb *boltDb) actualKey(lk db.LookupKey) []byte {
+	if lk.Translation != nil {
+		return lk.Translation
+	}
+	return lk.Default
+}
+
+// Put implements Db
+func (bdb *boltDb) Put(ctx context.Context, key []byte, val []byte) error {
+	if !bdb.CheckPut() {
+		return errors.New("unsafe put and safety set")
+	}
+	lk, err := bdb.ToKey(ctx, key)
+	if err != nil {
+		return err
+	}
+	logg.TraceCtxf(ctx, "bolt put", "key", key, "lk", lk, "val", val)
+	return bdb.update(ctx, func(b *bbolt.Bucket) error {
+		return b.Put(bdb.actualKey(lk), val)
+	})
+}
+
+// CompareAndSwap implements Db
+func (bdb *boltDb) CompareAndSwap(ctx context.Context, key []byte, old []byte, val []byte) error {
+	if !bdb.CheckPut() {
+		return errors.New("unsafe put and safety set")
+	}
+	lk, err := bdb.ToKey(ctx, key)
+	if err != nil {
+		return err
+	}
+	logg.TraceCtxf(ctx, "bolt cas", "key", key, "lk", lk, "val", val)
+	return bdb.update(ctx, func(b *bbolt.Bucket) error {
+		k := bdb.actualKey(lk)
+		v := b.Get(k)
+		if old == nil {
+			if v != nil {
+				return db.NewErrConflict(key)
+			}
+		} else if v == nil || !bytes.Equal(v, old) {
+			return db.NewErrConflict(key)
+		}
+		return b.Put(k, val)
+	})
+}
+
+// Delete implements Db
+func (bdb *boltDb) Delete(ctx context.Context, key []byte) error {
+	if !bdb.CheckPut() {
+		return errors.New("unsafe delete and safety set")
+	}
+	lk, err := bdb.ToKey(ctx, key)
+	if err != nil {
+		return err
+	}
+	logg.TraceCtxf(ctx, "bolt delete", "key", key, "lk", lk)
+	return bdb.update(ctx, func(b *bbolt.Bucket) error {
+		k := bdb.actualKey(lk)
+		if b.Get(k) == nil {
+			return db.NewErrNotFound(key)
+		}
+		return b.Delete(k)
+	})
+}
+
+// CompareAndDelete implements Db.
+func (bdb *boltDb) CompareAndDelete(ctx context.Context, key []byte, old []byte) error {
+	if !bdb.CheckPut() {
+		return errors.New("unsafe delete and safety set")
+	}
+	lk, err := bdb.ToKey(ctx, key)
+	if err != nil {
+		return err
+	}
+	logg.TraceCtxf(ctx, "bolt cad", "key", key, "lk", lk)
+	return bdb.update(ctx, func(b *bbolt.Bucket) error {
+		k := bdb.actualKey(lk)
+		v := b.Get(k)
+		if v == nil || !bytes.Equal(v, old) {
+			return db.NewErrConflict(key)
+		}
+		return b.Delete(k)
+	})
+}
+
+// Get implements Db
+func (bdb *boltDb) Get(ctx context.Context, key []byte) ([]byte, error) {
+	var v []byte
+	lk, err := bdb.ToKey(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	err = bdb.view(func(b *bbolt.Bucket) error {
+		if lk.Translation != nil {
+			r := b.Get(lk.Translation)
+			if r != nil {
+				v = bytes.Clone(r)
+				return nil
+			}
+		}
+		r := b.Get(lk.Default)
+		if r == nil {
+			return db.NewErrNotFound(key)
+		}
+		v = bytes.Clone(r)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	logg.TraceCtxf(ctx, "bolt get", "key", key, "lk", lk, "val", v)
+	return v, nil
+}
+
+// Close implements Db
+func (bdb *boltDb) Close(ctx context.Context) error {
+	logg.TraceCtxf(ctx, "closing bolt", "path", bdb.Connection())
+	err := bdb.Stop(ctx)
+	if err == db.ErrNoTx {
+		err = nil
+	}
+	cerr := bdb.conn.Close()
+	if err != nil {
+		return err
+	}
+	return cerr
+}
@@ -0,0 +1,172 @@
+package bolt
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"path"
+	"testing"
+
+	"git.defalsify.org/vise.git/db"
+	"git.defalsify.org/vise.git/db/dbtest"
+)
+
+func newTestDb(t *testing.T) *boltDb {
+	ctx := context.Background()
+	d, err := ioutil.TempDir("", "vise-db-bolt-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := NewBoltDb()
+	err = store.Connect(ctx, path.Join(d, "vise.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func TestCasesBolt(t *testing.T) {
+	ctx := context.Background()
+	store := newTestDb(t)
+	err := dbtest.RunTests(t, ctx, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPutGetBolt(t *testing.T) {
+	var dbi db.Db
+	ctx := context.Background()
+	store := newTestDb(t)
+	store.SetPrefix(db.DATATYPE_USERDATA)
+	store.SetSession("ses")
+
+	dbi = store
+	_ = dbi
+
+	err := store.Put(ctx, []byte("foo"), []byte("bar"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := store.Get(ctx, []byte("foo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(v, []byte("bar")) {
+		t.Fatalf("expected value 'bar', found '%s'", v)
+	}
+	err = store.Put(ctx, []byte("foo"), []byte("baz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err = store.Get(ctx, []byte("foo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(v, []byte("baz")) {
+		t.Fatalf("expected value 'baz', found '%s'", v)
+	}
+	_, err = store.Get(ctx, []byte("bar"))
+	if !db.IsNotFound(err) {
+		t.Fatalf("expected not found for key 'bar', got %v", err)
+	}
+	err = store.Close(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTxBolt(t *testing.T) {
+	ctx := context.Background()
+	store := newTestDb(t)
+	store.SetPrefix(db.DATATYPE_USERDATA)
+
+	err := store.Start(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.Start(ctx)
+	if err != db.ErrTxExist {
+		t.Fatalf("expected ErrTxExist, got %v", err)
+	}
+	err = store.Put(ctx, []byte("foo"), []byte("inky"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.Put(ctx, []byte("bar"), []byte("pinky"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Abort(ctx)
+	_, err = store.Get(ctx, []byte("foo"))
+	if !db.IsNotFound(err) {
+		t.Fatalf("expected not found after abort, got %v", err)
+	}
+
+	err = store.Start(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.Put(ctx, []byte("foo"), []byte("inky"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.Put(ctx, []byte("bar"), []byte("pinky"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.Stop(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.Stop(ctx)
+	if err != db.ErrNoTx {
+		t.Fatalf("expected ErrNoTx, got %v", err)
+	}
+	v, err := store.Get(ctx, []byte("bar"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(v, []byte("pinky")) {
+		t.Fatalf("expected value 'pinky', found '%s'", v)
+	}
+}
+
+func TestReadOnlyBolt(t *testing.T) {
+	ctx := context.Background()
+	store := newTestDb(t)
+	fp := store.Connection()
+	store.SetPrefix(db.DATATYPE_USERDATA)
+	err := store.Put(ctx, []byte("foo"), []byte("inky"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.Close(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store = NewBoltDb().WithReadOnly()
+	err = store.Connect(ctx, fp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.SetPrefix(db.DATATYPE_USERDATA)
+	v, err := store.Get(ctx, []byte("foo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(v, []byte("inky")) {
+		t.Fatalf("expected value 'inky', found '%s'", v)
+	}
+	err = store.Put(ctx, []byte("foo"), []byte("pinky"))
+	if err == nil {
+		t.Fatal("expected error on readonly put")
+	}
+
+	store = NewBoltDb().WithReadOnly()
+	err = store.Connect(ctx, path.Join(path.Dir(fp), "new.db"))
+	if err == nil {
+		t.Fatal("expected error on readonly open of new database")
+	}
+}
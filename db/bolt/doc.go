@@ -0,0 +1,4 @@
+// Package bolt is a bbolt embedded database backed implementation of the db.Db interface.
+//
+// Unlike the gdbm implementation it is pure go, and supports transactions.
+package bolt
@@ -0,0 +1,46 @@
+package bolt
+
+import (
+	"bytes"
+	"context"
+
+	bbolt "go.etcd.io/bbolt"
+
+	"git.defalsify.org/vise.git/db"
+)
+
+// Dump implements Db.
+//
+// All matching entries are retrieved before the method returns, since the values are only valid for the lifetime of the bbolt transaction.
+func (bdb *boltDb) Dump(ctx context.Context, key []byte) (*db.Dumper, error) {
+	var ks [][]byte
+	var vs [][]byte
+
+	bdb.SetLanguage(nil)
+	lk, err := bdb.ToKey(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	key = lk.Default
+
+	err = bdb.view(func(b *bbolt.Bucket) error {
+		c := b.Cursor()
+		for k, v := c.Seek(key); k != nil && bytes.HasPrefix(k, key); k, v = c.Next() {
+			kk, err := bdb.DecodeKey(ctx, k)
+			if err != nil {
+				return err
+			}
+			ks = append(ks, bytes.Clone(kk))
+			vs = append(vs, bytes.Clone(v))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ks) == 0 {
+		return nil, db.NewErrNotFound(key)
+	}
+	return db.NewSliceDumper(ks, vs), nil
+}
@@ -30,6 +30,11 @@ const (
 	DATATYPE_USERDATA = 32
 )
 
+const (
+	// Key suffix of the source map of bytecode, stored under DATATYPE_BIN next to the bytecode itself.
+	SOURCEMAP_SUFFIX = ".map"
+)
+
 const (
 	datatype_sessioned_threshold = DATATYPE_STATICLOAD
 )
@@ -52,6 +57,20 @@ type Db interface {
 	//
 	// Errors if the value could not be stored.
 	Put(ctx context.Context, key []byte, val []byte) error
+	// CompareAndSwap stores a value under a key only if the currently stored value matches the given old value.
+	//
+	// If old is nil, the value will only be stored if the key does not exist.
+	//
+	// Errors with ErrConflict if the stored value does not match, or if the value could not be stored.
+	CompareAndSwap(ctx context.Context, key []byte, old []byte, val []byte) error
+	// CompareAndDelete removes the value stored under a key only if it matches the given old value.
+	//
+	// Errors with ErrConflict if the stored value does not match, if the key does not exist, or if the value could not be removed.
+	CompareAndDelete(ctx context.Context, key []byte, old []byte) error
+	// Delete removes the value stored under a key.
+	//
+	// Errors with ErrNotFound if the key does not exist, or if the value could not be removed.
+	Delete(ctx context.Context, key []byte) error
 	// SetPrefix sets the storage context prefix to use for consecutive Get and Put operations.
 	SetPrefix(pfx uint8)
 	// SetSession sets the session context to use for consecutive Get and Put operations.
@@ -31,6 +31,21 @@ func TestDbBase(t *testing.T) {
 	}
 }
 
+func TestDbBaseUserData(t *testing.T) {
+	store := NewDbBase()
+	store.SetSession("inky")
+	for _, pfx := range []uint8{DATATYPE_USERDATA + 1, DATATYPE_USERDATA * 2} {
+		k := store.ToSessionKey(pfx, []byte("foo"))
+		if !bytes.Equal(k, []byte("inky.foo")) {
+			t.Fatalf("expected session key for prefix %d, got '%s'", pfx, k)
+		}
+	}
+	store.SetPrefix(DATATYPE_USERDATA * 2)
+	if !store.CheckPut() {
+		t.Fatal("expected checkput true")
+	}
+}
+
 func TestDbKeyLanguage(t *testing.T) {
 	ctx := context.Background()
 	store := NewDbBase()
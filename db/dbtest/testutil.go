@@ -291,6 +291,128 @@ func runTest(t *testing.T, ctx context.Context, db db.Db, vs testVector) error {
 
 }
 
+func runCompareAndSwapTest(t *testing.T, ctx context.Context, store db.Db) error {
+	r := t.Run("TestCompareAndSwap", func(t *testing.T) {
+		k := []byte("cas")
+		store.SetPrefix(db.DATATYPE_STATE)
+		store.SetSession("casses")
+		store.SetLanguage(nil)
+		store.SetLock(db.DATATYPE_STATE, false)
+		defer store.SetLock(db.DATATYPE_STATE, true)
+		err := store.CompareAndSwap(ctx, k, nil, []byte("inky"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = store.CompareAndSwap(ctx, k, nil, []byte("pinky"))
+		if !db.IsConflict(err) {
+			t.Fatalf("expected conflict on existing key, got %v", err)
+		}
+		err = store.CompareAndSwap(ctx, k, []byte("pinky"), []byte("blinky"))
+		if !db.IsConflict(err) {
+			t.Fatalf("expected conflict on changed value, got %v", err)
+		}
+		err = store.CompareAndSwap(ctx, k, []byte("inky"), []byte("clyde"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		v, err := store.Get(ctx, k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(v, []byte("clyde")) {
+			t.Fatalf("expected 'clyde', got '%s'", v)
+		}
+		err = store.CompareAndSwap(ctx, []byte("nocas"), []byte("inky"), []byte("pinky"))
+		if !db.IsConflict(err) {
+			t.Fatalf("expected conflict on missing key, got %v", err)
+		}
+	})
+	if !r {
+		return errors.New("subtest fail")
+	}
+	return nil
+}
+
+func runCompareAndDeleteTest(t *testing.T, ctx context.Context, store db.Db) error {
+	r := t.Run("TestCompareAndDelete", func(t *testing.T) {
+		k := []byte("cad")
+		store.SetPrefix(db.DATATYPE_STATE)
+		store.SetSession("cads")
+		store.SetLanguage(nil)
+		store.SetLock(db.DATATYPE_STATE, false)
+		defer store.SetLock(db.DATATYPE_STATE, true)
+		err := store.Put(ctx, k, []byte("inky"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = store.CompareAndDelete(ctx, k, []byte("pinky"))
+		if !db.IsConflict(err) {
+			t.Fatalf("expected conflict on changed value, got %v", err)
+		}
+		_, err = store.Get(ctx, k)
+		if err != nil {
+			t.Fatalf("expected value kept after conflict, got %v", err)
+		}
+		err = store.CompareAndDelete(ctx, k, []byte("inky"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = store.Get(ctx, k)
+		if !db.IsNotFound(err) {
+			t.Fatalf("expected not found after delete, got %v", err)
+		}
+		err = store.CompareAndDelete(ctx, k, []byte("inky"))
+		if !db.IsConflict(err) {
+			t.Fatalf("expected conflict on missing key, got %v", err)
+		}
+	})
+	if !r {
+		return errors.New("subtest fail")
+	}
+	return nil
+}
+
+func runDeleteTest(t *testing.T, ctx context.Context, store db.Db) error {
+	r := t.Run("TestDelete", func(t *testing.T) {
+		k := []byte("deleteme")
+		store.SetPrefix(db.DATATYPE_STATE)
+		store.SetSession("deletes")
+		store.SetLanguage(nil)
+		store.SetLock(db.DATATYPE_STATE, false)
+		defer store.SetLock(db.DATATYPE_STATE, true)
+		err := store.Put(ctx, k, []byte("inky"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		store.SetLock(db.DATATYPE_STATE, true)
+		err = store.Delete(ctx, k)
+		if err == nil {
+			t.Fatal("expected error on locked delete")
+		}
+		_, err = store.Get(ctx, k)
+		if err != nil {
+			t.Fatalf("expected value to remain after locked delete, got %v", err)
+		}
+		store.SetLock(db.DATATYPE_STATE, false)
+		err = store.Delete(ctx, k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = store.Get(ctx, k)
+		if !db.IsNotFound(err) {
+			t.Fatalf("expected not found after delete, got %v", err)
+		}
+		err = store.Delete(ctx, k)
+		if !db.IsNotFound(err) {
+			t.Fatalf("expected not found on repeated delete, got %v", err)
+		}
+	})
+	if !r {
+		return errors.New("subtest fail")
+	}
+	return nil
+}
+
 func runTests(t *testing.T, ctx context.Context, db db.Db) error {
 	for _, fn := range tests {
 		err := runTest(t, ctx, db, fn())
@@ -299,7 +421,15 @@ func runTests(t *testing.T, ctx context.Context, db db.Db) error {
 		}
 	}
 
-	return nil
+	err := runCompareAndSwapTest(t, ctx, db)
+	if err != nil {
+		return err
+	}
+	err = runCompareAndDeleteTest(t, ctx, db)
+	if err != nil {
+		return err
+	}
+	return runDeleteTest(t, ctx, db)
 }
 
 func RunTests(t *testing.T, ctx context.Context, db db.Db) error {
@@ -54,3 +54,17 @@ func (d *Dumper) Close() error {
 	}
 	return nil
 }
+
+// NewSliceDumper creates a Dumper over already retrieved keys and values.
+//
+// The slices must be non-empty and of equal length.
+func NewSliceDumper(ks [][]byte, vs [][]byte) *Dumper {
+	i := 1
+	return NewDumper(func(ctx context.Context) ([]byte, []byte) {
+		if i == len(ks) {
+			return nil, nil
+		}
+		i++
+		return ks[i-1], vs[i-1]
+	}).WithFirst(ks[0], vs[0])
+}
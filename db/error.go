@@ -8,6 +8,7 @@ import (
 
 const (
 	notFoundPrefix = "key not found: "
+	conflictPrefix = "value changed for key: "
 )
 
 var (
@@ -39,3 +40,30 @@ func IsNotFound(err error) bool {
 	target := ErrNotFound{}
 	return target.Is(err)
 }
+
+// ErrConflict is returned by CompareAndSwap when the stored value does not match the expected value.
+type ErrConflict struct {
+	k []byte
+}
+
+// NewErrConflict creates a new ErrConflict with the given storage key.
+func NewErrConflict(k []byte) error {
+	return ErrConflict{k}
+}
+
+// Error implements Error.
+func (e ErrConflict) Error() string {
+	return fmt.Sprintf("%s%x", conflictPrefix, e.k)
+}
+
+func (e ErrConflict) Is(err error) bool {
+	if err == nil {
+		return false
+	}
+	return strings.Contains(err.Error(), conflictPrefix)
+}
+
+func IsConflict(err error) bool {
+	target := ErrConflict{}
+	return target.Is(err)
+}
@@ -1,6 +1,7 @@
 package fs
 
 import (
+	"bytes"
 	"context"
 	"encoding/base64"
 	"errors"
@@ -9,6 +10,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path"
+	"strings"
 
 	"git.defalsify.org/vise.git/db"
 )
@@ -152,6 +154,108 @@ func (fdb *fsDb) Put(ctx context.Context, key []byte, val []byte) error {
 	return ioutil.WriteFile(flk.Default, val, 0600)
 }
 
+// CompareAndSwap implements the Db interface.
+//
+// The comparison and write are not atomic across processes sharing the same directory.
+func (fdb *fsDb) CompareAndSwap(ctx context.Context, key []byte, old []byte, val []byte) error {
+	if !fdb.CheckPut() {
+		return errors.New("unsafe put and safety set")
+	}
+	lk, err := fdb.ToKey(ctx, key)
+	if err != nil {
+		return err
+	}
+	flk, err := fdb.pathFor(ctx, &lk)
+	if err != nil {
+		return err
+	}
+	fp := flk.Default
+	if flk.Translation != "" {
+		fp = flk.Translation
+	}
+	v, err := ioutil.ReadFile(fp)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		if old != nil {
+			return db.NewErrConflict(key)
+		}
+	} else if old == nil || !bytes.Equal(v, old) {
+		return db.NewErrConflict(key)
+	}
+	logg.TraceCtxf(ctx, "fs cas", "key", key, "lk", lk, "flk", flk, "val", val)
+	return ioutil.WriteFile(fp, val, 0600)
+}
+
+// Delete implements the Db interface.
+func (fdb *fsDb) Delete(ctx context.Context, key []byte) error {
+	if !fdb.CheckPut() {
+		return errors.New("unsafe delete and safety set")
+	}
+	lk, err := fdb.ToKey(ctx, key)
+	if err != nil {
+		return err
+	}
+	flk, err := fdb.pathFor(ctx, &lk)
+	if err != nil {
+		return err
+	}
+	fp := flk.Default
+	if flk.Translation != "" {
+		fp = flk.Translation
+	}
+	logg.TraceCtxf(ctx, "fs delete", "key", key, "lk", lk, "flk", flk)
+	err = os.Remove(fp)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return db.NewErrNotFound(key)
+		}
+		return err
+	}
+	return nil
+}
+
+// CompareAndDelete implements the Db interface.
+//
+// As with CompareAndSwap, the comparison and removal are not atomic.
+func (fdb *fsDb) CompareAndDelete(ctx context.Context, key []byte, old []byte) error {
+	if !fdb.CheckPut() {
+		return errors.New("unsafe delete and safety set")
+	}
+	lk, err := fdb.ToKey(ctx, key)
+	if err != nil {
+		return err
+	}
+	flk, err := fdb.pathFor(ctx, &lk)
+	if err != nil {
+		return err
+	}
+	fp := flk.Default
+	if flk.Translation != "" {
+		fp = flk.Translation
+	}
+	v, err := ioutil.ReadFile(fp)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return db.NewErrConflict(key)
+		}
+		return err
+	}
+	if !bytes.Equal(v, old) {
+		return db.NewErrConflict(key)
+	}
+	logg.TraceCtxf(ctx, "fs cad", "key", key, "lk", lk, "flk", flk)
+	err = os.Remove(fp)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return db.NewErrConflict(key)
+		}
+		return err
+	}
+	return nil
+}
+
 // Close implements the Db interface.
 func (fdb *fsDb) Close(ctx context.Context) error {
 	return nil
@@ -173,14 +277,14 @@ func (fdb *fsDb) pathFor(ctx context.Context, lk *db.LookupKey) (fsLookupKey, er
 func (fdb *fsDb) altPathFor(ctx context.Context, lk *db.LookupKey) (fsLookupKey, error) {
 	var flk fsLookupKey
 	fb := string(lk.Default[1:])
-	if fdb.Prefix() == db.DATATYPE_BIN {
+	if fdb.Prefix() == db.DATATYPE_BIN && !strings.HasSuffix(fb, db.SOURCEMAP_SUFFIX) {
 		fb += ".bin"
 	}
 	flk.Default = path.Join(fdb.dir, fb)
 
 	if lk.Translation != nil {
 		fb = string(lk.Translation[1:])
-		if fdb.Prefix() == db.DATATYPE_BIN {
+		if fdb.Prefix() == db.DATATYPE_BIN && !strings.HasSuffix(fb, db.SOURCEMAP_SUFFIX) {
 			fb += ".bin"
 		}
 		flk.Translation = path.Join(fdb.dir, fb)
@@ -188,3 +188,106 @@ func TestNoKey(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestFallbackDumpFs(t *testing.T) {
+	ctx := context.Background()
+
+	store := NewFsDb()
+	d, err := ioutil.TempDir("", "vise-db-fs-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.Connect(ctx, d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := NewFsDb()
+	d, err = ioutil.TempDir("", "vise-db-fs-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = other.Connect(ctx, d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other.SetPrefix(db.DATATYPE_USERDATA)
+	other.SetSession("xyzzy")
+	err = other.Put(ctx, []byte("foo"), []byte("bar"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = other.Put(ctx, []byte("baz"), []byte("inky"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fdb := db.NewFallbackDb(store, other)
+	fdb.SetPrefix(db.DATATYPE_USERDATA)
+	fdb.SetSession("xyzzy")
+	err = fdb.Put(ctx, []byte("foo"), []byte("pinky"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = fdb.Put(ctx, []byte("blinky"), []byte("clyde"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dmp, err := fdb.Dump(ctx, []byte(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range [][2]string{
+		{"baz", "inky"},
+		{"blinky", "clyde"},
+		{"foo", "pinky"},
+	} {
+		k, v := dmp.Next(ctx)
+		if !bytes.Equal(k, []byte(r[0])) || !bytes.Equal(v, []byte(r[1])) {
+			t.Fatalf("expected '%s' -> '%s', got '%s' -> '%s'", r[0], r[1], k, v)
+		}
+	}
+	k, _ := dmp.Next(ctx)
+	if k != nil {
+		t.Fatalf("expected end of dump, got '%s'", k)
+	}
+}
+
+func TestGetFsSourceMapAlt(t *testing.T) {
+	ctx := context.Background()
+	sid := "zezion"
+	d, err := ioutil.TempDir("", "vise-db-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := NewFsDb()
+	store.SetPrefix(db.DATATYPE_BIN)
+	store.SetSession(sid)
+	store.Connect(ctx, d)
+
+	b := []byte("0 inky.vis:1:1\n")
+	err = ioutil.WriteFile(path.Join(d, "inky.map"), b, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bc := []byte("pinky blinky clyde")
+	err = ioutil.WriteFile(path.Join(d, "inky.bin"), bc, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := store.Get(ctx, []byte("inky"+db.SOURCEMAP_SUFFIX))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(v, b) {
+		t.Fatalf("expected %x, got %x", b, v)
+	}
+	v, err = store.Get(ctx, []byte("inky"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(v, bc) {
+		t.Fatalf("expected %x, got %x", bc, v)
+	}
+}
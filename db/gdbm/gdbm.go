@@ -1,10 +1,12 @@
 package gdbm
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
 	"os"
+	"sync"
 
 	gdbm "github.com/graygnuorg/go-gdbm"
 
@@ -19,6 +21,7 @@ type gdbmDb struct {
 	prefix   uint8
 	it       gdbm.DatabaseIterator
 	itBase   []byte
+	mu       sync.Mutex // serializes writes through this handle
 }
 
 // Creates a new gdbm backed Db implementation.
@@ -93,6 +96,8 @@ func (gdb *gdbmDb) Put(ctx context.Context, key []byte, val []byte) error {
 	if !gdb.CheckPut() {
 		return errors.New("unsafe put and safety set")
 	}
+	gdb.mu.Lock()
+	defer gdb.mu.Unlock()
 	lk, err := gdb.ToKey(ctx, key)
 	if err != nil {
 		return err
@@ -132,6 +137,102 @@ func (gdb *gdbmDb) Get(ctx context.Context, key []byte) ([]byte, error) {
 	return v, nil
 }
 
+// CompareAndSwap implements Db
+//
+// The compare and the store are atomic only with respect to writes through the same handle, which gdbm requires to be the single writer of the database file.
+func (gdb *gdbmDb) CompareAndSwap(ctx context.Context, key []byte, old []byte, val []byte) error {
+	if !gdb.CheckPut() {
+		return errors.New("unsafe put and safety set")
+	}
+	gdb.mu.Lock()
+	defer gdb.mu.Unlock()
+	lk, err := gdb.ToKey(ctx, key)
+	if err != nil {
+		return err
+	}
+	k := lk.Default
+	if lk.Translation != nil {
+		k = lk.Translation
+	}
+	v, err := gdb.conn.Fetch(k)
+	if err != nil {
+		if !errors.Is(gdbm.ErrItemNotFound, err) {
+			return err
+		}
+		if old != nil {
+			return db.NewErrConflict(key)
+		}
+	} else if old == nil || !bytes.Equal(v, old) {
+		return db.NewErrConflict(key)
+	}
+	logg.TraceCtxf(ctx, "gdbm cas", "key", key, "lk", lk, "val", val)
+	return gdb.conn.Store(k, val, true)
+}
+
+// Delete implements Db
+func (gdb *gdbmDb) Delete(ctx context.Context, key []byte) error {
+	if !gdb.CheckPut() {
+		return errors.New("unsafe delete and safety set")
+	}
+	gdb.mu.Lock()
+	defer gdb.mu.Unlock()
+	lk, err := gdb.ToKey(ctx, key)
+	if err != nil {
+		return err
+	}
+	k := lk.Default
+	if lk.Translation != nil {
+		k = lk.Translation
+	}
+	logg.TraceCtxf(ctx, "gdbm delete", "key", key, "lk", lk)
+	err = gdb.conn.Delete(k)
+	if err != nil {
+		if errors.Is(gdbm.ErrItemNotFound, err) {
+			return db.NewErrNotFound(key)
+		}
+		return err
+	}
+	return nil
+}
+
+// CompareAndDelete implements Db.
+//
+// As with CompareAndSwap, atomicity holds with respect to writes through the same handle.
+func (gdb *gdbmDb) CompareAndDelete(ctx context.Context, key []byte, old []byte) error {
+	if !gdb.CheckPut() {
+		return errors.New("unsafe delete and safety set")
+	}
+	gdb.mu.Lock()
+	defer gdb.mu.Unlock()
+	lk, err := gdb.ToKey(ctx, key)
+	if err != nil {
+		return err
+	}
+	k := lk.Default
+	if lk.Translation != nil {
+		k = lk.Translation
+	}
+	v, err := gdb.conn.Fetch(k)
+	if err != nil {
+		if errors.Is(gdbm.ErrItemNotFound, err) {
+			return db.NewErrConflict(key)
+		}
+		return err
+	}
+	if !bytes.Equal(v, old) {
+		return db.NewErrConflict(key)
+	}
+	logg.TraceCtxf(ctx, "gdbm cad", "key", key, "lk", lk)
+	err = gdb.conn.Delete(k)
+	if err != nil {
+		if errors.Is(gdbm.ErrItemNotFound, err) {
+			return db.NewErrConflict(key)
+		}
+		return err
+	}
+	return nil
+}
+
 // Close implements Db
 func (gdb *gdbmDb) Close(ctx context.Context) error {
 	logg.TraceCtxf(ctx, "closing gdbm", "path", gdb.conn)
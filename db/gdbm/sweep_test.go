@@ -0,0 +1,64 @@
+package gdbm
+
+import (
+	"context"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"git.defalsify.org/vise.git/cache"
+	"git.defalsify.org/vise.git/db"
+	"git.defalsify.org/vise.git/persist"
+	"git.defalsify.org/vise.git/state"
+)
+
+func TestSweepGdbm(t *testing.T) {
+	ctx := context.Background()
+	f, err := ioutil.TempFile("", "vise-db-gdbm-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := NewGdbmDb()
+	err = store.Connect(ctx, f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pe := persist.NewPersister(store).WithSession("foo").WithContent(state.NewState(0), cache.NewCache())
+	err = pe.Save("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pe = persist.NewPersister(store).WithSession("bar").WithContent(state.NewState(0), cache.NewCache())
+	pe.Accessed = time.Now().Add(-time.Hour)
+	b, err := pe.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.SetPrefix(db.DATATYPE_STATE)
+	err = store.Put(ctx, []byte("bar"), b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sw := persist.NewSweeper(store, time.Minute)
+	r, err := sw.Sweep(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r) != 1 || r[0] != "bar.bar" {
+		t.Fatalf("expected only 'bar.bar' swept, got: %v", r)
+	}
+
+	store.SetSession("bar")
+	_, err = store.Get(ctx, []byte("bar"))
+	if !db.IsNotFound(err) {
+		t.Fatalf("expected expired state deleted, got: %v", err)
+	}
+	store.SetSession("foo")
+	_, err = store.Get(ctx, []byte("foo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
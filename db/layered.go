@@ -0,0 +1,397 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"sort"
+
+	"git.defalsify.org/vise.git/lang"
+)
+
+// dbGroup applies context and transaction operations to a group of Db members.
+//
+// Members are expected to already be connected.
+type dbGroup struct {
+	members []Db
+	pfx     uint8
+}
+
+// add a member to the group, unless it is already a member.
+func (g *dbGroup) add(store Db) {
+	for _, v := range g.members {
+		if v == store {
+			return
+		}
+	}
+	g.members = append(g.members, store)
+}
+
+// Connect implements Db.
+//
+// The members must already be connected, so this is a noop.
+func (g *dbGroup) Connect(ctx context.Context, connStr string) error {
+	return nil
+}
+
+// Close implements Db.
+//
+// All members are closed. The first error encountered is returned.
+func (g *dbGroup) Close(ctx context.Context) error {
+	var r error
+	for _, v := range g.members {
+		err := v.Close(ctx)
+		if err != nil && r == nil {
+			r = err
+		}
+	}
+	return r
+}
+
+// SetPrefix implements Db.
+func (g *dbGroup) SetPrefix(pfx uint8) {
+	g.pfx = pfx
+	for _, v := range g.members {
+		v.SetPrefix(pfx)
+	}
+}
+
+// Prefix implements Db.
+func (g *dbGroup) Prefix() uint8 {
+	return g.pfx
+}
+
+// SetSession implements Db.
+func (g *dbGroup) SetSession(sessionId string) {
+	for _, v := range g.members {
+		v.SetSession(sessionId)
+	}
+}
+
+// SetLanguage implements Db.
+func (g *dbGroup) SetLanguage(ln *lang.Language) {
+	for _, v := range g.members {
+		v.SetLanguage(ln)
+	}
+}
+
+// SetLock implements Db.
+func (g *dbGroup) SetLock(typ uint8, locked bool) error {
+	for _, v := range g.members {
+		err := v.SetLock(typ, locked)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Safe implements Db.
+//
+// Returns true only if all members are safe.
+func (g *dbGroup) Safe() bool {
+	for _, v := range g.members {
+		if !v.Safe() {
+			return false
+		}
+	}
+	return true
+}
+
+// Start implements Db.
+//
+// A transaction is started on all members. If any fails, the transactions already started are aborted.
+func (g *dbGroup) Start(ctx context.Context) error {
+	for i, v := range g.members {
+		err := v.Start(ctx)
+		if err != nil {
+			for _, vv := range g.members[:i] {
+				vv.Abort(ctx)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// Stop implements Db.
+//
+// The transactions of all members are stopped in order. If one fails, the remaining are aborted. Transactions already stopped are not rolled back.
+func (g *dbGroup) Stop(ctx context.Context) error {
+	for i, v := range g.members {
+		err := v.Stop(ctx)
+		if err != nil {
+			for _, vv := range g.members[i+1:] {
+				vv.Abort(ctx)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// Abort implements Db.
+func (g *dbGroup) Abort(ctx context.Context) {
+	for _, v := range g.members {
+		v.Abort(ctx)
+	}
+}
+
+// Connection implements Db.
+func (g *dbGroup) Connection() string {
+	return ""
+}
+
+// routeDb routes operations to different Db backends depending on the current datatype prefix.
+type routeDb struct {
+	dbGroup
+	def    Db
+	routes map[uint8]Db
+}
+
+// NewRouteDb creates a new Db that routes all datatypes without an explicit route to the given Db.
+func NewRouteDb(store Db) *routeDb {
+	rdb := &routeDb{
+		def:    store,
+		routes: make(map[uint8]Db),
+	}
+	rdb.add(store)
+	return rdb
+}
+
+// WithRoute is a chainable function that routes the given datatypes to a Db.
+//
+// The typ argument may combine several datatypes, e.g. DATATYPE_BIN | DATATYPE_MENU.
+func (rdb *routeDb) WithRoute(typ uint8, store Db) *routeDb {
+	for i := uint8(1); i > 0; i <<= 1 {
+		if typ&i > 0 {
+			rdb.routes[i] = store
+		}
+	}
+	rdb.add(store)
+	store.SetPrefix(rdb.pfx)
+	return rdb
+}
+
+// get the Db for the current datatype prefix.
+func (rdb *routeDb) route() Db {
+	store, ok := rdb.routes[rdb.pfx]
+	if !ok {
+		return rdb.def
+	}
+	return store
+}
+
+// Get implements Db.
+func (rdb *routeDb) Get(ctx context.Context, key []byte) ([]byte, error) {
+	return rdb.route().Get(ctx, key)
+}
+
+// Put implements Db.
+func (rdb *routeDb) Put(ctx context.Context, key []byte, val []byte) error {
+	return rdb.route().Put(ctx, key, val)
+}
+
+// CompareAndSwap implements Db.
+func (rdb *routeDb) CompareAndSwap(ctx context.Context, key []byte, old []byte, val []byte) error {
+	return rdb.route().CompareAndSwap(ctx, key, old, val)
+}
+
+// Delete implements Db.
+func (rdb *routeDb) Delete(ctx context.Context, key []byte) error {
+	return rdb.route().Delete(ctx, key)
+}
+
+// CompareAndDelete implements Db.
+func (rdb *routeDb) CompareAndDelete(ctx context.Context, key []byte, old []byte) error {
+	return rdb.route().CompareAndDelete(ctx, key, old)
+}
+
+// Dump implements Db.
+func (rdb *routeDb) Dump(ctx context.Context, key []byte) (*Dumper, error) {
+	return rdb.route().Dump(ctx, key)
+}
+
+// DecodeKey implements Db.
+func (rdb *routeDb) DecodeKey(ctx context.Context, key []byte) ([]byte, error) {
+	return rdb.route().DecodeKey(ctx, key)
+}
+
+// fallbackDb reads through a chain of Db backends, and writes to the first.
+type fallbackDb struct {
+	dbGroup
+}
+
+// NewFallbackDb creates a new Db from a chain of Db backends.
+//
+// Get returns the value from the first backend in the chain that has the key. All writes are made to the first backend only, which acts as an overlay over the rest.
+//
+// Delete removes the value from every backend in the chain that has the key.
+func NewFallbackDb(store Db, fallback ...Db) *fallbackDb {
+	fdb := &fallbackDb{}
+	fdb.add(store)
+	for _, v := range fallback {
+		fdb.add(v)
+	}
+	return fdb
+}
+
+// Get implements Db.
+func (fdb *fallbackDb) Get(ctx context.Context, key []byte) ([]byte, error) {
+	for _, v := range fdb.members {
+		r, err := v.Get(ctx, key)
+		if err == nil {
+			return r, nil
+		}
+		if !IsNotFound(err) {
+			return nil, err
+		}
+	}
+	return nil, NewErrNotFound(key)
+}
+
+// Put implements Db.
+func (fdb *fallbackDb) Put(ctx context.Context, key []byte, val []byte) error {
+	return fdb.members[0].Put(ctx, key, val)
+}
+
+// CompareAndSwap implements Db.
+//
+// The old value is compared against the value visible through the chain. If the value only exists in a fallback backend, the comparison is not atomic.
+func (fdb *fallbackDb) CompareAndSwap(ctx context.Context, key []byte, old []byte, val []byte) error {
+	top := fdb.members[0]
+	_, err := top.Get(ctx, key)
+	if err == nil {
+		return top.CompareAndSwap(ctx, key, old, val)
+	}
+	if !IsNotFound(err) {
+		return err
+	}
+	r, err := fdb.Get(ctx, key)
+	if err != nil {
+		if !IsNotFound(err) {
+			return err
+		}
+		r = nil
+	}
+	if old == nil {
+		if r != nil {
+			return NewErrConflict(key)
+		}
+	} else if r == nil || !bytes.Equal(r, old) {
+		return NewErrConflict(key)
+	}
+	return top.CompareAndSwap(ctx, key, nil, val)
+}
+
+// Delete implements Db.
+//
+// The value is removed from every backend that has the key, so that it is no longer visible through the chain. Fails if it cannot be removed from any of them, for example if a fallback backend is read-only.
+//
+// Errors with ErrNotFound only if none of the backends had the key.
+func (fdb *fallbackDb) Delete(ctx context.Context, key []byte) error {
+	var found bool
+	for i, v := range fdb.members {
+		if i > 0 {
+			// fallback backends are often read-only, so only attempt to delete what is actually there.
+			_, err := v.Get(ctx, key)
+			if err != nil {
+				if IsNotFound(err) {
+					continue
+				}
+				return err
+			}
+		}
+		err := v.Delete(ctx, key)
+		if err == nil {
+			found = true
+			continue
+		}
+		if !IsNotFound(err) {
+			return err
+		}
+	}
+	if !found {
+		return NewErrNotFound(key)
+	}
+	return nil
+}
+
+// CompareAndDelete implements Db.
+//
+// The old value is compared against the value visible through the chain, which is then removed from every backend that has the key. Only the removal from the first backend is atomic.
+func (fdb *fallbackDb) CompareAndDelete(ctx context.Context, key []byte, old []byte) error {
+	r, err := fdb.Get(ctx, key)
+	if err != nil {
+		if IsNotFound(err) {
+			return NewErrConflict(key)
+		}
+		return err
+	}
+	if !bytes.Equal(r, old) {
+		return NewErrConflict(key)
+	}
+	top := fdb.members[0]
+	_, err = top.Get(ctx, key)
+	if err == nil {
+		err = top.CompareAndDelete(ctx, key, old)
+		if err != nil {
+			return err
+		}
+	} else if !IsNotFound(err) {
+		return err
+	}
+	err = fdb.Delete(ctx, key)
+	if err != nil && !IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// Dump implements Db.
+//
+// Entries from all backends are merged in lexical key order. If several backends have the same key, the value from the earliest backend in the chain is used.
+func (fdb *fallbackDb) Dump(ctx context.Context, key []byte) (*Dumper, error) {
+	var ks []string
+	vals := make(map[string][]byte)
+	for _, v := range fdb.members {
+		d, err := v.Dump(ctx, key)
+		if err != nil {
+			if IsNotFound(err) {
+				continue
+			}
+			return nil, err
+		}
+		for true {
+			k, val := d.Next(ctx)
+			if k == nil {
+				break
+			}
+			_, ok := vals[string(k)]
+			if ok {
+				continue
+			}
+			ks = append(ks, string(k))
+			vals[string(k)] = val
+		}
+		err = d.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(ks) == 0 {
+		return nil, NewErrNotFound(key)
+	}
+	sort.Strings(ks)
+	kr := make([][]byte, len(ks))
+	vr := make([][]byte, len(ks))
+	for i, k := range ks {
+		kr[i] = []byte(k)
+		vr[i] = vals[k]
+	}
+	return NewSliceDumper(kr, vr), nil
+}
+
+// DecodeKey implements Db.
+func (fdb *fallbackDb) DecodeKey(ctx context.Context, key []byte) ([]byte, error) {
+	return fdb.members[0].DecodeKey(ctx, key)
+}
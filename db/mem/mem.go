@@ -1,6 +1,7 @@
 package mem
 
 import (
+	"bytes"
 	"context"
 	"encoding/hex"
 	"errors"
@@ -98,6 +99,82 @@ func (mdb *memDb) Put(ctx context.Context, key []byte, val []byte) error {
 	return nil
 }
 
+// CompareAndSwap implements Db
+func (mdb *memDb) CompareAndSwap(ctx context.Context, key []byte, old []byte, val []byte) error {
+	var k string
+	if !mdb.CheckPut() {
+		return errors.New("unsafe put and safety set")
+	}
+	mk, err := mdb.toHexKey(ctx, key)
+	if err != nil {
+		return err
+	}
+	if mk.Translation != "" {
+		k = mk.Translation
+	} else {
+		k = mk.Default
+	}
+	v, ok := mdb.store[k]
+	if old == nil {
+		if ok {
+			return db.NewErrConflict(key)
+		}
+	} else if !ok || !bytes.Equal(v, old) {
+		return db.NewErrConflict(key)
+	}
+	mdb.store[k] = val
+	logg.TraceCtxf(ctx, "mem cas", "k", k, "mk", mk, "v", val)
+	return nil
+}
+
+// Delete implements Db
+func (mdb *memDb) Delete(ctx context.Context, key []byte) error {
+	var k string
+	if !mdb.CheckPut() {
+		return errors.New("unsafe delete and safety set")
+	}
+	mk, err := mdb.toHexKey(ctx, key)
+	if err != nil {
+		return err
+	}
+	if mk.Translation != "" {
+		k = mk.Translation
+	} else {
+		k = mk.Default
+	}
+	_, ok := mdb.store[k]
+	if !ok {
+		return db.NewErrNotFound(key)
+	}
+	delete(mdb.store, k)
+	logg.TraceCtxf(ctx, "mem delete", "k", k, "mk", mk)
+	return nil
+}
+
+// CompareAndDelete implements Db
+func (mdb *memDb) CompareAndDelete(ctx context.Context, key []byte, old []byte) error {
+	var k string
+	if !mdb.CheckPut() {
+		return errors.New("unsafe delete and safety set")
+	}
+	mk, err := mdb.toHexKey(ctx, key)
+	if err != nil {
+		return err
+	}
+	if mk.Translation != "" {
+		k = mk.Translation
+	} else {
+		k = mk.Default
+	}
+	v, ok := mdb.store[k]
+	if !ok || !bytes.Equal(v, old) {
+		return db.NewErrConflict(key)
+	}
+	delete(mdb.store, k)
+	logg.TraceCtxf(ctx, "mem cad", "k", k, "mk", mk)
+	return nil
+}
+
 // Close implements Db
 func (mdb *memDb) Close(ctx context.Context) error {
 	return nil
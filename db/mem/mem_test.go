@@ -55,3 +55,232 @@ func TestPutGetMem(t *testing.T) {
 		t.Fatal("expected get error for key 'bar'")
 	}
 }
+
+func TestCasesMemShared(t *testing.T) {
+	ctx := context.Background()
+
+	store := NewMemDb()
+	err := store.Connect(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	shared := db.NewSharedDb(store)
+	other := shared.View()
+	other.SetPrefix(db.DATATYPE_USERDATA)
+	other.SetSession("xyzzy")
+
+	err = dbtest.RunTests(t, ctx, shared.View())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other.Prefix() != db.DATATYPE_USERDATA {
+		t.Fatalf("expected view prefix %d, got %d", db.DATATYPE_USERDATA, other.Prefix())
+	}
+}
+
+func TestCasesMemRoute(t *testing.T) {
+	ctx := context.Background()
+
+	store := NewMemDb()
+	err := store.Connect(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := NewMemDb()
+	err = other.Connect(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rdb := db.NewRouteDb(store).WithRoute(db.DATATYPE_STATE|db.DATATYPE_USERDATA, other)
+
+	err = dbtest.RunTests(t, ctx, rdb)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRouteMem(t *testing.T) {
+	ctx := context.Background()
+
+	store := NewMemDb()
+	err := store.Connect(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := NewMemDb()
+	err = other.Connect(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rdb := db.NewRouteDb(store).WithRoute(db.DATATYPE_USERDATA, other)
+	rdb.SetSession("xyzzy")
+	err = rdb.SetLock(db.DATATYPE_TEMPLATE, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rdb.SetPrefix(db.DATATYPE_USERDATA)
+	err = rdb.Put(ctx, []byte("foo"), []byte("bar"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rdb.SetPrefix(db.DATATYPE_TEMPLATE)
+	err = rdb.Put(ctx, []byte("foo"), []byte("baz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other.SetPrefix(db.DATATYPE_USERDATA)
+	v, err := other.Get(ctx, []byte("foo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(v, []byte("bar")) {
+		t.Fatalf("expected 'bar', got '%s'", v)
+	}
+	store.SetPrefix(db.DATATYPE_USERDATA)
+	_, err = store.Get(ctx, []byte("foo"))
+	if !db.IsNotFound(err) {
+		t.Fatalf("expected not found, got %v", err)
+	}
+	store.SetPrefix(db.DATATYPE_TEMPLATE)
+	v, err = store.Get(ctx, []byte("foo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(v, []byte("baz")) {
+		t.Fatalf("expected 'baz', got '%s'", v)
+	}
+}
+
+func TestCasesMemFallback(t *testing.T) {
+	ctx := context.Background()
+
+	store := NewMemDb()
+	err := store.Connect(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := NewMemDb()
+	err = other.Connect(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fdb := db.NewFallbackDb(store, other)
+
+	err = dbtest.RunTests(t, ctx, fdb)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFallbackMem(t *testing.T) {
+	ctx := context.Background()
+
+	store := NewMemDb()
+	err := store.Connect(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := NewMemDb()
+	err = other.Connect(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = other.SetLock(db.DATATYPE_TEMPLATE, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other.SetPrefix(db.DATATYPE_TEMPLATE)
+	err = other.Put(ctx, []byte("foo"), []byte("bar"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = other.Put(ctx, []byte("baz"), []byte("xyzzy"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fdb := db.NewFallbackDb(store, other)
+	err = fdb.SetLock(db.DATATYPE_TEMPLATE, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fdb.SetPrefix(db.DATATYPE_TEMPLATE)
+	v, err := fdb.Get(ctx, []byte("foo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(v, []byte("bar")) {
+		t.Fatalf("expected 'bar', got '%s'", v)
+	}
+
+	err = fdb.CompareAndSwap(ctx, []byte("foo"), []byte("inky"), []byte("pinky"))
+	if !db.IsConflict(err) {
+		t.Fatalf("expected conflict, got %v", err)
+	}
+	err = fdb.CompareAndSwap(ctx, []byte("foo"), nil, []byte("pinky"))
+	if !db.IsConflict(err) {
+		t.Fatalf("expected conflict, got %v", err)
+	}
+	err = fdb.CompareAndSwap(ctx, []byte("foo"), []byte("bar"), []byte("pinky"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err = fdb.Get(ctx, []byte("foo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(v, []byte("pinky")) {
+		t.Fatalf("expected 'pinky', got '%s'", v)
+	}
+	v, err = other.Get(ctx, []byte("foo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(v, []byte("bar")) {
+		t.Fatalf("expected fallback unchanged 'bar', got '%s'", v)
+	}
+
+	err = fdb.Delete(ctx, []byte("foo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = fdb.Get(ctx, []byte("foo"))
+	if !db.IsNotFound(err) {
+		t.Fatalf("expected not found after delete, got %v", err)
+	}
+	err = fdb.Delete(ctx, []byte("baz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = other.Get(ctx, []byte("baz"))
+	if !db.IsNotFound(err) {
+		t.Fatalf("expected fallback value deleted, got %v", err)
+	}
+	err = fdb.Delete(ctx, []byte("baz"))
+	if !db.IsNotFound(err) {
+		t.Fatalf("expected not found, got %v", err)
+	}
+
+	err = other.Put(ctx, []byte("xyzzy"), []byte("plugh"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = other.SetLock(db.DATATYPE_TEMPLATE, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = fdb.Delete(ctx, []byte("xyzzy"))
+	if err == nil || db.IsNotFound(err) {
+		t.Fatalf("expected error on delete from read-only fallback, got %v", err)
+	}
+	err = fdb.Put(ctx, []byte("plugh"), []byte("xyzzy"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = fdb.Delete(ctx, []byte("plugh"))
+	if err != nil {
+		t.Fatalf("expected delete with read-only fallback not having the key, got %v", err)
+	}
+}
@@ -1,6 +1,7 @@
 package postgres
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 
@@ -20,8 +21,8 @@ func (pdb *pgDb) Dump(ctx context.Context, key []byte) (*db.Dumper, error) {
 	}
 	k := lk.Default
 
-	query := fmt.Sprintf("SELECT key, value FROM %s.kv_vise WHERE key >= $1", pdb.schema)
-	rs, err := tx.Query(ctx, query, k)
+	query, args := prefixQuery(fmt.Sprintf("SELECT key, value FROM %s.kv_vise WHERE", pdb.schema), k)
+	rs, err := tx.Query(ctx, query+" ORDER BY key", args...)
 	if err != nil {
 		logg.Debugf("query fail", "err", err)
 		tx.Rollback(ctx)
@@ -75,3 +76,17 @@ func (pdb *pgDb) closeFunc() error {
 	}
 	return nil
 }
+
+// add a condition matching all keys with the given prefix to a query.
+//
+// The key range is bounded by the lowest key greater than all keys with the prefix, so that the index can be used. Returns the arguments for the condition.
+func prefixQuery(query string, k []byte) (string, []any) {
+	end := bytes.Clone(k)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return query + " key >= $1 AND key < $2", []any{k, end[:i+1]}
+		}
+	}
+	return query + " key >= $1", []any{k}
+}
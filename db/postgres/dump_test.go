@@ -63,7 +63,7 @@ func TestDumpPg(t *testing.T) {
 	//rows = rows.AddRow([]byte("xyzzy"), []byte("clyde"))
 
 	mock.ExpectBegin()
-	mock.ExpectQuery("SELECT key, value FROM vvise.kv_vise").WithArgs(append([]byte{db.DATATYPE_USERDATA}, k...)).WillReturnRows(rows)
+	mock.ExpectQuery("SELECT key, value FROM vvise.kv_vise").WithArgs(append([]byte{db.DATATYPE_USERDATA}, k...), append([]byte{db.DATATYPE_USERDATA}, []byte("xyzzy.fop")...)).WillReturnRows(rows)
 	mock.ExpectCommit()
 
 	o, err := store.Dump(ctx, []byte("foo"))
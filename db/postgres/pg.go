@@ -4,8 +4,10 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"time"
 
 	pgx "github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
 	"github.com/jackc/pgx/v5/pgxpool"
 
 	"git.defalsify.org/vise.git/db"
@@ -161,6 +163,186 @@ func (pdb *pgDb) Put(ctx context.Context, key []byte, val []byte) error {
 	return pdb.stopSingle(ctx)
 }
 
+// CompareAndSwap implements Db.
+func (pdb *pgDb) CompareAndSwap(ctx context.Context, key []byte, old []byte, val []byte) error {
+	var query string
+	var args []any
+	if !pdb.CheckPut() {
+		return errors.New("unsafe put and safety set")
+	}
+
+	lk, err := pdb.ToKey(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	err = pdb.start(ctx)
+	if err != nil {
+		return err
+	}
+	actualKey := lk.Default
+	if lk.Translation != nil {
+		actualKey = lk.Translation
+	}
+	logg.TraceCtxf(ctx, "cas", "key", key, "old", old, "val", val)
+	if old == nil {
+		query = fmt.Sprintf("INSERT INTO %s.kv_vise (key, value, updated) VALUES ($1, $2, 'now') ON CONFLICT(key) DO NOTHING;", pdb.schema)
+		args = []any{actualKey, val}
+	} else {
+		query = fmt.Sprintf("UPDATE %s.kv_vise SET value = $2, updated = 'now' WHERE key = $1 AND value = $3;", pdb.schema)
+		args = []any{actualKey, val, old}
+	}
+
+	r, err := pdb.tx.Exec(ctx, query, args...)
+	if err != nil {
+		pdb.Abort(ctx)
+		return err
+	}
+
+	err = pdb.stopSingle(ctx)
+	if err != nil {
+		return err
+	}
+	if r.RowsAffected() == 0 {
+		return db.NewErrConflict(key)
+	}
+	return nil
+}
+
+// Delete implements Db.
+func (pdb *pgDb) Delete(ctx context.Context, key []byte) error {
+	if !pdb.CheckPut() {
+		return errors.New("unsafe delete and safety set")
+	}
+
+	lk, err := pdb.ToKey(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	err = pdb.start(ctx)
+	if err != nil {
+		return err
+	}
+	logg.TraceCtxf(ctx, "delete", "key", key)
+	query := fmt.Sprintf("DELETE FROM %s.kv_vise WHERE key = $1;", pdb.schema)
+	actualKey := lk.Default
+	if lk.Translation != nil {
+		actualKey = lk.Translation
+	}
+
+	r, err := pdb.tx.Exec(ctx, query, actualKey)
+	if err != nil {
+		pdb.Abort(ctx)
+		return err
+	}
+
+	err = pdb.stopSingle(ctx)
+	if err != nil {
+		return err
+	}
+	if r.RowsAffected() == 0 {
+		return db.NewErrNotFound(key)
+	}
+	return nil
+}
+
+// CompareAndDelete implements Db.
+func (pdb *pgDb) CompareAndDelete(ctx context.Context, key []byte, old []byte) error {
+	if !pdb.CheckPut() {
+		return errors.New("unsafe delete and safety set")
+	}
+
+	lk, err := pdb.ToKey(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	err = pdb.start(ctx)
+	if err != nil {
+		return err
+	}
+	logg.TraceCtxf(ctx, "cad", "key", key, "old", old)
+	query := fmt.Sprintf("DELETE FROM %s.kv_vise WHERE key = $1 AND value = $2;", pdb.schema)
+	actualKey := lk.Default
+	if lk.Translation != nil {
+		actualKey = lk.Translation
+	}
+
+	r, err := pdb.tx.Exec(ctx, query, actualKey, old)
+	if err != nil {
+		pdb.Abort(ctx)
+		return err
+	}
+
+	err = pdb.stopSingle(ctx)
+	if err != nil {
+		return err
+	}
+	if r.RowsAffected() == 0 {
+		return db.NewErrConflict(key)
+	}
+	return nil
+}
+
+// Expire deletes all entries under the current prefix and session that have not been updated within the given time to live, according to the updated column.
+//
+// Returns the keys of the deleted entries.
+func (pdb *pgDb) Expire(ctx context.Context, ttl time.Duration) ([][]byte, error) {
+	var r [][]byte
+	if !pdb.CheckPut() {
+		return nil, errors.New("unsafe delete and safety set")
+	}
+
+	pdb.SetLanguage(nil)
+	lk, err := pdb.ToKey(ctx, []byte{})
+	if err != nil {
+		return nil, err
+	}
+
+	err = pdb.start(ctx)
+	if err != nil {
+		return nil, err
+	}
+	logg.TraceCtxf(ctx, "expire", "prefix", lk.Default, "ttl", ttl)
+	query, args := prefixQuery(fmt.Sprintf("DELETE FROM %s.kv_vise WHERE", pdb.schema), lk.Default)
+	query = fmt.Sprintf("%s AND updated < LOCALTIMESTAMP - $%d::interval RETURNING key;", query, len(args)+1)
+	args = append(args, pgtype.Interval{Microseconds: ttl.Microseconds(), Valid: true})
+	rs, err := pdb.tx.Query(ctx, query, args...)
+	if err != nil {
+		pdb.Abort(ctx)
+		return nil, err
+	}
+	for rs.Next() {
+		var kk []byte
+		err = rs.Scan(&kk)
+		if err != nil {
+			rs.Close()
+			pdb.Abort(ctx)
+			return nil, err
+		}
+		kk, err = pdb.DecodeKey(ctx, kk)
+		if err != nil {
+			rs.Close()
+			pdb.Abort(ctx)
+			return nil, err
+		}
+		r = append(r, kk)
+	}
+	rs.Close()
+	err = rs.Err()
+	if err != nil {
+		pdb.Abort(ctx)
+		return nil, err
+	}
+
+	err = pdb.stopSingle(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
 // Get implements Db.
 func (pdb *pgDb) Get(ctx context.Context, key []byte) ([]byte, error) {
 	var rr []byte
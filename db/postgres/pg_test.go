@@ -316,3 +316,125 @@ func TestPostgresTxStartStop(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestPostgresCompareAndSwap(t *testing.T) {
+	ses := "xyzzy"
+
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mock.Close()
+
+	store := NewPgDb().WithConnection(mock).WithSchema("vvise")
+	store.SetPrefix(db.DATATYPE_STATE)
+	store.SetSession(ses)
+	ctx := context.Background()
+
+	k := []byte("foo")
+	ks := append([]byte{db.DATATYPE_STATE}, []byte(ses)...)
+	ks = append(ks, []byte(".")...)
+	ks = append(ks, k...)
+	v := []byte("bar")
+	vtwo := []byte("baz")
+
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO vvise.kv_vise").WithArgs(ks, v).WillReturnResult(pgxmock.NewResult("INSERT", 1))
+	mock.ExpectCommit()
+	err = store.CompareAndSwap(ctx, k, nil, v)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO vvise.kv_vise").WithArgs(ks, v).WillReturnResult(pgxmock.NewResult("INSERT", 0))
+	mock.ExpectCommit()
+	err = store.CompareAndSwap(ctx, k, nil, v)
+	if !db.IsConflict(err) {
+		t.Fatalf("expected conflict, got %v", err)
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE vvise.kv_vise").WithArgs(ks, vtwo, v).WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+	mock.ExpectCommit()
+	err = store.CompareAndSwap(ctx, k, v, vtwo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE vvise.kv_vise").WithArgs(ks, vtwo, v).WillReturnResult(pgxmock.NewResult("UPDATE", 0))
+	mock.ExpectCommit()
+	err = store.CompareAndSwap(ctx, k, v, vtwo)
+	if !db.IsConflict(err) {
+		t.Fatalf("expected conflict, got %v", err)
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectExec("DELETE FROM vvise.kv_vise").WithArgs(ks, v).WillReturnResult(pgxmock.NewResult("DELETE", 0))
+	mock.ExpectCommit()
+	err = store.CompareAndDelete(ctx, k, v)
+	if !db.IsConflict(err) {
+		t.Fatalf("expected conflict, got %v", err)
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectExec("DELETE FROM vvise.kv_vise").WithArgs(ks, vtwo).WillReturnResult(pgxmock.NewResult("DELETE", 1))
+	mock.ExpectCommit()
+	err = store.CompareAndDelete(ctx, k, vtwo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = mock.ExpectationsWereMet()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPostgresDelete(t *testing.T) {
+	ses := "xyzzy"
+
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mock.Close()
+
+	store := NewPgDb().WithConnection(mock).WithSchema("vvise")
+	store.SetPrefix(db.DATATYPE_STATE)
+	store.SetSession(ses)
+	ctx := context.Background()
+
+	k := []byte("foo")
+	ks := append([]byte{db.DATATYPE_STATE}, []byte(ses)...)
+	ks = append(ks, []byte(".")...)
+	ks = append(ks, k...)
+
+	mock.ExpectBegin()
+	mock.ExpectExec("DELETE FROM vvise.kv_vise").WithArgs(ks).WillReturnResult(pgxmock.NewResult("DELETE", 1))
+	mock.ExpectCommit()
+	err = store.Delete(ctx, k)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectExec("DELETE FROM vvise.kv_vise").WithArgs(ks).WillReturnResult(pgxmock.NewResult("DELETE", 0))
+	mock.ExpectCommit()
+	err = store.Delete(ctx, k)
+	if !db.IsNotFound(err) {
+		t.Fatalf("expected not found, got %v", err)
+	}
+
+	store.SetLock(db.DATATYPE_STATE, true)
+	err = store.Delete(ctx, k)
+	if err == nil {
+		t.Fatal("expected error on locked delete")
+	}
+
+	err = mock.ExpectationsWereMet()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
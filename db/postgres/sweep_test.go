@@ -0,0 +1,52 @@
+package postgres
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
+	pgxmock "github.com/pashagolub/pgxmock/v4"
+
+	"git.defalsify.org/vise.git/db"
+	"git.defalsify.org/vise.git/persist"
+)
+
+func TestSweepPg(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mock.Close()
+
+	store := NewPgDb().WithConnection(mock).WithSchema("vvise")
+	ctx := context.Background()
+
+	mockKfd := pgconn.FieldDescription{
+		Name:        "key",
+		DataTypeOID: pgtype.ByteaOID,
+		Format:      typMap.FormatCodeForOID(pgtype.ByteaOID),
+	}
+	rows := pgxmock.NewRowsWithColumnDefinition(mockKfd)
+	rows = rows.AddRow(append([]byte{db.DATATYPE_STATE}, []byte("bar.bar")...))
+	ttl := pgtype.Interval{Microseconds: time.Minute.Microseconds(), Valid: true}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("DELETE FROM vvise.kv_vise WHERE key >= \\$1 AND key < \\$2 AND updated < LOCALTIMESTAMP - \\$3::interval").WithArgs([]byte{db.DATATYPE_STATE}, []byte{db.DATATYPE_STATE + 1}, ttl).WillReturnRows(rows)
+	mock.ExpectCommit()
+
+	sw := persist.NewSweeper(store, time.Minute)
+	r, err := sw.Sweep(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r) != 1 || r[0] != "bar.bar" {
+		t.Fatalf("expected only 'bar.bar' swept, got: %v", r)
+	}
+
+	err = mock.ExpectationsWereMet()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,4 @@
+// Package redis is a Redis protocol backed implementation of the db.Db interface.
+//
+// Keys are stored verbatim as produced by db.ToDbKey, optionally prepended by a namespace. Values of DATATYPE_STATE may be given an expiry time.
+package redis
@@ -0,0 +1,78 @@
+package redis
+
+import (
+	"bytes"
+	"context"
+	"sort"
+	"strings"
+
+	"git.defalsify.org/vise.git/db"
+)
+
+// escape characters with special meaning in SCAN match patterns.
+func escapePattern(s string) string {
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		switch c {
+		case '*', '?', '[', ']', '\\':
+			b.WriteByte('\\')
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+// Dump implements Db.
+//
+// Matching keys are collected with SCAN, and returned in lexical order. All matching entries are retrieved before the method returns.
+func (rdb *redisDb) Dump(ctx context.Context, key []byte) (*db.Dumper, error) {
+	var ks []string
+	var cursor uint64
+
+	rdb.SetLanguage(nil)
+	lk, err := rdb.ToKey(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	pfx := rdb.ns + string(lk.Default)
+	pattern := escapePattern(pfx) + "*"
+	for true {
+		var r []string
+		r, cursor, err = rdb.conn.Scan(ctx, cursor, pattern, 0).Result()
+		if err != nil {
+			return nil, err
+		}
+		ks = append(ks, r...)
+		if cursor == 0 {
+			break
+		}
+	}
+	if len(ks) == 0 {
+		return nil, db.NewErrNotFound(lk.Default)
+	}
+	sort.Strings(ks)
+	vs, err := rdb.conn.MGet(ctx, ks...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	var kr [][]byte
+	var vr [][]byte
+	for i, k := range ks {
+		v, ok := vs[i].(string)
+		if !ok {
+			// expired or deleted since scan
+			continue
+		}
+		kk, err := rdb.DecodeKey(ctx, bytes.TrimPrefix([]byte(k), []byte(rdb.ns)))
+		if err != nil {
+			return nil, err
+		}
+		kr = append(kr, kk)
+		vr = append(vr, []byte(v))
+	}
+	if len(kr) == 0 {
+		return nil, db.NewErrNotFound(lk.Default)
+	}
+	return db.NewSliceDumper(kr, vr), nil
+}
@@ -0,0 +1,360 @@
+package redis
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"time"
+
+	goredis "github.com/redis/go-redis/v9"
+
+	"git.defalsify.org/vise.git/db"
+)
+
+// txOp is a write operation buffered in a transaction.
+type txOp struct {
+	key string
+	val []byte
+	del bool
+	ttl time.Duration
+}
+
+// redisDb is a Redis protocol backend implementation of the Db interface.
+type redisDb struct {
+	*db.DbBase
+	conn     *goredis.Client
+	ns       string
+	stateTtl time.Duration
+	tx       bool
+	txOps    []txOp
+	txVals   map[string]*txOp
+}
+
+// NewRedisDb creates a new Redis backed Db implementation.
+func NewRedisDb() *redisDb {
+	db := &redisDb{
+		DbBase: db.NewDbBase(),
+	}
+	return db
+}
+
+// WithNamespace sets a string to prepend to all keys.
+//
+// It enables several independent stores to share the same Redis database.
+func (rdb *redisDb) WithNamespace(ns string) *redisDb {
+	rdb.ns = ns
+	return rdb
+}
+
+// WithStateExpiry sets the time after which values of DATATYPE_STATE expire, counted from the last time they were stored.
+//
+// If not set, or set to 0, values never expire.
+func (rdb *redisDb) WithStateExpiry(ttl time.Duration) *redisDb {
+	rdb.stateTtl = ttl
+	return rdb
+}
+
+// WithClient sets an already configured client to use for the connection.
+func (rdb *redisDb) WithClient(client *goredis.Client) *redisDb {
+	rdb.conn = client
+	return rdb
+}
+
+// String implements the string interface.
+func (rdb *redisDb) String() string {
+	return "redisdb: " + rdb.Connection()
+}
+
+// Connect implements Db.
+//
+// The connection string is a redis:// url.
+func (rdb *redisDb) Connect(ctx context.Context, connStr string) error {
+	if rdb.conn == nil {
+		opts, err := goredis.ParseURL(connStr)
+		if err != nil {
+			return err
+		}
+		rdb.conn = goredis.NewClient(opts)
+	} else {
+		logg.WarnCtxf(ctx, "Redis client already set")
+	}
+	err := rdb.conn.Ping(ctx).Err()
+	if err != nil {
+		return err
+	}
+	rdb.DbBase.Connect(ctx, connStr)
+	return nil
+}
+
+// Start implements Db.
+//
+// Writes are buffered until Stop is called, and then applied atomically. Reads in the transaction include the buffered writes.
+func (rdb *redisDb) Start(ctx context.Context) error {
+	if rdb.tx {
+		return db.ErrTxExist
+	}
+	rdb.tx = true
+	rdb.txOps = []txOp{}
+	rdb.txVals = make(map[string]*txOp)
+	return nil
+}
+
+// Stop implements Db.
+func (rdb *redisDb) Stop(ctx context.Context) error {
+	if !rdb.tx {
+		return db.ErrNoTx
+	}
+	ops := rdb.txOps
+	rdb.Abort(ctx)
+	if len(ops) == 0 {
+		return nil
+	}
+	_, err := rdb.conn.TxPipelined(ctx, func(p goredis.Pipeliner) error {
+		for _, op := range ops {
+			if op.del {
+				p.Del(ctx, op.key)
+			} else {
+				p.Set(ctx, op.key, op.val, op.ttl)
+			}
+		}
+		return nil
+	})
+	logg.TraceCtxf(ctx, "stop tx", "ops", len(ops), "err", err)
+	return err
+}
+
+// Abort implements Db.
+func (rdb *redisDb) Abort(ctx context.Context) {
+	rdb.tx = false
+	rdb.txOps = nil
+	rdb.txVals = nil
+}
+
+// the redis key to use for the current context.
+func (rdb *redisDb) actualKey(lk db.LookupKey) string {
+	if lk.Translation != nil {
+		return rdb.ns + string(lk.Translation)
+	}
+	return rdb.ns + string(lk.Default)
+}
+
+// the expiry time to use for the current data type.
+func (rdb *redisDb) ttl() time.Duration {
+	if rdb.Prefix() == db.DATATYPE_STATE {
+		return rdb.stateTtl
+	}
+	return 0
+}
+
+// retrieve a value, including writes buffered in a transaction.
+func (rdb *redisDb) get(ctx context.Context, k string) ([]byte, bool, error) {
+	if rdb.tx {
+		op, ok := rdb.txVals[k]
+		if ok {
+			return op.val, !op.del, nil
+		}
+	}
+	v, err := rdb.conn.Get(ctx, k).Bytes()
+	if err != nil {
+		if errors.Is(err, goredis.Nil) {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+	return v, true, nil
+}
+
+// buffer a write in the current transaction.
+func (rdb *redisDb) queue(op txOp) {
+	rdb.txOps = append(rdb.txOps, op)
+	rdb.txVals[op.key] = &op
+}
+
+// Put implements Db.
+func (rdb *redisDb) Put(ctx context.Context, key []byte, val []byte) error {
+	if !rdb.CheckPut() {
+		return errors.New("unsafe put and safety set")
+	}
+	lk, err := rdb.ToKey(ctx, key)
+	if err != nil {
+		return err
+	}
+	k := rdb.actualKey(lk)
+	logg.TraceCtxf(ctx, "redis put", "key", key, "k", k, "val", val)
+	if rdb.tx {
+		rdb.queue(txOp{key: k, val: val, ttl: rdb.ttl()})
+		return nil
+	}
+	return rdb.conn.Set(ctx, k, val, rdb.ttl()).Err()
+}
+
+// CompareAndSwap implements Db.
+//
+// Outside of a transaction the comparison and write are atomic. Within a transaction, the comparison is made against the value at the time of the call.
+func (rdb *redisDb) CompareAndSwap(ctx context.Context, key []byte, old []byte, val []byte) error {
+	if !rdb.CheckPut() {
+		return errors.New("unsafe put and safety set")
+	}
+	lk, err := rdb.ToKey(ctx, key)
+	if err != nil {
+		return err
+	}
+	k := rdb.actualKey(lk)
+	ttl := rdb.ttl()
+	logg.TraceCtxf(ctx, "redis cas", "key", key, "k", k, "old", old, "val", val)
+	if rdb.tx {
+		v, ok, err := rdb.get(ctx, k)
+		if err != nil {
+			return err
+		}
+		if !match(v, ok, old) {
+			return db.NewErrConflict(key)
+		}
+		rdb.queue(txOp{key: k, val: val, ttl: ttl})
+		return nil
+	}
+	err = rdb.conn.Watch(ctx, func(tx *goredis.Tx) error {
+		ok := true
+		v, err := tx.Get(ctx, k).Bytes()
+		if errors.Is(err, goredis.Nil) {
+			ok = false
+		} else if err != nil {
+			return err
+		}
+		if !match(v, ok, old) {
+			return db.NewErrConflict(key)
+		}
+		_, err = tx.TxPipelined(ctx, func(p goredis.Pipeliner) error {
+			p.Set(ctx, k, val, ttl)
+			return nil
+		})
+		return err
+	}, k)
+	if errors.Is(err, goredis.TxFailedErr) {
+		return db.NewErrConflict(key)
+	}
+	return err
+}
+
+// check whether a stored value matches the expected old value for CompareAndSwap.
+func match(v []byte, ok bool, old []byte) bool {
+	if old == nil {
+		return !ok
+	}
+	return ok && bytes.Equal(v, old)
+}
+
+// Delete implements Db.
+func (rdb *redisDb) Delete(ctx context.Context, key []byte) error {
+	if !rdb.CheckPut() {
+		return errors.New("unsafe delete and safety set")
+	}
+	lk, err := rdb.ToKey(ctx, key)
+	if err != nil {
+		return err
+	}
+	k := rdb.actualKey(lk)
+	logg.TraceCtxf(ctx, "redis delete", "key", key, "k", k)
+	if rdb.tx {
+		_, ok, err := rdb.get(ctx, k)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return db.NewErrNotFound(key)
+		}
+		rdb.queue(txOp{key: k, del: true})
+		return nil
+	}
+	c, err := rdb.conn.Del(ctx, k).Result()
+	if err != nil {
+		return err
+	}
+	if c == 0 {
+		return db.NewErrNotFound(key)
+	}
+	return nil
+}
+
+// CompareAndDelete implements Db.
+func (rdb *redisDb) CompareAndDelete(ctx context.Context, key []byte, old []byte) error {
+	if !rdb.CheckPut() {
+		return errors.New("unsafe delete and safety set")
+	}
+	lk, err := rdb.ToKey(ctx, key)
+	if err != nil {
+		return err
+	}
+	k := rdb.actualKey(lk)
+	logg.TraceCtxf(ctx, "redis cad", "key", key, "k", k, "old", old)
+	if rdb.tx {
+		v, ok, err := rdb.get(ctx, k)
+		if err != nil {
+			return err
+		}
+		if !ok || !bytes.Equal(v, old) {
+			return db.NewErrConflict(key)
+		}
+		rdb.queue(txOp{key: k, del: true})
+		return nil
+	}
+	err = rdb.conn.Watch(ctx, func(tx *goredis.Tx) error {
+		v, err := tx.Get(ctx, k).Bytes()
+		if errors.Is(err, goredis.Nil) {
+			return db.NewErrConflict(key)
+		} else if err != nil {
+			return err
+		}
+		if !bytes.Equal(v, old) {
+			return db.NewErrConflict(key)
+		}
+		_, err = tx.TxPipelined(ctx, func(p goredis.Pipeliner) error {
+			p.Del(ctx, k)
+			return nil
+		})
+		return err
+	}, k)
+	if errors.Is(err, goredis.TxFailedErr) {
+		return db.NewErrConflict(key)
+	}
+	return err
+}
+
+// Get implements Db.
+func (rdb *redisDb) Get(ctx context.Context, key []byte) ([]byte, error) {
+	lk, err := rdb.ToKey(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if lk.Translation != nil {
+		v, ok, err := rdb.get(ctx, rdb.ns+string(lk.Translation))
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return v, nil
+		}
+	}
+	v, ok, err := rdb.get(ctx, rdb.ns+string(lk.Default))
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, db.NewErrNotFound(key)
+	}
+	logg.TraceCtxf(ctx, "redis get", "key", key, "lk", lk, "val", v)
+	return v, nil
+}
+
+// Close implements Db.
+func (rdb *redisDb) Close(ctx context.Context) error {
+	err := rdb.Stop(ctx)
+	if err == db.ErrNoTx {
+		err = nil
+	}
+	cerr := rdb.conn.Close()
+	if err != nil {
+		return err
+	}
+	return cerr
+}
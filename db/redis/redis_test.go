@@ -0,0 +1,162 @@
+package redis
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+
+	"git.defalsify.org/vise.git/db"
+	"git.defalsify.org/vise.git/db/dbtest"
+)
+
+func newTestDb(t *testing.T) (*redisDb, *miniredis.Miniredis) {
+	ctx := context.Background()
+	srv := miniredis.RunT(t)
+	store := NewRedisDb()
+	err := store.Connect(ctx, "redis://"+srv.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store, srv
+}
+
+func TestCasesRedis(t *testing.T) {
+	ctx := context.Background()
+	store, _ := newTestDb(t)
+	err := dbtest.RunTests(t, ctx, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPutGetRedis(t *testing.T) {
+	var dbi db.Db
+	ctx := context.Background()
+	store, srv := newTestDb(t)
+	store = store.WithNamespace("vise:")
+	store.SetPrefix(db.DATATYPE_USERDATA)
+	store.SetSession("ses")
+
+	dbi = store
+	_ = dbi
+
+	err := store.Put(ctx, []byte("foo"), []byte("bar"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := store.Get(ctx, []byte("foo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(v, []byte("bar")) {
+		t.Fatalf("expected value 'bar', found '%s'", v)
+	}
+	k := "vise:" + string(db.ToDbKey(db.DATATYPE_USERDATA, []byte("ses.foo"), nil))
+	if !srv.Exists(k) {
+		t.Fatalf("expected key %x to exist, have %v", k, srv.Keys())
+	}
+	_, err = store.Get(ctx, []byte("bar"))
+	if !db.IsNotFound(err) {
+		t.Fatalf("expected not found for key 'bar', got %v", err)
+	}
+}
+
+func TestStateExpiryRedis(t *testing.T) {
+	ctx := context.Background()
+	store, srv := newTestDb(t)
+	store = store.WithStateExpiry(time.Minute)
+	store.SetLock(db.DATATYPE_STATE, false)
+
+	store.SetPrefix(db.DATATYPE_STATE)
+	err := store.Put(ctx, []byte("foo"), []byte("inky"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.SetPrefix(db.DATATYPE_USERDATA)
+	err = store.Put(ctx, []byte("foo"), []byte("pinky"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv.FastForward(time.Minute * 2)
+	store.SetPrefix(db.DATATYPE_STATE)
+	_, err = store.Get(ctx, []byte("foo"))
+	if !db.IsNotFound(err) {
+		t.Fatalf("expected state expired, got %v", err)
+	}
+	store.SetPrefix(db.DATATYPE_USERDATA)
+	_, err = store.Get(ctx, []byte("foo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTxRedis(t *testing.T) {
+	ctx := context.Background()
+	store, _ := newTestDb(t)
+	store.SetPrefix(db.DATATYPE_USERDATA)
+
+	err := store.Put(ctx, []byte("baz"), []byte("clyde"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.Start(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.Start(ctx)
+	if err != db.ErrTxExist {
+		t.Fatalf("expected ErrTxExist, got %v", err)
+	}
+	err = store.Put(ctx, []byte("foo"), []byte("inky"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Abort(ctx)
+	_, err = store.Get(ctx, []byte("foo"))
+	if !db.IsNotFound(err) {
+		t.Fatalf("expected not found after abort, got %v", err)
+	}
+
+	err = store.Start(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.Put(ctx, []byte("foo"), []byte("inky"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := store.Get(ctx, []byte("foo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(v, []byte("inky")) {
+		t.Fatalf("expected value 'inky' in tx, found '%s'", v)
+	}
+	err = store.Delete(ctx, []byte("baz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = store.Get(ctx, []byte("baz"))
+	if !db.IsNotFound(err) {
+		t.Fatalf("expected not found after delete in tx, got %v", err)
+	}
+	err = store.Stop(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err = store.Get(ctx, []byte("foo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(v, []byte("inky")) {
+		t.Fatalf("expected value 'inky', found '%s'", v)
+	}
+	_, err = store.Get(ctx, []byte("baz"))
+	if !db.IsNotFound(err) {
+		t.Fatalf("expected not found after commit, got %v", err)
+	}
+}
@@ -0,0 +1,237 @@
+package db
+
+import (
+	"context"
+	"sync"
+
+	"git.defalsify.org/vise.git/lang"
+)
+
+// SharedDb enables a single Db to be used concurrently by multiple goroutines.
+//
+// Every goroutine must use a separate view of the Db, created with View. The view keeps its own prefix, session and language context, and applies them to the shared Db while holding a lock for the duration of a single operation.
+//
+// A transaction started on a view holds the lock until it is stopped or aborted.
+type SharedDb struct {
+	db Db
+	mu sync.Mutex
+}
+
+// NewSharedDb creates a new SharedDb for an already connected Db.
+func NewSharedDb(store Db) *SharedDb {
+	return &SharedDb{
+		db: store,
+	}
+}
+
+// View creates a new Db that can be used independently of other views of the same SharedDb.
+func (s *SharedDb) View() Db {
+	return &sharedDbView{
+		shared: s,
+	}
+}
+
+// Close closes the underlying Db.
+func (s *SharedDb) Close(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.db.Close(ctx)
+}
+
+// sharedDbView is the Db implementation returned by SharedDb.View.
+type sharedDbView struct {
+	shared *SharedDb
+	pfx    uint8
+	sid    string
+	lang   *lang.Language
+	tx     bool
+}
+
+// acquire the shared lock and apply the view context to the shared db.
+func (v *sharedDbView) lock() Db {
+	if !v.tx {
+		v.shared.mu.Lock()
+	}
+	store := v.shared.db
+	store.SetPrefix(v.pfx)
+	store.SetSession(v.sid)
+	store.SetLanguage(v.lang)
+	return store
+}
+
+// release the shared lock, unless a transaction is in progress.
+func (v *sharedDbView) unlock() {
+	if !v.tx {
+		v.shared.mu.Unlock()
+	}
+}
+
+// Connect implements Db.
+//
+// The shared Db must already be connected, so this is a noop.
+func (v *sharedDbView) Connect(ctx context.Context, connStr string) error {
+	return nil
+}
+
+// Close implements Db.
+//
+// The shared Db is not closed. Use SharedDb.Close instead.
+func (v *sharedDbView) Close(ctx context.Context) error {
+	if v.tx {
+		v.Abort(ctx)
+	}
+	return nil
+}
+
+// Get implements Db.
+func (v *sharedDbView) Get(ctx context.Context, key []byte) ([]byte, error) {
+	store := v.lock()
+	defer v.unlock()
+	return store.Get(ctx, key)
+}
+
+// Put implements Db.
+func (v *sharedDbView) Put(ctx context.Context, key []byte, val []byte) error {
+	store := v.lock()
+	defer v.unlock()
+	return store.Put(ctx, key, val)
+}
+
+// CompareAndSwap implements Db.
+func (v *sharedDbView) CompareAndSwap(ctx context.Context, key []byte, old []byte, val []byte) error {
+	store := v.lock()
+	defer v.unlock()
+	return store.CompareAndSwap(ctx, key, old, val)
+}
+
+// Delete implements Db.
+func (v *sharedDbView) Delete(ctx context.Context, key []byte) error {
+	store := v.lock()
+	defer v.unlock()
+	return store.Delete(ctx, key)
+}
+
+// CompareAndDelete implements Db.
+func (v *sharedDbView) CompareAndDelete(ctx context.Context, key []byte, old []byte) error {
+	store := v.lock()
+	defer v.unlock()
+	return store.CompareAndDelete(ctx, key, old)
+}
+
+// SetPrefix implements Db.
+func (v *sharedDbView) SetPrefix(pfx uint8) {
+	v.pfx = pfx
+}
+
+// SetSession implements Db.
+func (v *sharedDbView) SetSession(sessionId string) {
+	v.sid = sessionId
+}
+
+// SetLanguage implements Db.
+func (v *sharedDbView) SetLanguage(ln *lang.Language) {
+	v.lang = ln
+}
+
+// Prefix implements Db.
+func (v *sharedDbView) Prefix() uint8 {
+	return v.pfx
+}
+
+// SetLock implements Db.
+//
+// Locks apply to the shared Db, and thus to all views.
+func (v *sharedDbView) SetLock(typ uint8, locked bool) error {
+	store := v.lock()
+	defer v.unlock()
+	return store.SetLock(typ, locked)
+}
+
+// Safe implements Db.
+func (v *sharedDbView) Safe() bool {
+	store := v.lock()
+	defer v.unlock()
+	return store.Safe()
+}
+
+// Dump implements Db.
+//
+// All matching entries are retrieved before the method returns, so that the lock is not held while iterating.
+func (v *sharedDbView) Dump(ctx context.Context, key []byte) (*Dumper, error) {
+	var ks [][]byte
+	var vs [][]byte
+	store := v.lock()
+	defer v.unlock()
+	d, err := store.Dump(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	for true {
+		k, val := d.Next(ctx)
+		if k == nil {
+			break
+		}
+		ks = append(ks, k)
+		vs = append(vs, val)
+	}
+	err = d.Close()
+	if err != nil {
+		return nil, err
+	}
+	if len(ks) == 0 {
+		return nil, NewErrNotFound(key)
+	}
+	return NewSliceDumper(ks, vs), nil
+}
+
+// DecodeKey implements Db.
+func (v *sharedDbView) DecodeKey(ctx context.Context, key []byte) ([]byte, error) {
+	store := v.lock()
+	defer v.unlock()
+	return store.DecodeKey(ctx, key)
+}
+
+// Start implements Db.
+//
+// The lock on the shared Db is held until Stop or Abort is called.
+func (v *sharedDbView) Start(ctx context.Context) error {
+	if v.tx {
+		return ErrTxExist
+	}
+	store := v.lock()
+	err := store.Start(ctx)
+	if err != nil {
+		v.unlock()
+		return err
+	}
+	v.tx = true
+	return nil
+}
+
+// Stop implements Db.
+func (v *sharedDbView) Stop(ctx context.Context) error {
+	if !v.tx {
+		return ErrNoTx
+	}
+	store := v.lock()
+	err := store.Stop(ctx)
+	v.tx = false
+	v.unlock()
+	return err
+}
+
+// Abort implements Db.
+func (v *sharedDbView) Abort(ctx context.Context) {
+	if !v.tx {
+		return
+	}
+	store := v.lock()
+	store.Abort(ctx)
+	v.tx = false
+	v.unlock()
+}
+
+// Connection implements Db.
+func (v *sharedDbView) Connection() string {
+	return v.shared.db.Connection()
+}
@@ -0,0 +1,6 @@
+// Package sqlite is a SQLite database backed implementation of the db.Db interface.
+//
+// The sqlite implementation of the vise key-value store uses the same schema as the postgres implementation; two data columns of type `BLOB` for each key and value, aswell as an `updated` field of type `TIMESTAMP` that is set to the current time when an update is made.
+//
+// It uses the pure Go modernc.org/sqlite driver, so it builds without cgo.
+package sqlite
@@ -0,0 +1,69 @@
+package sqlite
+
+import (
+	"bytes"
+	"context"
+
+	"git.defalsify.org/vise.git/db"
+)
+
+// Dump implements Db.
+//
+// All matching entries are retrieved before the method returns, since the database connection is not shared with the iterator.
+func (sdb *sqliteDb) Dump(ctx context.Context, key []byte) (*db.Dumper, error) {
+	var ks [][]byte
+	var vs [][]byte
+
+	sdb.SetLanguage(nil)
+	lk, err := sdb.ToKey(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	k := lk.Default
+
+	err = sdb.start(ctx)
+	if err != nil {
+		return nil, err
+	}
+	rs, err := sdb.tx.QueryContext(ctx, "SELECT key, value FROM kv_vise WHERE key >= ?1 ORDER BY key", k)
+	if err != nil {
+		logg.Debugf("query fail", "err", err)
+		sdb.Abort(ctx)
+		return nil, err
+	}
+	for rs.Next() {
+		var kk []byte
+		var vv []byte
+		err = rs.Scan(&kk, &vv)
+		if err != nil {
+			rs.Close()
+			sdb.Abort(ctx)
+			return nil, err
+		}
+		if !bytes.HasPrefix(kk, k) {
+			break
+		}
+		kk, err = sdb.DecodeKey(ctx, kk)
+		if err != nil {
+			rs.Close()
+			sdb.Abort(ctx)
+			return nil, err
+		}
+		ks = append(ks, kk)
+		vs = append(vs, vv)
+	}
+	err = rs.Close()
+	if err != nil {
+		sdb.Abort(ctx)
+		return nil, err
+	}
+	err = sdb.stopSingle(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ks) == 0 {
+		return nil, db.NewErrNotFound(k)
+	}
+	return db.NewSliceDumper(ks, vs), nil
+}
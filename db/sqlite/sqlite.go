@@ -0,0 +1,347 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	_ "modernc.org/sqlite"
+
+	"git.defalsify.org/vise.git/db"
+)
+
+// sqliteDb is a SQLite backend implementation of the Db interface.
+type sqliteDb struct {
+	*db.DbBase
+	conn  *sql.DB
+	prepd bool
+	tx    *sql.Tx
+	multi bool
+}
+
+// NewSqliteDb creates a new SQLite backed Db implementation.
+func NewSqliteDb() *sqliteDb {
+	db := &sqliteDb{
+		DbBase: db.NewDbBase(),
+	}
+	return db
+}
+
+// Connect implements Db.
+//
+// The connection string is the path to the database file, or any other data source name understood by the modernc.org/sqlite driver.
+func (sdb *sqliteDb) Connect(ctx context.Context, connStr string) error {
+	if sdb.conn != nil {
+		logg.WarnCtxf(ctx, "Sqlite already connected")
+		return nil
+	}
+	conn, err := sql.Open("sqlite", connStr)
+	if err != nil {
+		return err
+	}
+	// sqlite only allows a single writer, and in-memory databases are per connection.
+	conn.SetMaxOpenConns(1)
+	err = conn.PingContext(ctx)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	sdb.conn = conn
+	sdb.DbBase.Connect(ctx, connStr)
+	return sdb.ensureTable(ctx)
+}
+
+// Start implements Db.
+func (sdb *sqliteDb) Start(ctx context.Context) error {
+	if sdb.tx != nil {
+		return db.ErrTxExist
+	}
+	err := sdb.start(ctx)
+	if err != nil {
+		return err
+	}
+	sdb.multi = true
+	return nil
+}
+
+func (sdb *sqliteDb) start(ctx context.Context) error {
+	if sdb.tx != nil {
+		return nil
+	}
+	tx, err := sdb.conn.BeginTx(ctx, nil)
+	logg.TraceCtxf(ctx, "begin single tx", "err", err)
+	if err != nil {
+		return err
+	}
+	sdb.tx = tx
+	return nil
+}
+
+// Stop implements Db.
+func (sdb *sqliteDb) Stop(ctx context.Context) error {
+	if !sdb.multi {
+		return db.ErrSingleTx
+	}
+	return sdb.stop(ctx)
+}
+
+func (sdb *sqliteDb) stopSingle(ctx context.Context) error {
+	if sdb.multi {
+		return nil
+	}
+	err := sdb.tx.Commit()
+	logg.TraceCtxf(ctx, "stop single tx", "err", err)
+	sdb.tx = nil
+	return err
+}
+
+func (sdb *sqliteDb) stop(ctx context.Context) error {
+	if sdb.tx == nil {
+		return db.ErrNoTx
+	}
+	err := sdb.tx.Commit()
+	logg.TraceCtxf(ctx, "stop multi tx", "err", err)
+	sdb.tx = nil
+	sdb.multi = false
+	return err
+}
+
+// Abort implements Db.
+func (sdb *sqliteDb) Abort(ctx context.Context) {
+	logg.InfoCtxf(ctx, "aborting tx", "tx", sdb.tx)
+	if sdb.tx != nil {
+		sdb.tx.Rollback()
+	}
+	sdb.tx = nil
+	sdb.multi = false
+}
+
+// the key to write to for the current language context.
+func (sdb *sqliteDb) actualKey(lk db.LookupKey) []byte {
+	if lk.Translation != nil {
+		return lk.Translation
+	}
+	return lk.Default
+}
+
+// Put implements Db.
+func (sdb *sqliteDb) Put(ctx context.Context, key []byte, val []byte) error {
+	if !sdb.CheckPut() {
+		return errors.New("unsafe put and safety set")
+	}
+
+	lk, err := sdb.ToKey(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	err = sdb.start(ctx)
+	if err != nil {
+		return err
+	}
+	logg.TraceCtxf(ctx, "put", "key", key, "val", val)
+	query := "INSERT INTO kv_vise (key, value, updated) VALUES (?1, ?2, CURRENT_TIMESTAMP) ON CONFLICT(key) DO UPDATE SET value = ?2, updated = CURRENT_TIMESTAMP;"
+	_, err = sdb.tx.ExecContext(ctx, query, sdb.actualKey(lk), val)
+	if err != nil {
+		sdb.Abort(ctx)
+		return err
+	}
+
+	return sdb.stopSingle(ctx)
+}
+
+// CompareAndSwap implements Db.
+func (sdb *sqliteDb) CompareAndSwap(ctx context.Context, key []byte, old []byte, val []byte) error {
+	var query string
+	var args []any
+	if !sdb.CheckPut() {
+		return errors.New("unsafe put and safety set")
+	}
+
+	lk, err := sdb.ToKey(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	err = sdb.start(ctx)
+	if err != nil {
+		return err
+	}
+	logg.TraceCtxf(ctx, "cas", "key", key, "old", old, "val", val)
+	if old == nil {
+		query = "INSERT INTO kv_vise (key, value, updated) VALUES (?1, ?2, CURRENT_TIMESTAMP) ON CONFLICT(key) DO NOTHING;"
+		args = []any{sdb.actualKey(lk), val}
+	} else {
+		query = "UPDATE kv_vise SET value = ?2, updated = CURRENT_TIMESTAMP WHERE key = ?1 AND value = ?3;"
+		args = []any{sdb.actualKey(lk), val, old}
+	}
+
+	r, err := sdb.tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		sdb.Abort(ctx)
+		return err
+	}
+	c, err := r.RowsAffected()
+	if err != nil {
+		sdb.Abort(ctx)
+		return err
+	}
+
+	err = sdb.stopSingle(ctx)
+	if err != nil {
+		return err
+	}
+	if c == 0 {
+		return db.NewErrConflict(key)
+	}
+	return nil
+}
+
+// Delete implements Db.
+func (sdb *sqliteDb) Delete(ctx context.Context, key []byte) error {
+	if !sdb.CheckPut() {
+		return errors.New("unsafe delete and safety set")
+	}
+
+	lk, err := sdb.ToKey(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	err = sdb.start(ctx)
+	if err != nil {
+		return err
+	}
+	logg.TraceCtxf(ctx, "delete", "key", key)
+	r, err := sdb.tx.ExecContext(ctx, "DELETE FROM kv_vise WHERE key = ?1;", sdb.actualKey(lk))
+	if err != nil {
+		sdb.Abort(ctx)
+		return err
+	}
+	c, err := r.RowsAffected()
+	if err != nil {
+		sdb.Abort(ctx)
+		return err
+	}
+
+	err = sdb.stopSingle(ctx)
+	if err != nil {
+		return err
+	}
+	if c == 0 {
+		return db.NewErrNotFound(key)
+	}
+	return nil
+}
+
+// CompareAndDelete implements Db.
+func (sdb *sqliteDb) CompareAndDelete(ctx context.Context, key []byte, old []byte) error {
+	if !sdb.CheckPut() {
+		return errors.New("unsafe delete and safety set")
+	}
+
+	lk, err := sdb.ToKey(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	err = sdb.start(ctx)
+	if err != nil {
+		return err
+	}
+	logg.TraceCtxf(ctx, "cad", "key", key, "old", old)
+	r, err := sdb.tx.ExecContext(ctx, "DELETE FROM kv_vise WHERE key = ?1 AND value = ?2;", sdb.actualKey(lk), old)
+	if err != nil {
+		sdb.Abort(ctx)
+		return err
+	}
+	c, err := r.RowsAffected()
+	if err != nil {
+		sdb.Abort(ctx)
+		return err
+	}
+
+	err = sdb.stopSingle(ctx)
+	if err != nil {
+		return err
+	}
+	if c == 0 {
+		return db.NewErrConflict(key)
+	}
+	return nil
+}
+
+// Get implements Db.
+func (sdb *sqliteDb) Get(ctx context.Context, key []byte) ([]byte, error) {
+	var rr []byte
+	lk, err := sdb.ToKey(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	err = sdb.start(ctx)
+	if err != nil {
+		return nil, err
+	}
+	logg.TraceCtxf(ctx, "get", "key", key)
+
+	query := "SELECT value FROM kv_vise WHERE key = ?1"
+	if lk.Translation != nil {
+		err = sdb.tx.QueryRowContext(ctx, query, lk.Translation).Scan(&rr)
+		if err == nil {
+			return rr, sdb.stopSingle(ctx)
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			sdb.Abort(ctx)
+			return nil, err
+		}
+	}
+
+	err = sdb.tx.QueryRowContext(ctx, query, lk.Default).Scan(&rr)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			sdb.Abort(ctx)
+			return nil, err
+		}
+		err = sdb.stopSingle(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return nil, db.NewErrNotFound(key)
+	}
+	return rr, sdb.stopSingle(ctx)
+}
+
+// Close implements Db.
+func (sdb *sqliteDb) Close(ctx context.Context) error {
+	err := sdb.Stop(ctx)
+	if err == db.ErrNoTx || err == db.ErrSingleTx {
+		err = nil
+	}
+	cerr := sdb.conn.Close()
+	if err != nil {
+		return err
+	}
+	return cerr
+}
+
+// set up table
+func (sdb *sqliteDb) ensureTable(ctx context.Context) error {
+	if sdb.prepd {
+		logg.WarnCtxf(ctx, "ensureTable called more than once")
+		return nil
+	}
+	query := `CREATE TABLE IF NOT EXISTS kv_vise (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		key BLOB NOT NULL UNIQUE,
+		value BLOB NOT NULL,
+		updated TIMESTAMP NOT NULL
+	);
+`
+	_, err := sdb.conn.ExecContext(ctx, query)
+	if err != nil {
+		return err
+	}
+	sdb.prepd = true
+	return nil
+}
@@ -0,0 +1,133 @@
+package sqlite
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"path"
+	"testing"
+
+	"git.defalsify.org/vise.git/db"
+	"git.defalsify.org/vise.git/db/dbtest"
+)
+
+func newTestDb(t *testing.T) *sqliteDb {
+	ctx := context.Background()
+	d, err := ioutil.TempDir("", "vise-db-sqlite-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := NewSqliteDb()
+	err = store.Connect(ctx, path.Join(d, "vise.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func TestCasesSqlite(t *testing.T) {
+	ctx := context.Background()
+	store := newTestDb(t)
+	err := dbtest.RunTests(t, ctx, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPutGetSqlite(t *testing.T) {
+	var dbi db.Db
+	ctx := context.Background()
+	store := newTestDb(t)
+	store.SetPrefix(db.DATATYPE_USERDATA)
+	store.SetSession("ses")
+
+	dbi = store
+	_ = dbi
+
+	err := store.Put(ctx, []byte("foo"), []byte("bar"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := store.Get(ctx, []byte("foo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(v, []byte("bar")) {
+		t.Fatalf("expected value 'bar', found '%s'", v)
+	}
+	err = store.Put(ctx, []byte("foo"), []byte("baz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err = store.Get(ctx, []byte("foo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(v, []byte("baz")) {
+		t.Fatalf("expected value 'baz', found '%s'", v)
+	}
+	_, err = store.Get(ctx, []byte("bar"))
+	if !db.IsNotFound(err) {
+		t.Fatalf("expected not found for key 'bar', got %v", err)
+	}
+	err = store.Close(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTxSqlite(t *testing.T) {
+	ctx := context.Background()
+	store := newTestDb(t)
+	store.SetPrefix(db.DATATYPE_USERDATA)
+
+	err := store.Start(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.Start(ctx)
+	if err != db.ErrTxExist {
+		t.Fatalf("expected ErrTxExist, got %v", err)
+	}
+	err = store.Put(ctx, []byte("foo"), []byte("inky"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.Put(ctx, []byte("bar"), []byte("pinky"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Abort(ctx)
+	_, err = store.Get(ctx, []byte("foo"))
+	if !db.IsNotFound(err) {
+		t.Fatalf("expected not found after abort, got %v", err)
+	}
+
+	err = store.Start(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.Put(ctx, []byte("foo"), []byte("inky"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.Put(ctx, []byte("bar"), []byte("pinky"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.Stop(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.Stop(ctx)
+	if err != db.ErrSingleTx {
+		t.Fatalf("expected ErrSingleTx, got %v", err)
+	}
+	v, err := store.Get(ctx, []byte("bar"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(v, []byte("pinky")) {
+		t.Fatalf("expected value 'pinky', found '%s'", v)
+	}
+}
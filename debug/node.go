@@ -9,6 +9,7 @@ type Node struct {
 var (
 	NodeIndex = make(map[string]Node)
 	MenuIndex = make(map[string]int)
+	FlagIndex = make(map[uint32]int)
 )
 
 func (n *Node) haveConn(peer string) bool {
@@ -56,3 +57,8 @@ func AddMenu(s string) int {
 	MenuIndex[s] += 1
 	return MenuIndex[s]
 }
+
+func AddFlag(flag uint32) int {
+	FlagIndex[flag] += 1
+	return FlagIndex[flag]
+}
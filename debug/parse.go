@@ -11,11 +11,14 @@ var (
 
 type NodeParseHandler struct {
 	*vm.ParseHandler
-	node            *Node
-	parentMOutFunc  func(string, string) error
-	parentMoveFunc  func(string) error
-	parentInCmpFunc func(string, string) error
-	parentCatchFunc func(string, uint32, bool) error
+	node             *Node
+	parentMOutFunc   func(string, string) error
+	parentMoveFunc   func(string) error
+	parentInCmpFunc  func(string, string) error
+	parentInPatFunc  func(string, string) error
+	parentCatchFunc  func(string, uint32, bool) error
+	parentFSetFunc   func(uint32) error
+	parentFResetFunc func(uint32) error
 }
 
 func NewNodeParseHandler(node *Node) *NodeParseHandler {
@@ -26,12 +29,18 @@ func NewNodeParseHandler(node *Node) *NodeParseHandler {
 	np.node.Name = node.Name
 	np.parentMoveFunc = np.ParseHandler.Move
 	np.parentInCmpFunc = np.ParseHandler.InCmp
+	np.parentInPatFunc = np.ParseHandler.InPat
 	np.parentCatchFunc = np.ParseHandler.Catch
 	np.parentMOutFunc = np.ParseHandler.MOut
+	np.parentFSetFunc = np.ParseHandler.FSet
+	np.parentFResetFunc = np.ParseHandler.FReset
 	np.Move = np.move
 	np.InCmp = np.incmp
+	np.InPat = np.inpat
 	np.Catch = np.catch
 	np.MOut = np.mout
+	np.FSet = np.fset
+	np.FReset = np.freset
 	return np
 }
 
@@ -69,6 +78,20 @@ func (np *NodeParseHandler) incmp(sym string, sel string) error {
 	return np.parentInCmpFunc(sym, sel)
 }
 
+func (np *NodeParseHandler) inpat(sym string, class string) error {
+	var node Node
+
+	if sym == "<" || sym == ">" || sym == "^" || sym == "_" || sym == "." {
+		logg.Debugf("skip relative move")
+		return np.parentInPatFunc(sym, class)
+	}
+
+	node.Name = sym
+	np.node.Connect(node)
+	logg.Debugf("connect INPAT", "src", np.node.Name, "dst", node.Name, "class", class)
+	return np.parentInPatFunc(sym, class)
+}
+
 func (np *NodeParseHandler) catch(sym string, flag uint32, inv bool) error {
 	var node Node
 
@@ -82,3 +105,15 @@ func (np *NodeParseHandler) catch(sym string, flag uint32, inv bool) error {
 	logg.Debugf("connect CATCH", "src", np.node.Name, "dst", node.Name)
 	return np.parentCatchFunc(sym, flag, inv)
 }
+
+func (np *NodeParseHandler) fset(flag uint32) error {
+	c := AddFlag(flag)
+	logg.Debugf("add FSET", "src", np.node.Name, "flag", flag, "visited", c)
+	return np.parentFSetFunc(flag)
+}
+
+func (np *NodeParseHandler) freset(flag uint32) error {
+	c := AddFlag(flag)
+	logg.Debugf("add FRESET", "src", np.node.Name, "flag", flag, "visited", c)
+	return np.parentFResetFunc(flag)
+}
@@ -0,0 +1,369 @@
+package debug
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"git.defalsify.org/vise.git/asm"
+	"git.defalsify.org/vise.git/state"
+	"git.defalsify.org/vise.git/vm"
+)
+
+const (
+	// Break when entering a node.
+	BREAK_NODE = "node"
+	// Break before an instruction with the given opcode.
+	BREAK_OP = "op"
+	// Break after an instruction that changes the given flag.
+	BREAK_FLAG = "flag"
+)
+
+const debuggerHelp = `commands:
+	s, step			execute next instruction and stop
+	c, continue		continue until next breakpoint
+	b node|op|flag <v>	add breakpoint on entering node, before opcode, or on flag change
+	bl			list breakpoints
+	bd <n>			delete breakpoint by number
+	st, state		show state
+	ca, cache [level]	show cache contents, for all levels or a single level
+	set <flag>		set flag
+	reset <flag>		reset flag
+	edit <sym> <value>	replace cache content of symbol
+	detach			remove all breakpoints and continue without stopping
+	h, help			show this help
+An empty line is the same as step.
+`
+
+// Breakpoint defines a condition on which the Debugger stops execution.
+type Breakpoint struct {
+	// Kind of breakpoint, one of BREAK_NODE, BREAK_OP or BREAK_FLAG.
+	Kind string
+	// Node symbol, if kind is BREAK_NODE.
+	Node string
+	// Opcode, if kind is BREAK_OP.
+	Op vm.Opcode
+	// Flag index, if kind is BREAK_FLAG.
+	Flag uint32
+}
+
+// String implements the String interface.
+func (bp Breakpoint) String() string {
+	switch bp.Kind {
+	case BREAK_NODE:
+		return fmt.Sprintf("%s %s", bp.Kind, bp.Node)
+	case BREAK_OP:
+		return fmt.Sprintf("%s %s", bp.Kind, vm.OpcodeString[bp.Op])
+	}
+	return fmt.Sprintf("%s %d", bp.Kind, bp.Flag)
+}
+
+// Debugger is a vm.Hook implementation that stops execution on breakpoints or single steps, and reads commands to inspect and edit the state and cache while stopped.
+//
+// Commands are read one line at a time from the reader given to NewDebugger. If the same reader is used for the engine input, it must be the same *bufio.Reader.
+type Debugger struct {
+	pfx      string
+	r        *bufio.Reader
+	w        io.Writer
+	pp       *asm.FlagParser
+	bps      []Breakpoint
+	stepping bool
+	lastNode string
+}
+
+// NewDebugger creates a new Debugger reading commands from r and writing output to w.
+//
+// The Debugger stops before the first instruction executed.
+func NewDebugger(r io.Reader, w io.Writer) *Debugger {
+	return &Debugger{
+		pfx:      "DEBUG>",
+		r:        bufio.NewReader(r),
+		w:        w,
+		stepping: true,
+	}
+}
+
+// WithFlagParser is a chainable function that resolves flag names in commands using the given FlagParser.
+func (d *Debugger) WithFlagParser(pp *asm.FlagParser) *Debugger {
+	d.pp = pp
+	return d
+}
+
+// WithBreakpoints is a chainable function that adds breakpoints, and continues execution until the first of them is hit.
+func (d *Debugger) WithBreakpoints(bps []Breakpoint) *Debugger {
+	d.bps = append(d.bps, bps...)
+	if len(bps) > 0 {
+		d.stepping = false
+	}
+	return d
+}
+
+// ParseBreakpoint creates a Breakpoint from a kind and a value.
+//
+// Opcodes are given by their assembly name. Flags are given by their numeric value, or their name in the FlagParser, if set.
+func (d *Debugger) ParseBreakpoint(kind string, v string) (Breakpoint, error) {
+	bp := Breakpoint{
+		Kind: kind,
+	}
+	switch kind {
+	case BREAK_NODE:
+		bp.Node = v
+	case BREAK_OP:
+		op, ok := vm.OpcodeIndex[strings.ToUpper(v)]
+		if !ok {
+			return bp, fmt.Errorf("unknown opcode: %s", v)
+		}
+		bp.Op = op
+	case BREAK_FLAG:
+		flag, err := d.flag(v)
+		if err != nil {
+			return bp, err
+		}
+		bp.Flag = flag
+	default:
+		return bp, fmt.Errorf("unknown breakpoint kind: %s", kind)
+	}
+	return bp, nil
+}
+
+// Before implements the vm.Hook interface.
+func (d *Debugger) Before(ctx context.Context, ev vm.HookEvent) {
+	if ev.Step == 1 {
+		d.lastNode = ""
+	}
+	enter := ev.Node != d.lastNode
+	d.lastNode = ev.Node
+	var hit []Breakpoint
+	for _, bp := range d.bps {
+		switch bp.Kind {
+		case BREAK_NODE:
+			if enter && bp.Node == ev.Node {
+				hit = append(hit, bp)
+			}
+		case BREAK_OP:
+			if bp.Op == ev.Op {
+				hit = append(hit, bp)
+			}
+		}
+	}
+	if !d.stepping && len(hit) == 0 {
+		return
+	}
+	d.printf("stopped before %s", instruction(ev))
+	for _, bp := range hit {
+		d.printf("breakpoint: %s", bp)
+	}
+	d.prompt(ctx, ev)
+}
+
+// After implements the vm.Hook interface.
+func (d *Debugger) After(ctx context.Context, ev vm.HookEvent) {
+	var hit []Breakpoint
+	for _, bp := range d.bps {
+		if bp.Kind != BREAK_FLAG {
+			continue
+		}
+		if flagOf(ev.FlagsBefore, bp.Flag) != flagOf(ev.FlagsAfter, bp.Flag) {
+			hit = append(hit, bp)
+		}
+	}
+	if ev.Err != nil && d.stepping {
+		d.printf("error: %v", ev.Err)
+	}
+	if len(hit) == 0 {
+		return
+	}
+	d.printf("stopped after %s", instruction(ev))
+	for _, bp := range hit {
+		d.printf("breakpoint: %s, now %v", bp, flagOf(ev.FlagsAfter, bp.Flag))
+	}
+	d.prompt(ctx, ev)
+}
+
+// read and execute commands until execution is resumed.
+func (d *Debugger) prompt(ctx context.Context, ev vm.HookEvent) {
+	for {
+		fmt.Fprintf(d.w, "%s ", d.pfx)
+		in, err := d.r.ReadString('\n')
+		if err != nil && in == "" {
+			logg.DebugCtxf(ctx, "debugger input ended, detaching", "err", err)
+			d.detach()
+			return
+		}
+		resume, err := d.command(ev, strings.Fields(in))
+		if err != nil {
+			d.printf("error: %v", err)
+		}
+		if resume {
+			return
+		}
+	}
+}
+
+// execute a single command, and return true if execution should be resumed.
+func (d *Debugger) command(ev vm.HookEvent, args []string) (bool, error) {
+	if len(args) == 0 {
+		args = []string{"s"}
+	}
+	switch args[0] {
+	case "s", "step":
+		d.stepping = true
+		return true, nil
+	case "c", "continue":
+		d.stepping = false
+		return true, nil
+	case "detach":
+		d.detach()
+		return true, nil
+	case "b":
+		if len(args) != 3 {
+			return false, fmt.Errorf("usage: b node|op|flag <value>")
+		}
+		bp, err := d.ParseBreakpoint(args[1], args[2])
+		if err != nil {
+			return false, err
+		}
+		d.bps = append(d.bps, bp)
+		d.printf("breakpoint %d: %s", len(d.bps)-1, bp)
+	case "bl":
+		for i, bp := range d.bps {
+			d.printf("%d: %s", i, bp)
+		}
+	case "bd":
+		if len(args) != 2 {
+			return false, fmt.Errorf("usage: bd <n>")
+		}
+		i, err := strconv.Atoi(args[1])
+		if err != nil || i < 0 || i >= len(d.bps) {
+			return false, fmt.Errorf("no breakpoint %s", args[1])
+		}
+		d.bps = append(d.bps[:i], d.bps[i+1:]...)
+	case "st", "state":
+		d.showState(ev.State)
+	case "ca", "cache":
+		return false, d.showCache(ev, args[1:])
+	case "set", "reset":
+		if len(args) != 2 {
+			return false, fmt.Errorf("usage: %s <flag>", args[0])
+		}
+		return false, d.editFlag(ev.State, args[0] == "set", args[1])
+	case "edit":
+		if len(args) < 3 {
+			return false, fmt.Errorf("usage: edit <sym> <value>")
+		}
+		return false, ev.Memory.Update(args[1], strings.Join(args[2:], " "))
+	case "h", "help":
+		fmt.Fprint(d.w, debuggerHelp)
+	default:
+		return false, fmt.Errorf("unknown command: %s", args[0])
+	}
+	return false, nil
+}
+
+// remove all breakpoints and stop single stepping.
+func (d *Debugger) detach() {
+	d.bps = []Breakpoint{}
+	d.stepping = false
+}
+
+// write state details.
+func (d *Debugger) showState(st *state.State) {
+	node, lvl := st.Where()
+	d.printf("node: %s (%d)", node, lvl)
+	d.printf("path: %s", strings.Join(st.ExecPath, "/"))
+	d.printf("flags: %s", state.FlagDebugger.AsString(st.Flags, st.BitSize-8))
+	d.printf("sizeidx: %d", st.SizeIdx)
+	d.printf("moves: %d", st.Moves)
+	d.printf("calls: %d", st.CallDepth())
+	if st.Language != nil {
+		d.printf("language: %s", st.Language.Code)
+	}
+	input, err := st.GetInput()
+	if err == nil {
+		d.printf("input: %q", input)
+	}
+}
+
+// write cache contents for all levels, or the level given in args.
+func (d *Debugger) showCache(ev vm.HookEvent, args []string) error {
+	ca := ev.Memory
+	lo := uint32(0)
+	hi := ca.Levels()
+	if len(args) > 0 {
+		v, err := strconv.ParseUint(args[0], 10, 32)
+		if err != nil || uint32(v) >= hi {
+			return fmt.Errorf("no cache level %s", args[0])
+		}
+		lo = uint32(v)
+		hi = lo + 1
+	}
+	for i := lo; i < hi; i++ {
+		d.printf("level %d:", i)
+		for _, k := range ca.Keys(i) {
+			v, err := ca.Get(k)
+			if err != nil {
+				continue
+			}
+			d.printf("\t%s: %q", k, v)
+		}
+	}
+	return nil
+}
+
+// set or reset a flag.
+func (d *Debugger) editFlag(st *state.State, set bool, v string) error {
+	flag, err := d.flag(v)
+	if err != nil {
+		return err
+	}
+	if flag >= st.BitSize {
+		return fmt.Errorf("flag %d out of range", flag)
+	}
+	if set {
+		st.SetFlag(flag)
+	} else {
+		st.ResetFlag(flag)
+	}
+	return nil
+}
+
+// resolve a flag by numeric value or name.
+func (d *Debugger) flag(v string) (uint32, error) {
+	n, err := strconv.ParseUint(v, 10, 32)
+	if err == nil {
+		return uint32(n), nil
+	}
+	if d.pp == nil {
+		return 0, fmt.Errorf("invalid flag: %s", v)
+	}
+	return d.pp.GetFlag(v)
+}
+
+// write a single output line.
+func (d *Debugger) printf(s string, args ...any) {
+	fmt.Fprintf(d.w, "%s %s\n", d.pfx, fmt.Sprintf(s, args...))
+}
+
+// describe the instruction of an event.
+func instruction(ev vm.HookEvent) string {
+	s := fmt.Sprintf("%d %s %s", ev.Step, ev.Node, vm.OpcodeString[ev.Op])
+	if len(ev.Args) > 0 {
+		s += " " + strings.Join(ev.Args, " ")
+	}
+	if ev.Source != nil {
+		s += " @" + ev.Source.String()
+	}
+	return s
+}
+
+// value of a single flag in a flag set.
+func flagOf(flags []byte, flag uint32) bool {
+	i := flag / 8
+	if int(i) >= len(flags) {
+		return false
+	}
+	return flags[i]&(1<<(flag%8)) > 0
+}
@@ -0,0 +1,125 @@
+package debug
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"git.defalsify.org/vise.git/cache"
+	"git.defalsify.org/vise.git/internal/resourcetest"
+	"git.defalsify.org/vise.git/resource"
+	"git.defalsify.org/vise.git/state"
+	"git.defalsify.org/vise.git/vm"
+)
+
+func getFoo(ctx context.Context, sym string, input []byte) (resource.Result, error) {
+	return resource.Result{
+		Content: "foo",
+	}, nil
+}
+
+func newTestVm(t *testing.T, d *Debugger) (*vm.Vm, *state.State, cache.Memory) {
+	ctx := context.Background()
+	st := state.NewState(1)
+	rs := resourcetest.NewTestResource()
+	rs.AddLocalFunc("foo", getFoo)
+	rs.AddTemplate(ctx, "root", "{{.foo}}")
+	rs.AddTemplate(ctx, "bar", "bar")
+	b := vm.NewLine(nil, vm.HALT, nil, nil, nil)
+	rs.AddBytecode(ctx, "bar", b)
+	rs.Lock()
+	ca := cache.NewCache()
+	vmi := vm.NewVm(st, rs, ca, nil).WithHook(d)
+	st.Down("root")
+	ca.Push()
+	return vmi, st, ca
+}
+
+func TestDebuggerStep(t *testing.T) {
+	ctx := context.Background()
+	in := "s\nst\nca\nedit foo bar\nset 8\nc\n"
+	w := bytes.NewBuffer(nil)
+	d := NewDebugger(strings.NewReader(in), w)
+	vmi, _, ca := newTestVm(t, d)
+
+	b := vm.NewLine(nil, vm.LOAD, []string{"foo"}, []byte{0x0a}, nil)
+	b = vm.NewLine(b, vm.CATCH, []string{"bar"}, []byte{8}, []byte{1})
+	b = vm.NewLine(b, vm.HALT, nil, nil, nil)
+	_, err := vmi.Run(ctx, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := w.String()
+	if !strings.Contains(s, "stopped before 1 root LOAD foo 10") {
+		t.Fatalf("missing first stop in output:\n%s", s)
+	}
+	if !strings.Contains(s, "stopped before 2 root CATCH bar 8 1") {
+		t.Fatalf("missing second stop in output:\n%s", s)
+	}
+	if !strings.Contains(s, "path: root") {
+		t.Fatalf("missing state in output:\n%s", s)
+	}
+	if !strings.Contains(s, "foo: \"foo\"") {
+		t.Fatalf("missing cache in output:\n%s", s)
+	}
+	if strings.Contains(s, "stopped before 3") {
+		t.Fatalf("unexpected stop after continue in output:\n%s", s)
+	}
+	v, err := ca.Get("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != "bar" {
+		t.Fatalf("expected edited cache value 'bar', got '%s'", v)
+	}
+	r, err := vmi.Render(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r != "bar" {
+		t.Fatalf("expected catch to node bar, got render '%s'", r)
+	}
+}
+
+func TestDebuggerBreakpoint(t *testing.T) {
+	ctx := context.Background()
+	w := bytes.NewBuffer(nil)
+	d := NewDebugger(strings.NewReader("c\nc\n"), w)
+	var bps []Breakpoint
+	bp, err := d.ParseBreakpoint(BREAK_FLAG, "2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bps = append(bps, bp)
+	bp, err = d.ParseBreakpoint(BREAK_NODE, "bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bps = append(bps, bp)
+	_, err = d.ParseBreakpoint(BREAK_OP, "NOSUCHOP")
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	d = d.WithBreakpoints(bps)
+	vmi, _, _ := newTestVm(t, d)
+
+	b := vm.NewLine(nil, vm.LOAD, []string{"foo"}, []byte{0x0a}, nil)
+	b = vm.NewLine(b, vm.MOVE, []string{"bar"}, nil, nil)
+	_, err = vmi.Run(ctx, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := w.String()
+	if strings.Contains(s, "root LOAD") {
+		t.Fatalf("unexpected stop in output:\n%s", s)
+	}
+	if !strings.Contains(s, "stopped before 3 bar HALT\nDEBUG> breakpoint: node bar") {
+		t.Fatalf("missing node breakpoint in output:\n%s", s)
+	}
+	if !strings.Contains(s, "stopped after 3 bar HALT\nDEBUG> breakpoint: flag 2, now true") {
+		t.Fatalf("missing flag breakpoint in output:\n%s", s)
+	}
+}
@@ -1,11 +1,12 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"os"
+	"path"
 	"strconv"
 	"strings"
 
@@ -13,22 +14,30 @@ import (
 	"github.com/alecthomas/participle/v2/lexer"
 
 	"git.defalsify.org/vise.git/asm"
+	"git.defalsify.org/vise.git/logging"
+	"git.defalsify.org/vise.git/vm"
+)
+
+var (
+	logg = logging.NewVanilla()
 )
 
 type arg struct {
-	One   *string `(@Sym | @NumFirst)`
-	Two   *string `((@Sym | @NumFirst) Whitespace?)?`
-	Three *string `((@Sym | @NumFirst) Whitespace?)?`
+	One   *string `(@Sym | @NumFirst | @String)`
+	Two   *string `((@Sym | @NumFirst | @String) Whitespace?)?`
+	Three *string `((@Sym | @NumFirst | @String) Whitespace?)?`
 }
 
 type instruction struct {
-	OpCode  string `@Ident`
-	OpArg   arg    `(Whitespace @@)?`
-	Comment string `Comment? EOL`
+	Pos     lexer.Position
+	Label   *string `( @Sym Colon Comment? EOL`
+	OpCode  string  `| @Ident`
+	OpArg   arg     `(Whitespace @@)?`
+	Comment string  `Comment? EOL )`
 }
 
 type asmAsm struct {
-	Instructions []*instruction `@@*`
+	Instructions []*instruction `EOL* (@@ EOL*)*`
 }
 
 type processor struct {
@@ -43,18 +52,25 @@ func newProcessor(fp string) (*processor, error) {
 	return o, err
 }
 
+func (p *processor) translateFlag(one string) (string, error) {
+	_, err := strconv.Atoi(one)
+	if err == nil {
+		return one, nil
+	}
+	r, err := p.GetAsString(one)
+	if err != nil {
+		return "", err
+	}
+	logg.Debugf("translated flag", "flag", one, "value", r)
+	return r, nil
+}
+
 func (p *processor) processFlag(s []string, one *string, two *string) ([]string, error) {
-	_, err := strconv.Atoi(*one)
+	r, err := p.translateFlag(*one)
 	if err != nil {
-		r, err := p.GetAsString(*one)
-		if err != nil {
-			return nil, err
-		}
-		log.Printf("translated flag %s to %s", *one, r)
-		s = append(s, r)
-	} else {
-		s = append(s, *one)
+		return nil, err
 	}
+	s = append(s, r)
 	return append(s, *two), nil
 }
 
@@ -68,7 +84,17 @@ func (p *processor) pass(s []string, a arg) []string {
 	return s
 }
 
-func (pp *processor) run(b []byte) ([]byte, error) {
+// translate flag names to flag values in the expanded source, and report errors as diagnostics at the source positions the lines were produced from.
+func (pp *processor) run(src *asm.Source) (*asm.Source, asm.Diagnostics) {
+	var diags asm.Diagnostics
+	diag := func(line int, column int, msg string) asm.Diagnostic {
+		pos, trace := src.Pos(line, column)
+		return asm.Diagnostic{
+			Pos:   pos,
+			Msg:   msg,
+			Trace: trace,
+		}
+	}
 	asmLexer := lexer.MustSimple([]lexer.SimpleRule{
 		{"Comment", `(?:#)[^\n]*`},
 		{"Ident", `^[A-Z]+`},
@@ -76,47 +102,89 @@ func (pp *processor) run(b []byte) ([]byte, error) {
 		{"Sym", `[a-zA-Z_\*\.\^\<\>][a-zA-Z0-9_]*`},
 		{"Whitespace", `[ \t]+`},
 		{"EOL", `[\n\r]+`},
+		{"String", `"(?:\\.|[^"\\\n])*"`},
 		{"Quote", `["']`},
+		{"Colon", `:`},
 	})
 	asmParser := participle.MustBuild[asmAsm](
 		participle.Lexer(asmLexer),
 		participle.Elide("Comment", "Whitespace"),
 	)
-	ast, err := asmParser.ParseString("preprocessor", string(b))
+	ast, err := asmParser.ParseString("preprocessor", src.String())
 	if err != nil {
-		return nil, err
+		d := diag(0, 0, err.Error())
+		var perr participle.Error
+		if errors.As(err, &perr) {
+			d = diag(perr.Position().Line, perr.Position().Column, perr.Message())
+		}
+		return nil, append(diags, d)
 	}
 
-	b = []byte{}
+	b := []byte{}
+	line := 1
 	for _, v := range ast.Instructions {
+		// keep lines and columns of the source, so that source positions remain valid after preprocessing.
+		for ; line < v.Pos.Line; line++ {
+			b = append(b, 0x0a)
+		}
+		b = append(b, []byte(strings.Repeat(" ", v.Pos.Column-1))...)
+		line++
+		if v.Label != nil {
+			b = append(b, []byte(*v.Label+":")...)
+			b = append(b, 0x0a)
+			continue
+		}
 		s := []string{v.OpCode}
 		if v.OpArg.One != nil {
 			switch v.OpCode {
-			case "CATCH":
+			case "CATCH", "JUMPIF":
 				s = append(s, *v.OpArg.One)
-				s, err = pp.processFlag(s, v.OpArg.Two, v.OpArg.Three)
-				if err != nil {
-					return nil, err
+				if v.OpArg.Two == nil || v.OpArg.Three == nil {
+					s = pp.pass(s[:1], v.OpArg)
+					break
 				}
+				s, err = pp.processFlag(s, v.OpArg.Two, v.OpArg.Three)
 			case "CROAK":
-				s, err = pp.processFlag(s, v.OpArg.One, v.OpArg.Two)
-				if err != nil {
-					return nil, err
+				if v.OpArg.Two == nil {
+					s = pp.pass(s, v.OpArg)
+					break
 				}
+				s, err = pp.processFlag(s, v.OpArg.One, v.OpArg.Two)
+			case "FSET", "FRESET":
+				var r string
+				r, err = pp.translateFlag(*v.OpArg.One)
+				s = append(s, r)
 			default:
 				s = pp.pass(s, v.OpArg)
 			}
 		}
+		if err != nil {
+			diags = append(diags, diag(v.Pos.Line, v.Pos.Column, fmt.Sprintf("%s: %v", v.OpCode, err)))
+			err = nil
+			s = pp.pass(s[:1], v.OpArg)
+		}
 		b = append(b, []byte(strings.Join(s, " "))...)
 		b = append(b, 0x0a)
 	}
 
-	return b, nil
+	return src.WithText(string(b)), diags
+}
+
+// write diagnostics to stderr, and return true if there are any errors.
+func report(diags asm.Diagnostics) bool {
+	for _, v := range diags {
+		fmt.Fprintln(os.Stderr, v.Error())
+	}
+	return len(diags.Errors()) > 0
 }
 
 func main() {
 	var ppfp string
+	var mapfp string
+	var labeldir string
 	flag.StringVar(&ppfp, "f", "", "preprocessor data to load")
+	flag.StringVar(&mapfp, "m", "", "source map file to write")
+	flag.StringVar(&labeldir, "l", "", "directory to write quoted menu labels to as menu resources")
 	flag.Parse()
 	if len(flag.Args()) < 1 {
 		os.Exit(1)
@@ -128,6 +196,17 @@ func main() {
 		os.Exit(1)
 	}
 
+	var ml *asm.MenuLabels
+	app := asm.NewPreprocessor()
+	if len(labeldir) > 0 {
+		ml = asm.NewMenuLabels()
+		app = app.WithMenuLabels(ml)
+	}
+	src, diags := app.Process(string(v), fp)
+	if report(diags) {
+		os.Exit(1)
+	}
+
 	if len(ppfp) > 0 {
 		pp, err := newProcessor(ppfp)
 		if err != nil {
@@ -135,18 +214,37 @@ func main() {
 			os.Exit(1)
 		}
 
-		v, err = pp.run(v)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "preprocess error: %v\n", err)
+		src, diags = pp.run(src)
+		if report(diags) {
 			os.Exit(1)
 		}
+		logg.Debugf("preprocessor done")
 	}
-	log.Printf("preprocessor done")
 
-	n, err := asm.Parse(string(v), os.Stdout)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "parse error: %v\n", err)
+	var sm *vm.SourceMap
+	if len(mapfp) > 0 {
+		sm = vm.NewSourceMap()
+	}
+	n, diags := asm.ParseSource(src, os.Stdout, sm)
+	if report(diags) {
 		os.Exit(1)
 	}
-	log.Printf("parsed total %v bytes", n)
+	logg.Debugf("parsed", "bytes", n)
+	if sm != nil {
+		err = ioutil.WriteFile(mapfp, sm.Bytes(), 0644)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "source map write error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if ml != nil {
+		for _, k := range ml.Keys() {
+			label, _ := ml.Get(k)
+			err = ioutil.WriteFile(path.Join(labeldir, k+"_menu"), []byte(label), 0644)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "menu label write error: %v\n", err)
+				os.Exit(1)
+			}
+		}
+	}
 }
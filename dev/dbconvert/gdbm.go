@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"errors"
+
+	gdbm "github.com/graygnuorg/go-gdbm"
+
+	"git.defalsify.org/vise.git/db"
+	"git.defalsify.org/vise.git/lang"
+)
+
+// split the language code from a raw key of a translatable data type, if present.
+func languageFromKey(typ uint8, k []byte) ([]byte, *lang.Language) {
+	if typ&(db.DATATYPE_MENU|db.DATATYPE_TEMPLATE|db.DATATYPE_STATICLOAD) == 0 {
+		return k, nil
+	}
+	if len(k) < 5 || k[len(k)-4] != '_' {
+		return k, nil
+	}
+	ln, err := lang.LanguageFromCode(string(k[len(k)-3:]))
+	if err != nil {
+		return k, nil
+	}
+	return k[:len(k)-4], &ln
+}
+
+// migrateGdbm copies all entries of an existing gdbm database into the given db.Db.
+//
+// Keys are copied verbatim, including session and language context.
+func migrateGdbm(ctx context.Context, fp string, store db.Db) (int, error) {
+	var c int
+	src, err := gdbm.OpenConfig(gdbm.DatabaseConfig{
+		FileName: fp,
+		Mode:     gdbm.ModeReader,
+		Flags:    gdbm.OF_NOLOCK,
+	})
+	if err != nil {
+		return c, err
+	}
+	defer src.Close()
+
+	store.SetSession("")
+	it := src.Iterator()
+	for true {
+		k, err := it()
+		if err != nil {
+			if errors.Is(err, gdbm.ErrItemNotFound) {
+				break
+			}
+			return c, err
+		}
+		if len(k) < 2 {
+			logg.WarnCtxf(ctx, "skip invalid key", "key", k)
+			continue
+		}
+		v, err := src.Fetch(k)
+		if err != nil {
+			return c, err
+		}
+		typ := k[0]
+		kk, ln := languageFromKey(typ, k[1:])
+		store.SetPrefix(typ)
+		store.SetLanguage(ln)
+		store.SetLock(typ, false)
+		logg.TraceCtxf(ctx, "migrate record", "typ", typ, "key", kk, "lang", ln)
+		err = store.Put(ctx, kk, v)
+		if err != nil {
+			return c, err
+		}
+		c += 1
+	}
+	return c, nil
+}
@@ -1,4 +1,6 @@
 // Executable dbconvert processes a given directory recursively and inserts all legacy template files, menu files and bytecode files into corresponding db.Db entries of the chosen backend.
+//
+// Alternatively, all entries of an existing gdbm database can be migrated to the chosen backend.
 package main
 
 import (
@@ -14,6 +16,7 @@ import (
 	"strings"
 
 	"git.defalsify.org/vise.git/db"
+	boltdb "git.defalsify.org/vise.git/db/bolt"
 	fsdb "git.defalsify.org/vise.git/db/fs"
 	gdbmdb "git.defalsify.org/vise.git/db/gdbm"
 	"git.defalsify.org/vise.git/logging"
@@ -115,8 +118,10 @@ func main() {
 	var dbPath string
 	var dbFile string
 	var dbBackend string
+	var gdbmSrc string
 	flag.StringVar(&dbPath, "d", "", "output directory")
-	flag.StringVar(&dbBackend, "backend", "gdbm", "db backend. valid choices are: gdbm (default), fs")
+	flag.StringVar(&dbBackend, "backend", "gdbm", "db backend. valid choices are: gdbm (default), bolt, fs")
+	flag.StringVar(&gdbmSrc, "gdbm", "", "migrate entries from existing gdbm database file instead of scanning directory")
 	flag.Parse()
 
 	ctx := context.Background()
@@ -124,8 +129,14 @@ func main() {
 	case "gdbm":
 		store = gdbmdb.NewGdbmDb()
 		dbFile = "vise_resources.gdbm"
+	case "bolt":
+		store = boltdb.NewBoltDb()
+		dbFile = "vise_resources.bolt"
 	case "fs":
 		store = fsdb.NewFsDb()
+	default:
+		fmt.Fprintf(os.Stderr, "unknown backend: %s\n", dbBackend)
+		os.Exit(1)
 	}
 
 	dir = flag.Arg(0)
@@ -133,7 +144,7 @@ func main() {
 	if dbPath == "" {
 		dbPath, err = os.MkdirTemp(dir, "vise-dbconvert-*")
 	} else {
-		err = os.MkdirAll(dir, 0700)
+		err = os.MkdirAll(dbPath, 0700)
 	}
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "failed to create output dir")
@@ -157,6 +168,22 @@ func main() {
 	store.SetLock(db.DATATYPE_MENU, false)
 	store.SetLock(db.DATATYPE_STATICLOAD, false)
 
+	if gdbmSrc != "" {
+		c, err := migrateGdbm(ctx, gdbmSrc, store)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to migrate gdbm input: %s\n", err)
+			os.Exit(1)
+		}
+		err = store.Close(ctx)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to close output db: %s\n", err)
+			os.Exit(1)
+		}
+		logg.InfoCtxf(ctx, "migrated gdbm records", "count", c, "src", gdbmSrc)
+		fmt.Fprint(os.Stdout, dbPath)
+		return
+	}
+
 	o, err := newScanner(ctx, store)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "failed to open scanner")
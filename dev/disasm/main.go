@@ -1,24 +1,47 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"strings"
 
 	"git.defalsify.org/vise.git/vm"
 )
 
 func main() {
-	if len(os.Args) < 2 {
+	var mapfp string
+	flag.StringVar(&mapfp, "m", "", "source map file to read (default: bytecode file with .map extension, if it exists)")
+	flag.Parse()
+	if len(flag.Args()) < 1 {
 		os.Exit(1)
 	}
-	fp := os.Args[1]
+	fp := flag.Arg(0)
 	v, err := ioutil.ReadFile(fp)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "read error: %v", err)
 		os.Exit(1)
 	}
 	ph := vm.NewParseHandler().WithDefaultHandlers()
+
+	explicit := mapfp != ""
+	if !explicit {
+		mapfp = strings.TrimSuffix(fp, ".bin") + ".map"
+	}
+	b, err := ioutil.ReadFile(mapfp)
+	if err == nil {
+		sm, err := vm.ParseSourceMap(b)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "source map parse error: %v", err)
+			os.Exit(1)
+		}
+		ph = ph.WithSourceMap(sm)
+	} else if explicit {
+		fmt.Fprintf(os.Stderr, "source map read error: %v", err)
+		os.Exit(1)
+	}
+
 	r, err := ph.ToString(v)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "parse error: %v", err)
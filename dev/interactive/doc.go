@@ -1,2 +1,4 @@
 // Executable interactive runs the Engine execution loop against interactive client input in the terminal.
+//
+// With the -debug flag, it runs a step debugger on the vm instructions.
 package main
@@ -1,13 +1,17 @@
 package main
 
 import (
+	"bufio"
 	"context"
 	"flag"
 	"fmt"
 	"os"
+	"strings"
 
+	"git.defalsify.org/vise.git/asm"
 	"git.defalsify.org/vise.git/db"
 	fsdb "git.defalsify.org/vise.git/db/fs"
+	"git.defalsify.org/vise.git/debug"
 	"git.defalsify.org/vise.git/engine"
 	"git.defalsify.org/vise.git/persist"
 	"git.defalsify.org/vise.git/resource"
@@ -20,12 +24,18 @@ func main() {
 	var sessionId string
 	var persistDir string
 	var initial string
+	var debugMode bool
+	var breaks string
+	var flagsFile string
 	flag.StringVar(&dir, "d", ".", "resource dir to read from")
 	flag.UintVar(&size, "s", 0, "max size of output")
 	flag.StringVar(&root, "root", "root", "entry point symbol")
 	flag.StringVar(&sessionId, "session-id", "default", "session id")
 	flag.StringVar(&persistDir, "p", "", "state persistence directory")
 	flag.StringVar(&initial, "initial", "", "initial input to pass to engine initialization")
+	flag.BoolVar(&debugMode, "debug", false, "run with step debugger")
+	flag.StringVar(&breaks, "break", "", "comma-separated list of debugger breakpoints, as node:<sym>, op:<opcode> or flag:<flag>")
+	flag.StringVar(&flagsFile, "flags", "", "flag names csv file for debugger")
 	flag.Parse()
 	fmt.Fprintf(os.Stderr, "starting session at symbol '%s' using resource dir: %s\n", root, dir)
 
@@ -56,7 +66,35 @@ func main() {
 		en = en.WithPersister(pe)
 	}
 
-	err = engine.Loop(ctx, en, os.Stdin, os.Stdout, []byte(initial))
+	reader := bufio.NewReader(os.Stdin)
+	if debugMode || breaks != "" {
+		pp := asm.NewFlagParser().WithDebug()
+		if flagsFile != "" {
+			_, err = pp.Load(flagsFile)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "flag file load error: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		dbg := debug.NewDebugger(reader, os.Stdout).WithFlagParser(pp)
+		var bps []debug.Breakpoint
+		for _, v := range strings.Split(breaks, ",") {
+			if v == "" {
+				continue
+			}
+			kind, val, _ := strings.Cut(v, ":")
+			bp, err := dbg.ParseBreakpoint(kind, val)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "invalid breakpoint: %v\n", err)
+				os.Exit(1)
+			}
+			bps = append(bps, bp)
+		}
+		dbg = dbg.WithBreakpoints(bps)
+		en.AddHook(dbg)
+	}
+
+	err = engine.Loop(ctx, en, reader, os.Stdout, []byte(initial))
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "loop exited with error: %v\n", err)
 		os.Exit(1)
@@ -0,0 +1,6 @@
+// Executable verify statically checks compiled bytecode in a resource dir for unresolved references, unreachable nodes and dead end nodes.
+//
+// If an output size is given, it also reports nodes whose worst case render size can exceed it in any of the given languages.
+//
+// It exits with a non-zero status if any problems are found.
+package main
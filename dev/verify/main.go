@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"git.defalsify.org/vise.git/db"
+	fsdb "git.defalsify.org/vise.git/db/fs"
+	"git.defalsify.org/vise.git/lang"
+	"git.defalsify.org/vise.git/resource"
+	"git.defalsify.org/vise.git/verify"
+	"git.defalsify.org/vise.git/vm"
+)
+
+// noop external function for symbols provided by the application.
+func noop(ctx context.Context, nodeSym string, input []byte) (resource.Result, error) {
+	return resource.Result{}, nil
+}
+
+// symbols of all bytecode files in the resource dir.
+func nodeSymbols(dir string) ([]string, error) {
+	var r []string
+	fs, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range fs {
+		if v.IsDir() {
+			continue
+		}
+		fn := v.Name()
+		if path.Ext(fn) != ".bin" {
+			continue
+		}
+		r = append(r, strings.TrimSuffix(fn, ".bin"))
+	}
+	return r, nil
+}
+
+// input patterns registered by the application, given as name=regex.
+type patternVar struct {
+	v []string
+}
+
+func (pv *patternVar) Set(s string) error {
+	name, re, ok := strings.Cut(s, "=")
+	if !ok || name == "" {
+		return fmt.Errorf("input pattern must be given as name=regex, got '%s'", s)
+	}
+	err := vm.RegisterInputPattern(name, re)
+	if err != nil {
+		return fmt.Errorf("invalid input pattern '%s': %v", name, err)
+	}
+	pv.v = append(pv.v, s)
+	return nil
+}
+
+func (pv *patternVar) String() string {
+	return strings.Join(pv.v, " ")
+}
+
+func main() {
+	var dir string
+	var root string
+	var funcs string
+	var patterns patternVar
+	var strictMenu bool
+	var size uint
+	var sep string
+	var langs string
+	var showSizes bool
+	flag.StringVar(&dir, "d", ".", "resource dir to read from")
+	flag.StringVar(&root, "root", "root", "entry point symbol")
+	flag.StringVar(&funcs, "f", "", "comma-separated list of external function symbols provided by the application")
+	flag.Var(&patterns, "p", "input pattern registered by the application, as name=regex (may be repeated)")
+	flag.BoolVar(&strictMenu, "strict-menu", false, "report menu symbols without a menu resource")
+	flag.UintVar(&size, "s", 0, "max size of output")
+	flag.StringVar(&sep, "sep", ":", "menu separator")
+	flag.StringVar(&langs, "lang", "", "comma-separated list of ISO-639-3 language codes to check output size for")
+	flag.BoolVar(&showSizes, "sizes", false, "list worst case output size of all nodes")
+	flag.Parse()
+
+	var lns []lang.Language
+	for _, v := range strings.Split(langs, ",") {
+		if v == "" {
+			continue
+		}
+		ln, err := lang.LanguageFromCode(v)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid language: %v\n", err)
+			os.Exit(1)
+		}
+		lns = append(lns, ln)
+	}
+
+	ctx := context.Background()
+	rsStore := fsdb.NewFsDb()
+	err := rsStore.Connect(ctx, dir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "resource db connect error: %v\n", err)
+		os.Exit(1)
+	}
+	rs := resource.NewDbResource(rsStore)
+	rs = rs.With(db.DATATYPE_STATICLOAD)
+	for _, v := range strings.Split(funcs, ",") {
+		if v != "" {
+			rs.AddLocalFunc(v, noop)
+		}
+	}
+
+	syms, err := nodeSymbols(dir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "resource dir read error: %v\n", err)
+		os.Exit(1)
+	}
+	v := verify.NewVerifier(rs).WithSymbols(syms)
+	v = v.WithOutputSize(uint32(size)).WithMenuSeparator(sep).WithLanguages(lns)
+	if strictMenu {
+		v = v.WithStrictMenu()
+	}
+	r, err := v.Verify(ctx, root)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "verify error: %v\n", err)
+		os.Exit(1)
+	}
+	if showSizes {
+		for _, sz := range v.Sizes() {
+			fmt.Println(sz)
+		}
+	}
+	for _, f := range r {
+		fmt.Println(f)
+	}
+	if len(r) > 0 {
+		fmt.Fprintf(os.Stderr, "%d problems found\n", len(r))
+		os.Exit(1)
+	}
+}
@@ -2,6 +2,7 @@ package engine
 
 import (
 	"fmt"
+	"time"
 )
 
 // Config globally defines behavior of all components driven by the engine.
@@ -26,6 +27,20 @@ type Config struct {
 	MenuSeparator string
 	// ResetOnEmptyInput purges cache and restart state execution at root on empty input
 	ResetOnEmptyInput bool
+	// SessionTTL sets the time after the last access at which persisted state is discarded, and a new session is started instead. If set to 0, persisted state never expires.
+	SessionTTL time.Duration
+	// StepLimit sets the maximum number of instructions the vm may execute for a single input. If set to 0, no limit is imposed.
+	StepLimit uint32
+	// MoveLimit sets the maximum number of navigation moves the vm may perform for a single input. If set to 0, no limit is imposed.
+	MoveLimit uint32
+	// LoadTimeout sets the maximum duration of a single call to the external function of a LOAD or RELOAD symbol. If set to 0, no limit is imposed.
+	LoadTimeout time.Duration
+	// LoadRetries sets the number of additional calls made to the external function of a LOAD or RELOAD symbol if it fails or times out.
+	LoadRetries int
+	// RequestTimeout sets the maximum time spent calling external functions for a single input. Loads that have not completed when it expires fail, so that the _catch node can be rendered in time. If set to 0, no limit is imposed.
+	RequestTimeout time.Duration
+	// ConcurrentLoad makes the vm call the external functions of consecutive LOAD instructions concurrently. Results are still applied in instruction order.
+	ConcurrentLoad bool
 }
 
 // String implements the string interface.
@@ -7,8 +7,10 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"time"
 
 	"git.defalsify.org/vise.git/cache"
+	"git.defalsify.org/vise.git/db"
 	"git.defalsify.org/vise.git/persist"
 	"git.defalsify.org/vise.git/render"
 	"git.defalsify.org/vise.git/resource"
@@ -21,19 +23,22 @@ var (
 )
 
 type DefaultEngine struct {
-	st         *state.State
-	ca         cache.Memory
-	vm         *vm.Vm
-	rs         resource.Resource
-	pe         *persist.Persister
-	cfg        Config
-	dbg        Debug
-	first      resource.EntryFunc
-	initd      bool
-	exit       string
-	exiting    bool
-	execd      bool
-	regexCount int
+	st           *state.State
+	ca           cache.Memory
+	vm           *vm.Vm
+	rs           resource.Resource
+	pe           *persist.Persister
+	cfg          Config
+	dbg          Debug
+	first        resource.EntryFunc
+	initd        bool
+	exit         string
+	exiting      bool
+	execd        bool
+	regexCount   int
+	loadPolicies map[string]vm.LoadPolicy
+	hooks        []vm.Hook
+	deadline     time.Time
 }
 
 // NewEngine instantiates the default Engine implementation.
@@ -149,6 +154,36 @@ func (en *DefaultEngine) AddValidInput(re string) error {
 	return err
 }
 
+// AddInputPattern defines a regular expression string that INPAT instructions can refer to by name.
+//
+// Unlike AddValidInput, the pattern does not affect which input is accepted by the engine.
+//
+// The pattern is registered for the whole process, see vm.RegisterInputPattern. Engines that add the same pattern, such as those leased from a Pool, may all do so.
+func (en *DefaultEngine) AddInputPattern(name string, re string) error {
+	return vm.RegisterInputPattern(name, re)
+}
+
+// AddLoadPolicy defines the time limit and retries for the external function of a single LOAD or RELOAD symbol.
+//
+// Symbols without a load policy use the LoadTimeout and LoadRetries settings of the engine configuration.
+func (en *DefaultEngine) AddLoadPolicy(sym string, p vm.LoadPolicy) {
+	if en.loadPolicies == nil {
+		en.loadPolicies = make(map[string]vm.LoadPolicy)
+	}
+	en.loadPolicies[sym] = p
+	if en.vm != nil {
+		en.vm.AddLoadPolicy(sym, p)
+	}
+}
+
+// AddHook adds a hook to be invoked before and after each instruction executed by the vm.
+func (en *DefaultEngine) AddHook(h vm.Hook) {
+	en.hooks = append(en.hooks, h)
+	if en.vm != nil {
+		en.vm = en.vm.WithHook(h)
+	}
+}
+
 // ensure state is present in engine.
 func (en *DefaultEngine) ensureState() {
 	if en.st == nil {
@@ -252,11 +287,23 @@ func (en *DefaultEngine) ensurePersist() error {
 	if err != nil {
 		logg.Infof("persister load fail. trying save in case new session", "err", err, "session", en.cfg.SessionId)
 		err = en.pe.Save(en.cfg.SessionId)
-		if err != nil {
+		if db.IsConflict(err) {
+			logg.Infof("session created concurrently, loading", "session", en.cfg.SessionId)
+		} else if err != nil {
 			return err
 		}
 		en.pe = en.pe.WithContent(st, cac)
 		err = en.pe.Load(en.cfg.SessionId)
+	} else if en.pe.Expired(en.cfg.SessionTTL) {
+		logg.Infof("persisted session expired, starting new session", "session", en.cfg.SessionId, "accessed", en.pe.Accessed)
+		en.st = nil
+		en.ca = nil
+		en.ensureState()
+		err = en.ensureMemory()
+		if err != nil {
+			return err
+		}
+		en.pe = en.pe.WithContent(en.st, en.ca.(*cache.Cache))
 	}
 	if en.cfg.StateDebug {
 		en.st.UseDebug()
@@ -275,6 +322,20 @@ func (en *DefaultEngine) setupVm() {
 	if en.cfg.MenuSeparator != "" {
 		en.vm = en.vm.WithMenuSeparator(en.cfg.MenuSeparator)
 	}
+	en.vm = en.vm.WithStepLimit(en.cfg.StepLimit).WithMoveLimit(en.cfg.MoveLimit)
+	en.vm = en.vm.WithLoadPolicy(vm.LoadPolicy{
+		Timeout: en.cfg.LoadTimeout,
+		Retries: en.cfg.LoadRetries,
+	})
+	for k, v := range en.loadPolicies {
+		en.vm.AddLoadPolicy(k, v)
+	}
+	if en.cfg.ConcurrentLoad {
+		en.vm = en.vm.WithConcurrentLoad()
+	}
+	for _, h := range en.hooks {
+		en.vm = en.vm.WithHook(h)
+	}
 }
 
 func (en *DefaultEngine) empty(ctx context.Context) error {
@@ -336,6 +397,7 @@ func (en *DefaultEngine) runFirst(ctx context.Context) (bool, error) {
 	defer en.st.ResetFlag(state.FLAG_TERMINATE)
 	defer en.st.ResetFlag(state.FLAG_DIRTY)
 	pvm := vm.NewVm(en.st, rs, en.ca, nil)
+	pvm.SetDeadline(en.deadline)
 	b := vm.NewLine(nil, vm.LOAD, []string{"_first"}, []byte{0}, nil)
 	b = vm.NewLine(b, vm.HALT, nil, nil, nil)
 	b, err = pvm.Run(ctx, b)
@@ -367,7 +429,7 @@ func (en *DefaultEngine) runFirst(ctx context.Context) (bool, error) {
 // If persister is set, this call will save the state and memory.
 //
 // An error will be logged and returned if:
-//   - persistence was attempted and failed (takes precedence)
+//   - persistence was attempted and failed (takes precedence). If the persisted state was changed by someone else since it was loaded, the error will be db.ErrConflict.
 //   - resource backend did not close cleanly.
 func (en *DefaultEngine) Finish(ctx context.Context) error {
 	var perr error
@@ -417,6 +479,7 @@ func (en *DefaultEngine) init(ctx context.Context, input []byte) (bool, error) {
 	if err != nil {
 		return false, err
 	}
+	en.vm.SetDeadline(en.deadline)
 
 	if en.st.Language != nil {
 		logg.TraceCtxf(ctx, "set language on context", "lang", en.st.Language)
@@ -478,6 +541,12 @@ func (en *DefaultEngine) init(ctx context.Context, input []byte) (bool, error) {
 func (en *DefaultEngine) Exec(ctx context.Context, input []byte) (bool, error) {
 	var err error
 
+	// the deadline covers the whole request, including the start node and the pre-VM check.
+	en.deadline = time.Time{}
+	if en.cfg.RequestTimeout > 0 {
+		en.deadline = time.Now().Add(en.cfg.RequestTimeout)
+	}
+
 	if en.cfg.SessionId != "" {
 		ctx = context.WithValue(ctx, "SessionId", en.cfg.SessionId)
 	}
@@ -7,8 +7,10 @@ import (
 	"os"
 	"strings"
 	"testing"
+	"time"
 
 	"git.defalsify.org/vise.git/cache"
+	"git.defalsify.org/vise.git/db"
 	memdb "git.defalsify.org/vise.git/db/mem"
 	"git.defalsify.org/vise.git/persist"
 	"git.defalsify.org/vise.git/resource"
@@ -262,6 +264,72 @@ func TestDbEnginePersist(t *testing.T) {
 	}
 }
 
+func TestDbEnginePersistExpire(t *testing.T) {
+	nul := getNull()
+	defer nul.Close()
+	ctx := context.Background()
+	cfg := Config{
+		FlagCount:  2,
+		SessionId:  "bar",
+		SessionTTL: time.Minute,
+	}
+	store := memdb.NewMemDb()
+	store.Connect(ctx, "")
+	pe := persist.NewPersister(store)
+	rs := resource.NewMenuResource()
+	rs.WithCodeGetter(codeGet)
+	rs.AddLocalFunc("foo", flagSet)
+	en := NewEngine(cfg, rs)
+	en = en.WithPersister(pe)
+	_, err := en.Exec(ctx, []byte{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = en.Exec(ctx, []byte{0x30})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = en.Flush(ctx, nul)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = en.Finish(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pe.GetState().SetFlag(state.FLAG_USERSTART + 1)
+	pe.Accessed = time.Now().Add(-time.Hour)
+	b, err := pe.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.SetPrefix(db.DATATYPE_STATE)
+	err = store.Put(ctx, []byte("bar"), b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pe = persist.NewPersister(store)
+	en = NewEngine(cfg, rs)
+	en = en.WithPersister(pe)
+	_, err = en.Exec(ctx, []byte{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stn := pe.GetState()
+	if stn.MatchFlag(state.FLAG_USERSTART+1, true) {
+		t.Fatalf("expected new session state, have state %v", stn)
+	}
+	_, err = en.Flush(ctx, nul)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = en.Finish(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
 func TestDbConfigString(t *testing.T) {
 	cfg := Config{
 		Root: "tinkywinky",
@@ -376,3 +444,59 @@ func TestDbFirst(t *testing.T) {
 		t.Fatal("expected flag set")
 	}
 }
+
+func slowCodeGet(ctx context.Context, s string) ([]byte, error) {
+	var b []byte
+	var err error
+	switch s {
+	case "root":
+		b = vm.NewLine(nil, vm.LOAD, []string{"slow"}, []byte{0x0}, nil)
+		b = vm.NewLine(b, vm.HALT, nil, nil, nil)
+	case "_catch":
+		b = vm.NewLine(nil, vm.HALT, nil, nil, nil)
+	default:
+		err = fmt.Errorf("unknown code symbol '%s'", s)
+	}
+	return b, err
+}
+
+func slowGet(ctx context.Context, nodeSym string, input []byte) (resource.Result, error) {
+	time.Sleep(time.Second)
+	return resource.Result{
+		Content: "slow",
+	}, nil
+}
+
+func TestDbRequestTimeoutInit(t *testing.T) {
+	ctx := context.Background()
+	cfg := Config{
+		RequestTimeout: time.Millisecond * 10,
+	}
+	rs := resource.NewMenuResource()
+	rs.WithCodeGetter(slowCodeGet)
+	rs.AddLocalFunc("slow", slowGet)
+	en := NewEngine(cfg, rs)
+	then := time.Now()
+	_, err := en.Exec(ctx, []byte{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(then) > time.Millisecond*500 {
+		t.Fatalf("start node load not abandoned on request deadline")
+	}
+	location, _ := en.st.Where()
+	if location != "_catch" {
+		t.Fatalf("expected _catch, got %s", location)
+	}
+
+	en = NewEngine(cfg, resource.NewMenuResource())
+	en = en.WithFirst(slowGet)
+	then = time.Now()
+	_, err = en.Exec(ctx, []byte{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(then) > time.Millisecond*500 {
+		t.Fatalf("pre-VM load not abandoned on request deadline")
+	}
+}
@@ -0,0 +1,211 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"git.defalsify.org/vise.git/db"
+	"git.defalsify.org/vise.git/persist"
+	"git.defalsify.org/vise.git/resource"
+)
+
+// sharedResource serializes access to a resource shared between engines in a Pool.
+//
+// Only retrieval is serialized. The EntryFunc returned by FuncFor is executed without holding the lock.
+//
+// Close is a noop, since the resource outlives the individual engines.
+type sharedResource struct {
+	rs resource.Resource
+	mu *sync.Mutex
+}
+
+// GetTemplate implements the Resource interface.
+func (sr sharedResource) GetTemplate(ctx context.Context, nodeSym string) (string, error) {
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	return sr.rs.GetTemplate(ctx, nodeSym)
+}
+
+// GetCode implements the Resource interface.
+func (sr sharedResource) GetCode(ctx context.Context, nodeSym string) ([]byte, error) {
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	return sr.rs.GetCode(ctx, nodeSym)
+}
+
+// GetSourceMap implements the resource.SourceMapper interface, if the underlying resource implements it.
+func (sr sharedResource) GetSourceMap(ctx context.Context, nodeSym string) ([]byte, error) {
+	mp, ok := sr.rs.(resource.SourceMapper)
+	if !ok {
+		return nil, fmt.Errorf("no source map for %s", nodeSym)
+	}
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	return mp.GetSourceMap(ctx, nodeSym)
+}
+
+// GetMenu implements the Resource interface.
+func (sr sharedResource) GetMenu(ctx context.Context, menuSym string) (string, error) {
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	return sr.rs.GetMenu(ctx, menuSym)
+}
+
+// FuncFor implements the Resource interface.
+func (sr sharedResource) FuncFor(ctx context.Context, loadSym string) (resource.EntryFunc, error) {
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	return sr.rs.FuncFor(ctx, loadSym)
+}
+
+// Close implements the Resource interface.
+func (sr sharedResource) Close(ctx context.Context) error {
+	return nil
+}
+
+// sessionLock is a lock for a single session that can be waited for with a context.
+type sessionLock struct {
+	c     chan struct{}
+	count int
+}
+
+// Pool leases engines for individual sessions, sharing a single resource and persistence backend between them.
+//
+// Requests for the same session are serialized; a lease for a session blocks until the previous lease for the same session has been released. Different sessions are executed in parallel.
+type Pool struct {
+	cfg      Config
+	rs       resource.Resource
+	rsLock   sync.Mutex
+	store    *db.SharedDb
+	mu       sync.Mutex
+	sessions map[string]*sessionLock
+}
+
+// Lease is an engine reserved for exclusive use by a single session.
+//
+// It must be released with Release when execution is done.
+type Lease struct {
+	*DefaultEngine
+	pool      *Pool
+	sessionId string
+	sl        *sessionLock
+	released  bool
+}
+
+// NewPool creates a new Pool.
+//
+// Engines will be created from the given Config, with the SessionId set according to the leased session.
+func NewPool(cfg Config, rs resource.Resource) *Pool {
+	if rs == nil {
+		panic("resource cannot be nil")
+	}
+	return &Pool{
+		cfg:      cfg,
+		rs:       rs,
+		sessions: make(map[string]*sessionLock),
+	}
+}
+
+// WithPersistDb is a chainable function that sets the db.Db to use for state and cache persistence.
+//
+// The db.Db must already be connected. It will be closed by Close.
+//
+// The db.Db must not be the same as the one used by the resource, since they are locked separately. Use WithSharedDb instead in that case.
+func (p *Pool) WithPersistDb(store db.Db) *Pool {
+	p.store = db.NewSharedDb(store)
+	return p
+}
+
+// WithSharedDb is a chainable function that sets the db.SharedDb to use for state and cache persistence.
+//
+// It is used when the resource retrieves its data from the same db.Db, in which case the resource must use a view of the same db.SharedDb. All access to the db.Db is then serialized by a single lock.
+//
+// The underlying db.Db must already be connected. It will be closed by Close.
+func (p *Pool) WithSharedDb(store *db.SharedDb) *Pool {
+	p.store = store
+	return p
+}
+
+// get the lock for the session, creating it if it does not exist.
+func (p *Pool) sessionLock(sessionId string) *sessionLock {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	sl, ok := p.sessions[sessionId]
+	if !ok {
+		sl = &sessionLock{
+			c: make(chan struct{}, 1),
+		}
+		p.sessions[sessionId] = sl
+	}
+	sl.count += 1
+	return sl
+}
+
+// release interest in the session lock, removing it if there are no more waiters.
+func (p *Pool) sessionUnlock(sessionId string, sl *sessionLock) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	sl.count -= 1
+	if sl.count == 0 {
+		delete(p.sessions, sessionId)
+	}
+}
+
+// Lease reserves an engine for the given session.
+//
+// If the session is already leased, it waits until the lease is released, or until the context is done.
+func (p *Pool) Lease(ctx context.Context, sessionId string) (*Lease, error) {
+	sl := p.sessionLock(sessionId)
+	select {
+	case sl.c <- struct{}{}:
+	case <-ctx.Done():
+		p.sessionUnlock(sessionId, sl)
+		return nil, ctx.Err()
+	}
+	logg.TraceCtxf(ctx, "session leased", "session", sessionId)
+
+	cfg := p.cfg
+	cfg.SessionId = sessionId
+	en := NewEngine(cfg, sharedResource{rs: p.rs, mu: &p.rsLock})
+	if p.store != nil {
+		pe := persist.NewPersister(p.store.View()).WithSession(sessionId)
+		en = en.WithPersister(pe)
+	}
+	return &Lease{
+		DefaultEngine: en,
+		pool:          p,
+		sessionId:     sessionId,
+		sl:            sl,
+	}, nil
+}
+
+// Release finishes the leased engine and makes the session available for a new lease.
+//
+// The lease must not be used after it has been released.
+func (l *Lease) Release(ctx context.Context) error {
+	if l.released {
+		return nil
+	}
+	l.released = true
+	err := l.DefaultEngine.Finish(ctx)
+	<-l.sl.c
+	l.pool.sessionUnlock(l.sessionId, l.sl)
+	logg.TraceCtxf(ctx, "session released", "session", l.sessionId)
+	return err
+}
+
+// Close closes the shared resource and persistence backend.
+//
+// It must only be called after all leases have been released.
+func (p *Pool) Close(ctx context.Context) error {
+	var err error
+	if p.store != nil {
+		err = p.store.Close(ctx)
+	}
+	rerr := p.rs.Close(ctx)
+	if err != nil {
+		return err
+	}
+	return rerr
+}
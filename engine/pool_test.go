@@ -0,0 +1,262 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"git.defalsify.org/vise.git/db"
+	memdb "git.defalsify.org/vise.git/db/mem"
+	"git.defalsify.org/vise.git/internal/resourcetest"
+	"git.defalsify.org/vise.git/resource"
+	"git.defalsify.org/vise.git/vm"
+)
+
+func newPoolTestResource(t *testing.T) *resourcetest.TestResource {
+	ctx := context.Background()
+	rs := resourcetest.NewTestResource()
+	b := vm.NewLine(nil, vm.LOAD, []string{"count"}, []byte{0x00}, nil)
+	b = vm.NewLine(b, vm.MAP, []string{"count"}, nil, nil)
+	b = vm.NewLine(b, vm.HALT, nil, nil, nil)
+	b = vm.NewLine(b, vm.RELOAD, []string{"count"}, nil, nil)
+	b = vm.NewLine(b, vm.INCMP, []string{".", "*"}, nil, nil)
+	err := rs.AddBytecode(ctx, "root", b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = rs.AddTemplate(ctx, "root", "{{.count}}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mu sync.Mutex
+	counts := make(map[string]int)
+	rs.AddFunc(ctx, "count", func(ctx context.Context, sym string, input []byte) (resource.Result, error) {
+		sessionId := ctx.Value("SessionId").(string)
+		mu.Lock()
+		defer mu.Unlock()
+		counts[sessionId] += 1
+		return resource.Result{
+			Content: fmt.Sprintf("%s:%d", sessionId, counts[sessionId]),
+		}, nil
+	})
+	rs.Lock()
+	return rs
+}
+
+func TestPoolSessions(t *testing.T) {
+	ctx := context.Background()
+	rs := newPoolTestResource(t)
+	store := memdb.NewMemDb()
+	err := store.Connect(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := NewPool(Config{Root: "root"}, rs).WithPersistDb(store)
+
+	var wg sync.WaitGroup
+	sessions := []string{"inky", "pinky", "blinky", "clyde"}
+	errs := make(chan error, len(sessions)*4)
+	for _, sessionId := range sessions {
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func(sessionId string) {
+				defer wg.Done()
+				en, err := pool.Lease(ctx, sessionId)
+				if err != nil {
+					errs <- err
+					return
+				}
+				_, err = en.Exec(ctx, []byte("1"))
+				if err == nil {
+					_, err = en.Flush(ctx, bytes.NewBuffer(nil))
+				}
+				rerr := en.Release(ctx)
+				if err == nil {
+					err = rerr
+				}
+				if err != nil {
+					errs <- err
+				}
+			}(sessionId)
+		}
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	for _, sessionId := range sessions {
+		en, err := pool.Lease(ctx, sessionId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = en.Exec(ctx, []byte("1"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := bytes.NewBuffer(nil)
+		_, err = en.Flush(ctx, r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expect := fmt.Sprintf("%s:5", sessionId)
+		if r.String() != expect {
+			t.Fatalf("expected '%s', got '%s'", expect, r)
+		}
+		err = en.Release(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = pool.Close(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPoolSharedDb(t *testing.T) {
+	ctx := context.Background()
+	store := memdb.NewMemDb()
+	err := store.Connect(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.SetLock(db.DATATYPE_BIN, false)
+	store.SetLock(db.DATATYPE_TEMPLATE, false)
+	b := vm.NewLine(nil, vm.MAP, []string{"count"}, nil, nil)
+	b = append(vm.NewLine(nil, vm.LOAD, []string{"count"}, []byte{0x00}, nil), b...)
+	b = vm.NewLine(b, vm.HALT, nil, nil, nil)
+	b = vm.NewLine(b, vm.INCMP, []string{".", "*"}, nil, nil)
+	store.SetPrefix(db.DATATYPE_BIN)
+	err = store.Put(ctx, []byte("root"), b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.SetPrefix(db.DATATYPE_TEMPLATE)
+	err = store.Put(ctx, []byte("root"), []byte("{{.count}}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.SetLock(db.DATATYPE_BIN, true)
+	store.SetLock(db.DATATYPE_TEMPLATE, true)
+
+	sdb := db.NewSharedDb(store)
+	rs := resource.NewDbResource(sdb.View())
+	rs.AddLocalFunc("count", func(ctx context.Context, sym string, input []byte) (resource.Result, error) {
+		return resource.Result{
+			Content: ctx.Value("SessionId").(string),
+		}, nil
+	})
+	pool := NewPool(Config{Root: "root"}, rs).WithSharedDb(sdb)
+
+	var wg sync.WaitGroup
+	sessions := []string{"inky", "pinky", "blinky", "clyde"}
+	errs := make(chan error, len(sessions)*4)
+	for _, sessionId := range sessions {
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func(sessionId string) {
+				defer wg.Done()
+				en, err := pool.Lease(ctx, sessionId)
+				if err != nil {
+					errs <- err
+					return
+				}
+				_, err = en.Exec(ctx, []byte("1"))
+				if err == nil {
+					r := bytes.NewBuffer(nil)
+					_, err = en.Flush(ctx, r)
+					if err == nil && r.String() != sessionId {
+						err = fmt.Errorf("expected '%s', got '%s'", sessionId, r)
+					}
+				}
+				rerr := en.Release(ctx)
+				if err == nil {
+					err = rerr
+				}
+				if err != nil {
+					errs <- err
+				}
+			}(sessionId)
+		}
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	err = pool.Close(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPoolLeaseWait(t *testing.T) {
+	ctx := context.Background()
+	rs := newPoolTestResource(t)
+	pool := NewPool(Config{Root: "root"}, rs)
+
+	en, err := pool.Lease(ctx, "inky")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctxTimeout, cancel := context.WithTimeout(ctx, time.Millisecond*10)
+	defer cancel()
+	_, err = pool.Lease(ctxTimeout, "inky")
+	if err != context.DeadlineExceeded {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+
+	other, err := pool.Lease(ctx, "pinky")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = other.Release(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = en.Release(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	en, err = pool.Lease(ctx, "inky")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = en.Release(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pool.sessions) > 0 {
+		t.Fatalf("expected no session locks, have %d", len(pool.sessions))
+	}
+}
+
+func TestPoolInputPattern(t *testing.T) {
+	ctx := context.Background()
+	rs := newPoolTestResource(t)
+	pool := NewPool(Config{Root: "root"}, rs)
+
+	for _, sessionId := range []string{"inky", "pinky"} {
+		en, err := pool.Lease(ctx, sessionId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = en.AddInputPattern("pooldigits", "^[0-9]+$")
+		if err != nil {
+			t.Fatalf("session %s: %v", sessionId, err)
+		}
+		err = en.Release(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
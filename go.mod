@@ -6,22 +6,38 @@ toolchain go1.23.1
 
 require (
 	github.com/alecthomas/participle/v2 v2.0.0
+	github.com/alicebob/miniredis/v2 v2.37.0
 	github.com/barbashov/iso639-3 v0.0.0-20211020172741-1f4ffb2d8d1c
 	github.com/fxamacker/cbor/v2 v2.4.0
 	github.com/graygnuorg/go-gdbm v0.0.0-20220711140707-71387d66dce4
 	github.com/jackc/pgx/v5 v5.7.0
 	github.com/pashagolub/pgxmock/v4 v4.3.0
 	github.com/peteole/testdata-loader v0.3.0
+	github.com/redis/go-redis/v9 v9.7.3
+	go.etcd.io/bbolt v1.3.11
 	gopkg.in/leonelquinteros/gotext.v1 v1.3.1
+	modernc.org/sqlite v1.34.5
 )
 
 require (
+	github.com/cespare/xxhash/v2 v2.2.0 // indirect
+	github.com/dgryski/go-rendezvous v0.0.0-20200823014737-9f7001d12a5f // indirect
+	github.com/dustin/go-humanize v1.0.1 // indirect
+	github.com/google/uuid v1.6.0 // indirect
 	github.com/jackc/pgpassfile v1.0.0 // indirect
 	github.com/jackc/pgservicefile v0.0.0-20240606120523-5a60cdf6a761 // indirect
 	github.com/jackc/puddle/v2 v2.2.1 // indirect
+	github.com/mattn/go-isatty v0.0.20 // indirect
 	github.com/mattn/kinako v0.0.0-20170717041458-332c0a7e205a // indirect
+	github.com/ncruces/go-strftime v0.1.9 // indirect
+	github.com/remyoudompheng/bigfft v0.0.0-20230129092748-24d4a6f8daec // indirect
 	github.com/x448/float16 v0.8.4 // indirect
+	github.com/yuin/gopher-lua v1.1.1 // indirect
 	golang.org/x/crypto v0.27.0 // indirect
 	golang.org/x/sync v0.8.0 // indirect
+	golang.org/x/sys v0.25.0 // indirect
 	golang.org/x/text v0.18.0 // indirect
+	modernc.org/libc v1.55.3 // indirect
+	modernc.org/mathutil v1.6.0 // indirect
+	modernc.org/memory v1.8.0 // indirect
 )
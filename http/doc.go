@@ -0,0 +1,2 @@
+// Package http provides a http.Handler that drives vise engine executions for client sessions.
+package http
@@ -0,0 +1,9 @@
+package http
+
+import (
+	"git.defalsify.org/vise.git/logging"
+)
+
+var (
+	logg logging.Logger = logging.NewVanilla().WithDomain("http")
+)
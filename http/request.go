@@ -0,0 +1,44 @@
+package http
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+)
+
+var (
+	ErrNoSession = errors.New("no session found")
+)
+
+// RequestParser extracts the vise session and client input from a http request.
+type RequestParser interface {
+	// GetSessionId returns the session identifier of the client issuing the request.
+	GetSessionId(*http.Request) (string, error)
+	// GetInput returns the client input to pass on to engine execution.
+	GetInput(*http.Request) ([]byte, error)
+}
+
+// DefaultRequestParser is a vanilla implementation of the RequestParser interface.
+//
+// The session is read from the X-Vise-Session header, and the input is the full request body.
+type DefaultRequestParser struct {
+}
+
+// GetSessionId implements the RequestParser interface.
+func (rp *DefaultRequestParser) GetSessionId(rq *http.Request) (string, error) {
+	v := rq.Header.Get("X-Vise-Session")
+	if v == "" {
+		return "", ErrNoSession
+	}
+	return v, nil
+}
+
+// GetInput implements the RequestParser interface.
+func (rp *DefaultRequestParser) GetInput(rq *http.Request) ([]byte, error) {
+	defer rq.Body.Close()
+	v, err := ioutil.ReadAll(rq.Body)
+	if err != nil {
+		return nil, err
+	}
+	return v, nil
+}
@@ -0,0 +1,41 @@
+package http
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// ResponseWriter writes the results of an engine execution to a http response.
+type ResponseWriter interface {
+	// Write writes the rendered output of the engine.
+	//
+	// The cont argument is the value returned by engine.Engine.Exec, and is false if the session has terminated.
+	Write(w http.ResponseWriter, output []byte, cont bool) error
+	// WriteError writes a response for a request that could not be processed.
+	WriteError(w http.ResponseWriter, code int, msg string, err error)
+}
+
+// DefaultResponseWriter is a vanilla implementation of the ResponseWriter interface.
+//
+// Output is written as plain text. Errors are reported in the X-Vise header with an empty body.
+type DefaultResponseWriter struct {
+}
+
+// Write implements the ResponseWriter interface.
+func (rw *DefaultResponseWriter) Write(w http.ResponseWriter, output []byte, cont bool) error {
+	w.Header().Set("Content-Type", "text/plain")
+	w.Header().Set("Content-Length", strconv.Itoa(len(output)))
+	w.WriteHeader(http.StatusOK)
+	_, err := w.Write(output)
+	return err
+}
+
+// WriteError implements the ResponseWriter interface.
+func (rw *DefaultResponseWriter) WriteError(w http.ResponseWriter, code int, msg string, err error) {
+	if err != nil {
+		msg += ": " + err.Error()
+	}
+	w.Header().Set("X-Vise", msg)
+	w.Header().Set("Content-Length", "0")
+	w.WriteHeader(code)
+}
@@ -0,0 +1,184 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+
+	"git.defalsify.org/vise.git/db"
+	"git.defalsify.org/vise.git/engine"
+	"git.defalsify.org/vise.git/resource"
+)
+
+var (
+	ErrShutdown = errors.New("session handler is shutting down")
+)
+
+// SessionHandler is a http.Handler that executes client input against a new engine instance for every request.
+//
+// Engine instances are leased from an engine.Pool created from the engine.Config template given in the constructor, with the session id set according to the request.
+//
+// All sessions use the same resource.Resource, and if a persistence store has been set, the same db.Db to persist state.
+//
+// Concurrent requests for the same session are serialized, while different sessions are executed in parallel.
+type SessionHandler struct {
+	pool    *engine.Pool
+	rp      RequestParser
+	rw      ResponseWriter
+	wg      sync.WaitGroup
+	mu      sync.Mutex
+	closing bool
+	retries int
+}
+
+// NewSessionHandler creates a new SessionHandler.
+//
+// By default DefaultRequestParser and DefaultResponseWriter will be used, and state will not be persisted between requests.
+func NewSessionHandler(cfg engine.Config, rs resource.Resource) *SessionHandler {
+	if rs == nil {
+		panic("resource cannot be nil")
+	}
+	return &SessionHandler{
+		pool: engine.NewPool(cfg, rs),
+		rp:   &DefaultRequestParser{},
+		rw:   &DefaultResponseWriter{},
+	}
+}
+
+// WithRequestParser is a chainable function that sets the parser used to extract session and input from requests.
+func (f *SessionHandler) WithRequestParser(rp RequestParser) *SessionHandler {
+	f.rp = rp
+	return f
+}
+
+// WithResponseWriter is a chainable function that sets the writer used to write engine output and errors to responses.
+func (f *SessionHandler) WithResponseWriter(rw ResponseWriter) *SessionHandler {
+	f.rw = rw
+	return f
+}
+
+// WithPersistDb is a chainable function that sets the db.Db to use for state and cache persistence.
+//
+// The db.Db must already be connected. It will be closed by Shutdown.
+//
+// The db.Db must not be the same as the one used by the resource. Use WithSharedDb instead in that case.
+func (f *SessionHandler) WithPersistDb(store db.Db) *SessionHandler {
+	f.pool = f.pool.WithPersistDb(store)
+	return f
+}
+
+// WithSharedDb is a chainable function that sets the db.SharedDb to use for state and cache persistence.
+//
+// It must be used instead of WithPersistDb if the resource retrieves its data from the same db.Db. See engine.Pool.WithSharedDb.
+func (f *SessionHandler) WithSharedDb(store *db.SharedDb) *SessionHandler {
+	f.pool = f.pool.WithSharedDb(store)
+	return f
+}
+
+// WithConflictRetries is a chainable function that sets how many times a request will be executed again if the persisted state was changed by someone else during execution.
+//
+// By default the request is rejected with status 409 on the first conflict.
+func (f *SessionHandler) WithConflictRetries(retries int) *SessionHandler {
+	f.retries = retries
+	return f
+}
+
+// register an in-flight request, fails if shutdown has been initiated.
+func (f *SessionHandler) enter() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closing {
+		return ErrShutdown
+	}
+	f.wg.Add(1)
+	return nil
+}
+
+// ServeHTTP implements the http.Handler interface.
+//
+// The engine is always finished before the response is written, also on failed execution.
+//
+// If the persisted state of the session was changed by someone else during execution, the request is rejected with status 409, unless WithConflictRetries has been set.
+func (f *SessionHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	err := f.enter()
+	if err != nil {
+		f.rw.WriteError(w, http.StatusServiceUnavailable, "Unavailable", err)
+		return
+	}
+	defer f.wg.Done()
+
+	sessionId, err := f.rp.GetSessionId(req)
+	if err != nil {
+		f.rw.WriteError(w, http.StatusBadRequest, "Session missing", err)
+		return
+	}
+	input, err := f.rp.GetInput(req)
+	if err != nil {
+		f.rw.WriteError(w, http.StatusBadRequest, "Input read fail", err)
+		return
+	}
+
+	b, cont, code, err := f.exec(req.Context(), sessionId, input)
+	for i := 0; i < f.retries && db.IsConflict(err); i++ {
+		logg.InfoCtxf(req.Context(), "session state conflict, retrying", "session", sessionId, "retry", i+1)
+		b, cont, code, err = f.exec(req.Context(), sessionId, input)
+	}
+	if err != nil {
+		f.rw.WriteError(w, code, "Engine exec fail", err)
+		return
+	}
+	err = f.rw.Write(w, b, cont)
+	if err != nil {
+		logg.ErrorCtxf(req.Context(), "response write fail", "session", sessionId, "err", err)
+	}
+}
+
+// run a single engine execution and collect its output.
+func (f *SessionHandler) exec(ctx context.Context, sessionId string, input []byte) ([]byte, bool, int, error) {
+	en, err := f.pool.Lease(ctx, sessionId)
+	if err != nil {
+		return nil, false, http.StatusServiceUnavailable, err
+	}
+	b := bytes.NewBuffer(nil)
+	cont, err := en.Exec(ctx, input)
+	if err == nil {
+		_, err = en.Flush(ctx, b)
+	}
+	ferr := en.Release(ctx)
+	if err != nil {
+		return nil, false, http.StatusInternalServerError, err
+	}
+	if db.IsConflict(ferr) {
+		return nil, false, http.StatusConflict, ferr
+	}
+	if ferr != nil {
+		return nil, false, http.StatusInternalServerError, ferr
+	}
+	logg.DebugCtxf(ctx, "session exec done", "session", sessionId, "cont", cont)
+	return b.Bytes(), cont, http.StatusOK, nil
+}
+
+// Shutdown stops accepting new requests, and waits for all in-flight requests to finish their engines.
+//
+// Once all requests are done, the shared resource and persistence db are closed.
+//
+// If the context is done before all requests have finished, the context error is returned and the resource and db are left open.
+func (f *SessionHandler) Shutdown(ctx context.Context) error {
+	f.mu.Lock()
+	f.closing = true
+	f.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		f.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return f.pool.Close(ctx)
+}
@@ -0,0 +1,166 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"git.defalsify.org/vise.git/db"
+	memdb "git.defalsify.org/vise.git/db/mem"
+	"git.defalsify.org/vise.git/engine"
+	"git.defalsify.org/vise.git/internal/resourcetest"
+	"git.defalsify.org/vise.git/vm"
+)
+
+func newTestResource(t *testing.T) *resourcetest.TestResource {
+	ctx := context.Background()
+	rs := resourcetest.NewTestResource()
+	b := vm.NewLine(nil, vm.MOUT, []string{"foo", "1"}, nil, nil)
+	b = vm.NewLine(b, vm.HALT, nil, nil, nil)
+	b = vm.NewLine(b, vm.INCMP, []string{"foo", "1"}, nil, nil)
+	err := rs.AddBytecode(ctx, "root", b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = rs.AddTemplate(ctx, "root", "hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b = vm.NewLine(nil, vm.HALT, nil, nil, nil)
+	err = rs.AddBytecode(ctx, "foo", b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = rs.AddTemplate(ctx, "foo", "world")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs.Lock()
+	return rs
+}
+
+func doRequest(h http.Handler, sessionId string, input string) *httptest.ResponseRecorder {
+	rq := httptest.NewRequest("POST", "/", bytes.NewBufferString(input))
+	if sessionId != "" {
+		rq.Header.Set("X-Vise-Session", sessionId)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, rq)
+	return w
+}
+
+func TestSessionHandlerPersist(t *testing.T) {
+	ctx := context.Background()
+	rs := newTestResource(t)
+	store := memdb.NewMemDb()
+	err := store.Connect(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := NewSessionHandler(engine.Config{Root: "root"}, rs)
+	h = h.WithPersistDb(store)
+
+	w := doRequest(h, "xyzzy", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d (%s)", w.Code, w.Header().Get("X-Vise"))
+	}
+	expect := "hello\n1:foo"
+	if w.Body.String() != expect {
+		t.Fatalf("expected:\n\t%s\ngot:\n\t%s", expect, w.Body.String())
+	}
+
+	w = doRequest(h, "xyzzy", "1")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d (%s)", w.Code, w.Header().Get("X-Vise"))
+	}
+	expect = "world"
+	if w.Body.String() != expect {
+		t.Fatalf("expected:\n\t%s\ngot:\n\t%s", expect, w.Body.String())
+	}
+
+	w = doRequest(h, "plugh", "")
+	expect = "hello\n1:foo"
+	if w.Body.String() != expect {
+		t.Fatalf("expected:\n\t%s\ngot:\n\t%s", expect, w.Body.String())
+	}
+}
+
+func TestSessionHandlerNoSession(t *testing.T) {
+	rs := newTestResource(t)
+	h := NewSessionHandler(engine.Config{Root: "root"}, rs)
+	w := doRequest(h, "", "")
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400, got %d", w.Code)
+	}
+	if w.Header().Get("X-Vise") == "" {
+		t.Fatalf("expected error header")
+	}
+}
+
+func TestSessionHandlerShutdown(t *testing.T) {
+	ctx := context.Background()
+	rs := newTestResource(t)
+	h := NewSessionHandler(engine.Config{Root: "root"}, rs)
+	w := doRequest(h, "xyzzy", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+	err := h.Shutdown(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w = doRequest(h, "xyzzy", "")
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected status 503, got %d", w.Code)
+	}
+}
+
+// conflictDb fails a number of compare-and-swap operations as if the state was changed by someone else.
+type conflictDb struct {
+	db.Db
+	fails int
+}
+
+func (cdb *conflictDb) CompareAndSwap(ctx context.Context, key []byte, old []byte, val []byte) error {
+	if old != nil && cdb.fails > 0 {
+		cdb.fails -= 1
+		return db.NewErrConflict(key)
+	}
+	return cdb.Db.CompareAndSwap(ctx, key, old, val)
+}
+
+func TestSessionHandlerConflict(t *testing.T) {
+	ctx := context.Background()
+	rs := newTestResource(t)
+	store := memdb.NewMemDb()
+	err := store.Connect(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cdb := &conflictDb{Db: store}
+	h := NewSessionHandler(engine.Config{Root: "root"}, rs)
+	h = h.WithPersistDb(cdb)
+
+	w := doRequest(h, "xyzzy", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d (%s)", w.Code, w.Header().Get("X-Vise"))
+	}
+	cdb.fails = 1
+	w = doRequest(h, "xyzzy", "1")
+	if w.Code != http.StatusConflict {
+		t.Fatalf("expected status 409, got %d", w.Code)
+	}
+
+	h = h.WithConflictRetries(1)
+	cdb.fails = 1
+	w = doRequest(h, "xyzzy", "1")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d (%s)", w.Code, w.Header().Get("X-Vise"))
+	}
+	expect := "world"
+	if w.Body.String() != expect {
+		t.Fatalf("expected:\n\t%s\ngot:\n\t%s", expect, w.Body.String())
+	}
+}
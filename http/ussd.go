@@ -0,0 +1,150 @@
+package http
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	ussdContinuePrefix = "CON "
+	ussdEndPrefix      = "END "
+	ussdInputSeparator = "*"
+)
+
+// UssdRequestParser implements the RequestParser interface for form-encoded USSD gateway callbacks.
+//
+// The gateway sends the full history of inputs in the session, joined by the "*" character. Only the latest input is passed on to the engine.
+//
+// The names of the form fields can be changed to fit the gateway in use. The defaults are:
+//
+//   - session: "sessionId"
+//   - service code: "serviceCode"
+//   - phone number: "phoneNumber"
+//   - input: "text"
+type UssdRequestParser struct {
+	SessionField     string
+	ServiceCodeField string
+	PhoneNumberField string
+	TextField        string
+	phoneSession     bool
+}
+
+// NewUssdRequestParser creates a new UssdRequestParser with the default form field names.
+func NewUssdRequestParser() *UssdRequestParser {
+	return &UssdRequestParser{
+		SessionField:     "sessionId",
+		ServiceCodeField: "serviceCode",
+		PhoneNumberField: "phoneNumber",
+		TextField:        "text",
+	}
+}
+
+// WithPhoneSession is a chainable function that makes the phone number be used as the session id.
+//
+// This enables state to be retained across USSD sessions made by the same phone.
+func (rp *UssdRequestParser) WithPhoneSession() *UssdRequestParser {
+	rp.phoneSession = true
+	return rp
+}
+
+// field retrieves a single form value from the request.
+func (rp *UssdRequestParser) field(rq *http.Request, k string) (string, error) {
+	err := rq.ParseForm()
+	if err != nil {
+		return "", err
+	}
+	return rq.Form.Get(k), nil
+}
+
+// GetSessionId implements the RequestParser interface.
+func (rp *UssdRequestParser) GetSessionId(rq *http.Request) (string, error) {
+	if rp.phoneSession {
+		return rp.GetPhoneNumber(rq)
+	}
+	v, err := rp.field(rq, rp.SessionField)
+	if err != nil {
+		return "", err
+	}
+	if v == "" {
+		return "", ErrNoSession
+	}
+	return v, nil
+}
+
+// GetServiceCode returns the USSD service code the session was started with.
+func (rp *UssdRequestParser) GetServiceCode(rq *http.Request) (string, error) {
+	return rp.field(rq, rp.ServiceCodeField)
+}
+
+// GetPhoneNumber returns the phone number of the client.
+//
+// Fails if the phone number is missing.
+func (rp *UssdRequestParser) GetPhoneNumber(rq *http.Request) (string, error) {
+	v, err := rp.field(rq, rp.PhoneNumberField)
+	if err != nil {
+		return "", err
+	}
+	if v == "" {
+		return "", fmt.Errorf("no phone number found")
+	}
+	return v, nil
+}
+
+// GetInput implements the RequestParser interface.
+//
+// It returns the last element of the cumulative input, or an empty input if the session was just started.
+func (rp *UssdRequestParser) GetInput(rq *http.Request) ([]byte, error) {
+	v, err := rp.field(rq, rp.TextField)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(UssdLastInput(v)), nil
+}
+
+// UssdLastInput returns the latest input of a "*"-joined cumulative USSD input string.
+func UssdLastInput(text string) string {
+	i := strings.LastIndex(text, ussdInputSeparator)
+	if i == -1 {
+		return text
+	}
+	return text[i+1:]
+}
+
+// UssdResponseWriter implements the ResponseWriter interface for USSD gateways.
+//
+// Output is prefixed with "CON" if the session continues, and "END" if the session has terminated.
+type UssdResponseWriter struct {
+}
+
+// NewUssdResponseWriter creates a new UssdResponseWriter.
+func NewUssdResponseWriter() *UssdResponseWriter {
+	return &UssdResponseWriter{}
+}
+
+// Write implements the ResponseWriter interface.
+func (rw *UssdResponseWriter) Write(w http.ResponseWriter, output []byte, cont bool) error {
+	pfx := ussdEndPrefix
+	if cont {
+		pfx = ussdContinuePrefix
+	}
+	b := append([]byte(pfx), output...)
+	w.Header().Set("Content-Type", "text/plain")
+	w.Header().Set("Content-Length", strconv.Itoa(len(b)))
+	w.WriteHeader(http.StatusOK)
+	_, err := w.Write(b)
+	return err
+}
+
+// WriteError implements the ResponseWriter interface.
+//
+// The session is always terminated, with the message as the final screen. The status is always 200, since USSD gateways replace the reply to any other status with a generic network error. The actual status and the error are only logged.
+func (rw *UssdResponseWriter) WriteError(w http.ResponseWriter, code int, msg string, err error) {
+	logg.Errorf("ussd request fail", "code", code, "msg", msg, "err", err)
+	b := []byte(ussdEndPrefix + msg)
+	w.Header().Set("Content-Type", "text/plain")
+	w.Header().Set("Content-Length", strconv.Itoa(len(b)))
+	w.WriteHeader(http.StatusOK)
+	w.Write(b)
+}
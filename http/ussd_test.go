@@ -0,0 +1,104 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	memdb "git.defalsify.org/vise.git/db/mem"
+	"git.defalsify.org/vise.git/engine"
+)
+
+func doUssdRequest(h http.Handler, sessionId string, phone string, text string) *httptest.ResponseRecorder {
+	v := url.Values{}
+	v.Set("sessionId", sessionId)
+	v.Set("serviceCode", "*384*42#")
+	v.Set("phoneNumber", phone)
+	v.Set("text", text)
+	rq := httptest.NewRequest("POST", "/", strings.NewReader(v.Encode()))
+	rq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, rq)
+	return w
+}
+
+func TestUssdLastInput(t *testing.T) {
+	for _, v := range [][2]string{
+		{"", ""},
+		{"1", "1"},
+		{"1*2", "2"},
+		{"1*22*333", "333"},
+		{"1*", ""},
+	} {
+		r := UssdLastInput(v[0])
+		if r != v[1] {
+			t.Errorf("expected '%s' from '%s', got '%s'", v[1], v[0], r)
+		}
+	}
+}
+
+func TestUssdSession(t *testing.T) {
+	ctx := context.Background()
+	rs := newTestResource(t)
+	store := memdb.NewMemDb()
+	err := store.Connect(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := NewSessionHandler(engine.Config{Root: "root"}, rs)
+	h = h.WithPersistDb(store)
+	h = h.WithRequestParser(NewUssdRequestParser())
+	h = h.WithResponseWriter(NewUssdResponseWriter())
+
+	w := doUssdRequest(h, "xyzzy", "+254700000000", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+	expect := "CON hello\n1:foo"
+	if w.Body.String() != expect {
+		t.Fatalf("expected:\n\t%s\ngot:\n\t%s", expect, w.Body.String())
+	}
+
+	w = doUssdRequest(h, "xyzzy", "+254700000000", "1")
+	expect = "END world"
+	if w.Body.String() != expect {
+		t.Fatalf("expected:\n\t%s\ngot:\n\t%s", expect, w.Body.String())
+	}
+}
+
+func TestUssdPhoneSession(t *testing.T) {
+	rs := newTestResource(t)
+	rp := NewUssdRequestParser().WithPhoneSession()
+	h := NewSessionHandler(engine.Config{Root: "root"}, rs)
+	h = h.WithRequestParser(rp)
+	h = h.WithResponseWriter(NewUssdResponseWriter())
+
+	w := doUssdRequest(h, "xyzzy", "", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+	expect := "END Session missing"
+	if w.Body.String() != expect {
+		t.Fatalf("expected '%s', got '%s'", expect, w.Body.String())
+	}
+
+	rq := httptest.NewRequest("POST", "/", strings.NewReader("phoneNumber=%2B254700000000&text=1%2A2"))
+	rq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	sessionId, err := rp.GetSessionId(rq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sessionId != "+254700000000" {
+		t.Fatalf("expected phone number as session id, got '%s'", sessionId)
+	}
+	input, err := rp.GetInput(rq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(input) != "2" {
+		t.Fatalf("expected input '2', got '%s'", input)
+	}
+}
@@ -63,6 +63,11 @@ func (tr *TestResource) AddBytecode(ctx context.Context, key string, val []byte)
 	return tr.db.Put(ctx, []byte(key), val)
 }
 
+func (tr *TestResource) AddSourceMap(ctx context.Context, key string, val []byte) error {
+	tr.db.SetPrefix(db.DATATYPE_BIN)
+	return tr.db.Put(ctx, []byte(key+db.SOURCEMAP_SUFFIX), val)
+}
+
 func (tr *TestResource) AddMenu(ctx context.Context, key string, val string) error {
 	tr.db.SetPrefix(db.DATATYPE_MENU)
 	return tr.db.Put(ctx, []byte(key), []byte(val))
@@ -23,6 +23,7 @@ func TestSerializeState(t *testing.T) {
 	b := vm.NewLine(nil, vm.LOAD, []string{"foo"}, []byte{42}, nil)
 	b = vm.NewLine(b, vm.HALT, nil, nil, nil)
 	st.SetCode(b)
+	st.PushCall(vm.NewLine(nil, vm.MOVE, []string{"bar"}, nil, nil))
 
 	ca := cache.NewCache().WithCacheSize(1024)
 	ca.Add("inky", "pinky", 13)
@@ -53,6 +54,9 @@ func TestSerializeState(t *testing.T) {
 	if !bytes.Equal(stNew.Code, stOld.Code) {
 		t.Fatalf("expected %x, got %x", stNew.Code, stOld.Code)
 	}
+	if !reflect.DeepEqual(stNew.Calls, stOld.Calls) {
+		t.Fatalf("expected %x, got %x", stNew.Calls, stOld.Calls)
+	}
 	if stNew.BitSize != stOld.BitSize {
 		t.Fatalf("expected %v, got %v", stNew.BitSize, stOld.BitSize)
 	}
@@ -3,6 +3,7 @@ package persist
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/fxamacker/cbor/v2"
 
@@ -12,12 +13,17 @@ import (
 )
 
 // Persister abstracts storage and retrieval of state and cache.
+//
+// Save will only succeed if the stored state has not been changed by someone else since it was loaded, or, if nothing was loaded, if no state has been stored at all. Otherwise it fails with db.ErrConflict.
 type Persister struct {
-	State  *state.State
-	Memory *cache.Cache
-	ctx    context.Context
-	db     db.Db
-	flush  bool
+	State    *state.State
+	Memory   *cache.Cache
+	Version  uint64
+	Accessed time.Time
+	ctx      context.Context
+	db       db.Db
+	flush    bool
+	prev     []byte
 }
 
 // NewPersister creates a new Persister instance.
@@ -86,23 +92,33 @@ func (p *Persister) Deserialize(b []byte) error {
 
 // Save persists the state and cache to the db.Db backend.
 //
+// The version is incremented and the last access time is updated on every save. If the persister has been synchronized with the backend by a previous Load or Save, the save will fail with db.ErrConflict if the stored state has changed since then. Otherwise, it will fail with db.ErrConflict if state for the key already exists.
+//
 // If save is successful and WithFlush() has been called, the state and memory
 // will be empty when the method returns.
 func (p *Persister) Save(key string) error {
 	if p.Invalid() {
 		panic("persister has been invalidated")
 	}
+	accessed := p.Accessed
+	p.Version += 1
+	p.Accessed = time.Now()
 	b, err := p.Serialize()
 	if err != nil {
+		p.Version -= 1
+		p.Accessed = accessed
 		return err
 	}
 	p.db.SetPrefix(db.DATATYPE_STATE)
-	logg.Infof("saving state and cache", "self", p, "key", key, "state", p.State)
+	logg.Infof("saving state and cache", "self", p, "key", key, "state", p.State, "version", p.Version)
 	logg.Tracef("saving bytecode", "code", p.State.Code)
-	err = p.db.Put(p.ctx, []byte(key), b)
+	err = p.db.CompareAndSwap(p.ctx, []byte(key), p.prev, b)
 	if err != nil {
+		p.Version -= 1
+		p.Accessed = accessed
 		return err
 	}
+	p.prev = b
 	if p.flush {
 		logg.Tracef("state and cache flushed from persister")
 		p.Memory.Reset()
@@ -113,21 +129,37 @@ func (p *Persister) Save(key string) error {
 }
 
 // Load retrieves state and cache from the db.Db backend.
+//
+// If no state exists for the key, a subsequent Save will fail with db.ErrConflict if state for the key has been stored by someone else in the meantime.
 func (p *Persister) Load(key string) error {
 	p.db.SetPrefix(db.DATATYPE_STATE)
 	b, err := p.db.Get(p.ctx, []byte(key))
 	if err != nil {
+		if db.IsNotFound(err) {
+			p.prev = nil
+		}
 		return err
 	}
 	err = p.Deserialize(b)
 	if err != nil {
 		return err
 	}
-	logg.Infof("loaded state and cache", "self", p, "key", key, "state", p.State)
+	p.prev = b
+	logg.Infof("loaded state and cache", "self", p, "key", key, "state", p.State, "version", p.Version)
 	logg.Tracef("loaded bytecode", "code", p.State.Code)
 	return nil
 }
 
+// Expired returns true if the state was last saved longer ago than the given time to live.
+//
+// If ttl is 0, or if the state has never been saved, the state never expires.
+func (p *Persister) Expired(ttl time.Duration) bool {
+	if ttl == 0 || p.Accessed.IsZero() {
+		return false
+	}
+	return time.Since(p.Accessed) > ttl
+}
+
 // String implements the String interface
 func (p *Persister) String() string {
 	return fmt.Sprintf("persister @%p state:%p cache:%p", p, p.State, p.Memory)
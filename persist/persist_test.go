@@ -5,6 +5,7 @@ import (
 	"testing"
 
 	"git.defalsify.org/vise.git/cache"
+	"git.defalsify.org/vise.git/db"
 	"git.defalsify.org/vise.git/db/mem"
 	"git.defalsify.org/vise.git/state"
 )
@@ -180,3 +181,79 @@ func TestFlush(t *testing.T) {
 		t.Fatalf("expected cache use size 0, got: %v", o.CacheUseSize)
 	}
 }
+
+func TestSaveConflict(t *testing.T) {
+	ctx := context.Background()
+	store := mem.NewMemDb()
+	store.Connect(ctx, "")
+
+	pe := NewPersister(store).WithContent(state.NewState(0), cache.NewCache())
+	err := pe.Load("foo")
+	if !db.IsNotFound(err) {
+		t.Fatalf("expected not found, got: %v", err)
+	}
+	err = pe.Save("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pe.Version != 1 {
+		t.Fatalf("expected version 1, got %d", pe.Version)
+	}
+
+	pe = NewPersister(store).WithContent(state.NewState(0), cache.NewCache())
+	err = pe.Load("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	peOther := NewPersister(store).WithContent(state.NewState(0), cache.NewCache())
+	err = peOther.Load("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = peOther.Save("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if peOther.Version != 2 {
+		t.Fatalf("expected version 2, got %d", peOther.Version)
+	}
+
+	err = pe.Save("foo")
+	if !db.IsConflict(err) {
+		t.Fatalf("expected conflict, got: %v", err)
+	}
+	if pe.Version != 1 {
+		t.Fatalf("expected version 1, got %d", pe.Version)
+	}
+	err = pe.Load("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pe.Version != 2 {
+		t.Fatalf("expected version 2, got %d", pe.Version)
+	}
+	err = pe.Save("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSaveConflictNew(t *testing.T) {
+	ctx := context.Background()
+	store := mem.NewMemDb()
+	store.Connect(ctx, "")
+
+	pe := NewPersister(store).WithContent(state.NewState(0), cache.NewCache())
+	peOther := NewPersister(store).WithContent(state.NewState(0), cache.NewCache())
+	err := peOther.Save("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = pe.Save("foo")
+	if !db.IsConflict(err) {
+		t.Fatalf("expected conflict, got: %v", err)
+	}
+	if pe.Version != 0 {
+		t.Fatalf("expected version 0, got %d", pe.Version)
+	}
+}
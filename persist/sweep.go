@@ -0,0 +1,110 @@
+package persist
+
+import (
+	"context"
+	"time"
+
+	"git.defalsify.org/vise.git/db"
+)
+
+// Sweeper removes persisted state of sessions that have not been accessed within a time to live.
+type Sweeper struct {
+	db  db.Db
+	ttl time.Duration
+}
+
+// expirer is implemented by backends that record when each entry was last updated, and can delete expired entries without reading them.
+type expirer interface {
+	Expire(ctx context.Context, ttl time.Duration) ([][]byte, error)
+}
+
+// NewSweeper creates a new Sweeper for the state persisted in the given db.Db.
+//
+// The sweeper changes the prefix and session of the db.Db, so it must be a handle dedicated to the sweeper, such as a view created with db.SharedDb.View, or a separate connection. It must not be shared with a Persister or a resource.
+func NewSweeper(store db.Db, ttl time.Duration) *Sweeper {
+	return &Sweeper{
+		db:  store,
+		ttl: ttl,
+	}
+}
+
+// Sweep iterates all persisted state, and deletes the state of expired sessions.
+//
+// Entries that cannot be decoded as persisted state are skipped. A state is only deleted if it has not changed since it was read, so that a session that is resumed during the sweep is kept.
+//
+// If the backend records when each entry was last updated, as postgres does, expired states are instead deleted by the backend directly, according to that record.
+//
+// If the time to live is 0, nothing is deleted.
+//
+// Returns the keys of the deleted states.
+func (sw *Sweeper) Sweep(ctx context.Context) ([]string, error) {
+	var r []string
+	var ks []string
+	var vs [][]byte
+
+	if sw.ttl == 0 {
+		return r, nil
+	}
+	sw.db.SetSession("")
+	sw.db.SetPrefix(db.DATATYPE_STATE)
+	e, ok := sw.db.(expirer)
+	if ok {
+		return sw.expire(ctx, e)
+	}
+	d, err := sw.db.Dump(ctx, []byte{})
+	if err != nil {
+		if db.IsNotFound(err) {
+			return r, nil
+		}
+		return nil, err
+	}
+	for true {
+		k, v := d.Next(ctx)
+		if k == nil {
+			break
+		}
+		pe := NewPersister(sw.db)
+		err = pe.Deserialize(v)
+		if err != nil {
+			logg.DebugCtxf(ctx, "skip undecodable entry in sweep", "key", k, "err", err)
+			continue
+		}
+		if pe.Expired(sw.ttl) {
+			ks = append(ks, string(k))
+			vs = append(vs, v)
+		}
+	}
+	err = d.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	sw.db.SetPrefix(db.DATATYPE_STATE)
+	for i, k := range ks {
+		err = sw.db.CompareAndDelete(ctx, []byte(k), vs[i])
+		if err != nil {
+			if db.IsConflict(err) {
+				logg.DebugCtxf(ctx, "skip session state changed during sweep", "key", k)
+				continue
+			}
+			return r, err
+		}
+		logg.DebugCtxf(ctx, "swept expired session state", "key", k)
+		r = append(r, k)
+	}
+	return r, nil
+}
+
+// delete expired states using the record of the backend.
+func (sw *Sweeper) expire(ctx context.Context, e expirer) ([]string, error) {
+	var r []string
+	ks, err := e.Expire(ctx, sw.ttl)
+	if err != nil {
+		return nil, err
+	}
+	for _, k := range ks {
+		logg.DebugCtxf(ctx, "swept expired session state", "key", k)
+		r = append(r, string(k))
+	}
+	return r, nil
+}
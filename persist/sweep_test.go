@@ -0,0 +1,135 @@
+package persist
+
+import (
+	"context"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"git.defalsify.org/vise.git/cache"
+	"git.defalsify.org/vise.git/db"
+	"git.defalsify.org/vise.git/db/fs"
+	"git.defalsify.org/vise.git/state"
+)
+
+func TestSweep(t *testing.T) {
+	ctx := context.Background()
+	d, err := ioutil.TempDir("", "vise-persist-sweep-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := fs.NewFsDb()
+	err = store.Connect(ctx, d)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pe := NewPersister(store).WithSession("foo").WithContent(state.NewState(0), cache.NewCache())
+	err = pe.Save("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pe = NewPersister(store).WithSession("bar").WithContent(state.NewState(0), cache.NewCache())
+	pe.Accessed = time.Now().Add(-time.Hour)
+	b, err := pe.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.SetPrefix(db.DATATYPE_STATE)
+	err = store.Put(ctx, []byte("bar"), b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sw := NewSweeper(store, time.Minute)
+	r, err := sw.Sweep(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r) != 1 || r[0] != "bar.bar" {
+		t.Fatalf("expected only 'bar.bar' swept, got: %v", r)
+	}
+
+	store.SetSession("bar")
+	_, err = store.Get(ctx, []byte("bar"))
+	if !db.IsNotFound(err) {
+		t.Fatalf("expected expired state deleted, got: %v", err)
+	}
+	store.SetSession("foo")
+	_, err = store.Get(ctx, []byte("foo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// resumes a session after its state has been read by the sweeper.
+type resumeDb struct {
+	db.Db
+	resume func()
+}
+
+func (rdb *resumeDb) Dump(ctx context.Context, key []byte) (*db.Dumper, error) {
+	d, err := rdb.Db.Dump(ctx, key)
+	rdb.resume()
+	return d, err
+}
+
+func TestSweepResumed(t *testing.T) {
+	ctx := context.Background()
+	d, err := ioutil.TempDir("", "vise-persist-sweep-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := fs.NewFsDb()
+	err = store.Connect(ctx, d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shared := db.NewSharedDb(store)
+
+	pe := NewPersister(shared.View()).WithSession("foo").WithContent(state.NewState(0), cache.NewCache())
+	err = pe.Save("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pe.Accessed = time.Now().Add(-time.Hour)
+	pe.Version = 0
+	b, err := pe.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.SetSession("foo")
+	store.SetPrefix(db.DATATYPE_STATE)
+	err = store.Put(ctx, []byte("foo"), b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rdb := &resumeDb{
+		Db: shared.View(),
+		resume: func() {
+			pe := NewPersister(shared.View()).WithSession("foo")
+			err := pe.Load("foo")
+			if err == nil {
+				err = pe.Save("foo")
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		},
+	}
+	sw := NewSweeper(rdb, time.Minute)
+	r, err := sw.Sweep(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r) != 0 {
+		t.Fatalf("expected resumed session kept, got: %v", r)
+	}
+	pe = NewPersister(shared.View()).WithSession("foo")
+	err = pe.Load("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+}
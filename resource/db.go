@@ -38,6 +38,7 @@ func NewDbResource(store db.Db) *DbResource {
 	rs.WithCodeGetter(rs.DbGetCode)
 	rs.WithTemplateGetter(rs.DbGetTemplate)
 	rs.WithEntryFuncGetter(rs.DbFuncFor)
+	rs.WithSourceMapGetter(rs.DbGetSourceMap)
 	return rs
 }
 
@@ -122,6 +123,19 @@ func (g *DbResource) DbGetCode(ctx context.Context, sym string) ([]byte, error)
 	return g.fn(ctx, sym)
 }
 
+// The source map is stored along with the bytecode, with the key suffix db.SOURCEMAP_SUFFIX.
+//
+// Will fail if support for db.DATATYPE_BIN has been disabled.
+//
+// By default bound to GetSourceMap. Can be replaced with WithSourceMapGetter.
+func (g *DbResource) DbGetSourceMap(ctx context.Context, sym string) ([]byte, error) {
+	if g.typs&db.DATATYPE_BIN == 0 {
+		return nil, errors.New("not a source map getter")
+	}
+	g.db.SetPrefix(db.DATATYPE_BIN)
+	return g.fn(ctx, sym+db.SOURCEMAP_SUFFIX)
+}
+
 // The method will first attempt to resolve using the function registered
 // with the MenuResource parent class.
 //
@@ -36,6 +36,9 @@ type TemplateFunc func(ctx context.Context, nodeSym string) (string, error)
 // FuncForFunc is a function that returns an EntryFunc associated with a LOAD instruction symbol.
 type FuncForFunc func(ctx context.Context, loadSym string) (EntryFunc, error)
 
+// SourceMapFunc is the function signature for retrieving the serialized source map of the bytecode for a given symbol.
+type SourceMapFunc func(ctx context.Context, nodeSym string) ([]byte, error)
+
 // Resource implementation are responsible for retrieving values and templates for symbols, and can render templates from value dictionaries.
 //
 // All methods must fail if the symbol cannot be resolved.
@@ -52,14 +55,23 @@ type Resource interface {
 	Close(ctx context.Context) error
 }
 
+// SourceMapper is implemented by Resource implementations that can retrieve source maps for bytecode.
+//
+// The source map relates bytecode offsets to positions in the assembly source, as serialized by vm.SourceMap.
+type SourceMapper interface {
+	// GetSourceMap retrieves the serialized source map of the bytecode associated with the given symbol.
+	GetSourceMap(ctx context.Context, nodeSym string) ([]byte, error)
+}
+
 // MenuResource contains the base definition for building Resource implementations.
 type MenuResource struct {
-	sinkValues   []string
-	codeFunc     CodeFunc
-	templateFunc TemplateFunc
-	menuFunc     MenuFunc
-	funcFunc     FuncForFunc
-	fns          map[string]EntryFunc
+	sinkValues    []string
+	codeFunc      CodeFunc
+	templateFunc  TemplateFunc
+	menuFunc      MenuFunc
+	funcFunc      FuncForFunc
+	sourceMapFunc SourceMapFunc
+	fns           map[string]EntryFunc
 }
 
 var (
@@ -71,6 +83,9 @@ var (
 		logg.WarnCtxf(ctx, "no resource getter set!", "s", s)
 		return "", nil
 	}
+	noSourceMapFunc = func(ctx context.Context, s string) ([]byte, error) {
+		return nil, fmt.Errorf("no source map for %s", s)
+	}
 )
 
 // NewMenuResource creates a new MenuResource instance.
@@ -80,6 +95,7 @@ func NewMenuResource() *MenuResource {
 	rs.codeFunc = noBinFunc
 	rs.templateFunc = noStrFunc
 	rs.menuFunc = noStrFunc
+	rs.sourceMapFunc = noSourceMapFunc
 	return rs
 }
 
@@ -107,6 +123,12 @@ func (m *MenuResource) WithMenuGetter(menuGetter MenuFunc) *MenuResource {
 	return m
 }
 
+// WithSourceMapGetter sets the source map resolver method.
+func (m *MenuResource) WithSourceMapGetter(sourceMapGetter SourceMapFunc) *MenuResource {
+	m.sourceMapFunc = sourceMapGetter
+	return m
+}
+
 // FuncFor implements Resource interface.
 func (m *MenuResource) FuncFor(ctx context.Context, sym string) (EntryFunc, error) {
 	return m.funcFunc(ctx, sym)
@@ -127,6 +149,11 @@ func (m *MenuResource) GetMenu(ctx context.Context, sym string) (string, error)
 	return m.menuFunc(ctx, sym)
 }
 
+// GetSourceMap implements SourceMapper interface.
+func (m *MenuResource) GetSourceMap(ctx context.Context, sym string) ([]byte, error) {
+	return m.sourceMapFunc(ctx, sym)
+}
+
 // AddLocalFunc associates a handler function with a external function symbol to be returned by FallbackFunc.
 func (m *MenuResource) AddLocalFunc(sym string, fn EntryFunc) {
 	if m.fns == nil {
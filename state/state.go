@@ -12,8 +12,9 @@ const (
 )
 
 var (
-	IndexError = fmt.Errorf("already at first index")
-	MaxLevel   = 128
+	IndexError   = fmt.Errorf("already at first index")
+	MaxLevel     = 128
+	MaxCallDepth = 16
 )
 
 // State holds the command stack, error condition of a unique execution session.
@@ -33,6 +34,7 @@ var (
 // 8 first flags are reserved.
 type State struct {
 	Code     []byte         // Pending bytecode to execute
+	Calls    [][]byte       // Caller bytecode to resume on return from subroutine calls
 	ExecPath []string       // Command symbols stack
 	BitSize  uint32         // Size of (32-bit capacity) bit flag byte array
 	SizeIdx  uint16         // Lateral page browse index in current frame
@@ -354,6 +356,43 @@ func (st *State) GetCode() ([]byte, error) {
 	return b, nil
 }
 
+// PushCall saves the remaining bytecode of a caller, to be resumed when the subroutine call returns.
+//
+// Fails if MaxCallDepth is exceeded.
+func (st *State) PushCall(b []byte) error {
+	l := len(st.Calls)
+	if l >= MaxCallDepth {
+		return fmt.Errorf("max call depth exceeded (%d)", MaxCallDepth)
+	}
+	st.Calls = append(st.Calls, b)
+	logg.Debugf("call pushed", "depth", l+1, "code", b)
+	return nil
+}
+
+// PopCall returns the remaining bytecode of the caller of the current subroutine.
+//
+// Fails if no subroutine call is active.
+func (st *State) PopCall() ([]byte, error) {
+	l := len(st.Calls)
+	if l == 0 {
+		return nil, fmt.Errorf("return called outside of subroutine")
+	}
+	b := st.Calls[l-1]
+	st.Calls = st.Calls[:l-1]
+	logg.Debugf("call popped", "depth", l-1, "code", b)
+	return b, nil
+}
+
+// CallDepth returns the number of active subroutine calls.
+func (st *State) CallDepth() int {
+	return len(st.Calls)
+}
+
+// ResetCalls discards all active subroutine calls.
+func (st *State) ResetCalls() {
+	st.Calls = nil
+}
+
 // GetInput gets the most recent client input.
 func (st *State) GetInput() ([]byte, error) {
 	if st.input == nil {
@@ -383,6 +422,7 @@ func (st *State) Restart() error {
 	st.SizeIdx = 0
 	st.input = []byte{}
 	st.ExecPath = st.ExecPath[:1]
+	st.Calls = nil
 	st.lastMove = 0
 	return err
 }
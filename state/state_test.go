@@ -509,3 +509,36 @@ func TestStateLateral(t *testing.T) {
 		t.Fatal("expected not lateral")
 	}
 }
+
+func TestStateCall(t *testing.T) {
+	st := NewState(0)
+	st.Down("root")
+	_, err := st.PopCall()
+	if err == nil {
+		t.Fatal("expected error on return without call")
+	}
+	for i := 0; i < MaxCallDepth; i++ {
+		err = st.PushCall([]byte{byte(i)})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = st.PushCall([]byte{0x2a})
+	if err == nil {
+		t.Fatal("expected error on max call depth")
+	}
+	if st.CallDepth() != MaxCallDepth {
+		t.Fatalf("expected depth %d, got %d", MaxCallDepth, st.CallDepth())
+	}
+	b, err := st.PopCall()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, []byte{byte(MaxCallDepth - 1)}) {
+		t.Fatalf("expected last pushed code, got %x", b)
+	}
+	st.Restart()
+	if st.CallDepth() != 0 {
+		t.Fatalf("expected no calls after restart, got %d", st.CallDepth())
+	}
+}
@@ -0,0 +1,2 @@
+// Package verify statically checks the bytecode of an application for references that cannot be resolved at runtime.
+package verify
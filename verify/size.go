@@ -0,0 +1,195 @@
+package verify
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"text/template"
+
+	"git.defalsify.org/vise.git/db"
+	"git.defalsify.org/vise.git/lang"
+)
+
+// nodeInfo holds the render properties of a node collected from its bytecode.
+type nodeInfo struct {
+	// symbols mapped to the template.
+	maps []string
+	// selector and title of menu items.
+	menu [][2]string
+	// selector and title of browse menu items.
+	browse [][2]string
+	// set if menu items are rendered as sink.
+	msink bool
+}
+
+// NodeSize is the worst case render size of a node in a single language.
+type NodeSize struct {
+	// Node symbol.
+	Node string
+	// Language code, or empty for the default language.
+	Language string
+	// Worst case byte size of the rendered output.
+	Size uint32
+	// Set if the node renders sink content, in which case Size excludes the sink content that is paginated to fit the output size.
+	Sink bool
+}
+
+// String implements the String interface.
+func (n NodeSize) String() string {
+	ln := n.Language
+	if ln == "" {
+		ln = "default"
+	}
+	s := fmt.Sprintf("%s %s %d", n.Node, ln, n.Size)
+	if n.Sink {
+		s += " (sink)"
+	}
+	return s
+}
+
+// WithOutputSize is a chainable function that sets the maximum output size of a rendered page.
+//
+// If set, nodes whose worst case render size exceeds it in any language are reported.
+func (v *Verifier) WithOutputSize(outputSize uint32) *Verifier {
+	v.outputSize = outputSize
+	return v
+}
+
+// WithMenuSeparator is a chainable function that sets the string used to separate menu selectors and titles.
+//
+// It should match the separator used by the vm, which by default is ":".
+func (v *Verifier) WithMenuSeparator(sep string) *Verifier {
+	v.sep = sep
+	return v
+}
+
+// WithLanguages is a chainable function that sets the languages to calculate render sizes for, in addition to the default language.
+func (v *Verifier) WithLanguages(lns []lang.Language) *Verifier {
+	v.langs = lns
+	return v
+}
+
+// Sizes returns the worst case render sizes of all nodes walked by the last call to Verify.
+func (v *Verifier) Sizes() []NodeSize {
+	return v.sizes
+}
+
+// record the largest reserved size of a LOAD symbol.
+//
+// A size of 0 is kept only if no other size has been seen, since it denotes a sink.
+func (v *Verifier) addLoad(sym string, sz uint32) {
+	cur, ok := v.loads[sym]
+	if !ok || sz > cur {
+		v.loads[sym] = sz
+	}
+}
+
+// calculate worst case render sizes of a node in all languages.
+func (v *Verifier) size(ctx context.Context, node string) {
+	ctxs := []context.Context{ctx}
+	for _, ln := range v.langs {
+		ctxs = append(ctxs, context.WithValue(ctx, "Language", ln))
+	}
+	for _, lctx := range ctxs {
+		var code string
+		ln, ok := lang.LanguageFromContext(lctx)
+		if ok {
+			code = ln.Code
+		}
+		r, err := v.nodeSize(lctx, node)
+		if err != nil {
+			v.add(Finding{Issue: ISSUE_SIZE, Node: node, Offset: -1, Err: fmt.Errorf("language %s: %v", code, err)})
+			continue
+		}
+		r.Language = code
+		logg.DebugCtxf(ctx, "node size", "node", node, "lang", code, "size", r.Size, "sink", r.Sink)
+		v.sizes = append(v.sizes, r)
+		if v.outputSize > 0 && r.Size > v.outputSize {
+			err = fmt.Errorf("worst case size %d in language %s exceeds %d", r.Size, code, v.outputSize)
+			if code == "" {
+				err = fmt.Errorf("worst case size %d exceeds %d", r.Size, v.outputSize)
+			}
+			v.add(Finding{Issue: ISSUE_SIZE, Node: node, Offset: -1, Err: err})
+		}
+	}
+}
+
+// render a menu item line.
+func (v *Verifier) menuLine(ctx context.Context, item [2]string) (string, error) {
+	title, err := v.rs.GetMenu(ctx, item[1])
+	if err != nil {
+		return "", err
+	}
+	return item[0] + v.sep + title, nil
+}
+
+// calculate the worst case render size of a node in the language of the context.
+//
+// Mapped symbols are rendered at the size reserved by their LOAD instruction. Browse menu items are included if the node renders a sink.
+func (v *Verifier) nodeSize(ctx context.Context, node string) (NodeSize, error) {
+	var lines []string
+	r := NodeSize{
+		Node: node,
+	}
+	ni := v.nodes[node]
+
+	tpl, err := v.rs.GetTemplate(ctx, node)
+	if err != nil {
+		if !db.IsNotFound(err) {
+			return r, err
+		}
+		tpl = ""
+	}
+	values := make(map[string]string)
+	for _, sym := range ni.maps {
+		sz := v.loads[sym]
+		if sz == 0 {
+			r.Sink = true
+		}
+		values[sym] = strings.Repeat("x", int(sz))
+	}
+	if ni.msink {
+		r.Sink = true
+		tpl += "\n"
+	}
+	tp, err := template.New("size").Option("missingkey=zero").Parse(tpl)
+	if err != nil {
+		return r, err
+	}
+	b := bytes.NewBuffer(nil)
+	err = tp.Execute(b, values)
+	if err != nil {
+		return r, err
+	}
+	r.Size = uint32(b.Len())
+
+	var maxItem int
+	for _, item := range ni.menu {
+		s, err := v.menuLine(ctx, item)
+		if err != nil {
+			return r, err
+		}
+		if ni.msink {
+			if len(s) > maxItem {
+				maxItem = len(s)
+			}
+			continue
+		}
+		lines = append(lines, s)
+	}
+	if r.Sink {
+		for _, item := range ni.browse {
+			s, err := v.menuLine(ctx, item)
+			if err != nil {
+				return r, err
+			}
+			lines = append(lines, s)
+		}
+	}
+	if len(lines) > 0 {
+		r.Size += uint32(len(strings.Join(lines, "\n")) + 1)
+	}
+	r.Size += uint32(maxItem)
+	return r, nil
+}
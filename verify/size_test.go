@@ -0,0 +1,81 @@
+package verify
+
+import (
+	"context"
+	"testing"
+
+	"git.defalsify.org/vise.git/internal/resourcetest"
+	"git.defalsify.org/vise.git/lang"
+	"git.defalsify.org/vise.git/vm"
+)
+
+func TestSize(t *testing.T) {
+	var b []byte
+	ctx := context.Background()
+	rs := resourcetest.NewTestResource()
+
+	b = vm.NewLine(nil, vm.LOAD, []string{"foo"}, []byte{0x0a}, nil)
+	b = vm.NewLine(b, vm.MAP, []string{"foo"}, nil, nil)
+	b = vm.NewLine(b, vm.MOUT, []string{"one", "0"}, nil, nil)
+	b = vm.NewLine(b, vm.MOUT, []string{"two", "1"}, nil, nil)
+	b = vm.NewLine(b, vm.HALT, nil, nil, nil)
+	b = vm.NewLine(b, vm.INCMP, []string{"list", "1"}, nil, nil)
+	rs.AddBytecode(ctx, "root", b)
+	rs.AddTemplate(ctx, "root", "Hello {{.foo}}")
+	rs.AddTemplate(ctx, "root_nor", "Hallo og velkommen {{.foo}}")
+	rs.AddMenu(ctx, "one_menu", "Number one")
+
+	b = vm.NewLine(nil, vm.LOAD, []string{"bar"}, []byte{0x00}, nil)
+	b = vm.NewLine(b, vm.MAP, []string{"bar"}, nil, nil)
+	b = vm.NewLine(b, vm.MNEXT, []string{"fwd", "11"}, nil, nil)
+	b = vm.NewLine(b, vm.MPREV, []string{"back", "22"}, nil, nil)
+	b = vm.NewLine(b, vm.HALT, nil, nil, nil)
+	b = vm.NewLine(b, vm.INCMP, []string{">", "11"}, nil, nil)
+	b = vm.NewLine(b, vm.INCMP, []string{"<", "22"}, nil, nil)
+	rs.AddBytecode(ctx, "list", b)
+	rs.AddTemplate(ctx, "list", "{{.bar}}")
+	rs.AddFunc(ctx, "foo", noop)
+	rs.AddFunc(ctx, "bar", noop)
+	rs.Lock()
+
+	ln, err := lang.LanguageFromCode("nor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := NewVerifier(rs).WithOutputSize(40).WithLanguages([]lang.Language{ln})
+	r, err := v.Verify(ctx, "root")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r) != 1 || r[0].Issue != ISSUE_SIZE || r[0].Node != "root" {
+		t.Fatalf("expected size finding for root, got %v", r)
+	}
+
+	expect := []NodeSize{
+		{Node: "root", Size: 35},
+		{Node: "root", Language: "nor", Size: 48},
+		{Node: "list", Size: 15, Sink: true},
+		{Node: "list", Language: "nor", Size: 15, Sink: true},
+	}
+	sizes := v.Sizes()
+	if len(sizes) != len(expect) {
+		t.Fatalf("expected %d sizes, got %v", len(expect), sizes)
+	}
+	for i, x := range expect {
+		if sizes[i] != x {
+			t.Fatalf("size %d: expected %v, got %v", i, x, sizes[i])
+		}
+	}
+
+	v = NewVerifier(rs).WithOutputSize(40).WithMenuSeparator(" - ")
+	r, err = v.Verify(ctx, "root")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r) > 0 {
+		t.Fatalf("expected no findings, got %v", r)
+	}
+	if v.Sizes()[0].Size != 39 {
+		t.Fatalf("expected size 39, got %v", v.Sizes()[0])
+	}
+}
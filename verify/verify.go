@@ -0,0 +1,374 @@
+package verify
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"git.defalsify.org/vise.git/lang"
+	"git.defalsify.org/vise.git/resource"
+	"git.defalsify.org/vise.git/state"
+	"git.defalsify.org/vise.git/vm"
+)
+
+// Issue identifies the kind of problem a Finding describes.
+type Issue uint8
+
+const (
+	// Bytecode could not be parsed.
+	ISSUE_PARSE Issue = iota + 1
+	// A MOVE, CATCH, INCMP, INPAT or CALL target node has no bytecode.
+	ISSUE_NODE
+	// A LOAD or RELOAD symbol has no external function.
+	ISSUE_FUNC
+	// A MOUT menu symbol has no menu resource.
+	ISSUE_MENU
+	// A node cannot be reached from the root node.
+	ISSUE_UNREACHABLE
+	// A node has neither HALT, MOVE nor RET, and so can neither wait for input nor continue.
+	ISSUE_DEADEND
+	// The worst case render size of a node exceeds the output size.
+	ISSUE_SIZE
+	// An INPAT input class has no registered input pattern.
+	ISSUE_PATTERN
+)
+
+var (
+	issueString = map[Issue]string{
+		ISSUE_PARSE:       "parse error",
+		ISSUE_NODE:        "unresolved node",
+		ISSUE_FUNC:        "unresolved function",
+		ISSUE_MENU:        "unresolved menu",
+		ISSUE_UNREACHABLE: "unreachable node",
+		ISSUE_DEADEND:     "dead end node",
+		ISSUE_SIZE:        "output size exceeded",
+		ISSUE_PATTERN:     "unresolved input pattern",
+	}
+)
+
+// String implements the String interface.
+func (i Issue) String() string {
+	return issueString[i]
+}
+
+// Finding is a single problem found by the Verifier.
+type Finding struct {
+	// Kind of problem.
+	Issue Issue
+	// Node in which the problem was found.
+	Node string
+	// Byte offset of the instruction in the node bytecode, or -1 if the problem concerns the whole node.
+	Offset int
+	// Opcode of the instruction, if Offset is not -1.
+	Op vm.Opcode
+	// Symbol that could not be resolved, if any.
+	Sym string
+	// Error returned when resolving the symbol, if any.
+	Err error
+}
+
+// String implements the String interface.
+//
+// The format is "<node>:<offset> <opcode> <symbol>: <issue>: <error>", where parts that do not apply are omitted.
+func (f Finding) String() string {
+	s := f.Node
+	if f.Offset >= 0 {
+		s += fmt.Sprintf(":%d %s", f.Offset, vm.OpcodeString[f.Op])
+	}
+	if f.Sym != "" {
+		s += " " + f.Sym
+	}
+	s += ": " + f.Issue.String()
+	if f.Err != nil {
+		s += ": " + f.Err.Error()
+	}
+	return s
+}
+
+// Verifier walks the bytecode of an application from a root node, and reports references that cannot be resolved.
+type Verifier struct {
+	rs         resource.Resource
+	syms       []string
+	strictMenu bool
+	outputSize uint32
+	sep        string
+	langs      []lang.Language
+	code       map[string][]byte
+	nodes      map[string]*nodeInfo
+	loads      map[string]uint32
+	sizes      []NodeSize
+	findings   []Finding
+}
+
+// NewVerifier creates a new Verifier using the given resource to resolve symbols.
+func NewVerifier(rs resource.Resource) *Verifier {
+	return &Verifier{
+		rs:  rs,
+		sep: ":",
+	}
+}
+
+// WithSymbols is a chainable function that sets the symbols of all nodes in the application.
+//
+// If set, nodes in the list that are not reachable from the root node will be reported.
+func (v *Verifier) WithSymbols(syms []string) *Verifier {
+	v.syms = syms
+	return v
+}
+
+// WithStrictMenu is a chainable function that reports MOUT menu symbols for which the resource returns the symbol itself.
+//
+// Some resource implementations, like resource.DbResource, fall back to the menu symbol when no menu resource exists.
+func (v *Verifier) WithStrictMenu() *Verifier {
+	v.strictMenu = true
+	return v
+}
+
+// Verify walks all nodes reachable from the root symbol, and returns the problems found.
+//
+// The _catch node is also walked if it exists, since the vm moves to it on errors.
+//
+// Findings are ordered by node, and by offset within each node.
+func (v *Verifier) Verify(ctx context.Context, root string) ([]Finding, error) {
+	v.code = make(map[string][]byte)
+	v.nodes = make(map[string]*nodeInfo)
+	v.loads = make(map[string]uint32)
+	v.sizes = []NodeSize{}
+	v.findings = []Finding{}
+
+	var q []string
+	_, err := v.getCode(ctx, root)
+	if err != nil {
+		v.add(Finding{Issue: ISSUE_NODE, Node: root, Offset: -1, Err: err})
+		return v.findings, nil
+	}
+	q = append(q, root)
+	_, err = v.getCode(ctx, "_catch")
+	if err == nil {
+		q = append(q, "_catch")
+	}
+
+	var order []string
+	seen := make(map[string]bool)
+	for len(q) > 0 {
+		sym := q[0]
+		q = q[1:]
+		if seen[sym] {
+			continue
+		}
+		seen[sym] = true
+		order = append(order, sym)
+		next := v.node(ctx, sym)
+		q = append(q, next...)
+	}
+
+	for _, sym := range order {
+		v.size(ctx, sym)
+	}
+
+	for _, sym := range v.syms {
+		if !seen[sym] {
+			v.add(Finding{Issue: ISSUE_UNREACHABLE, Node: sym, Offset: -1})
+		}
+	}
+
+	sort.SliceStable(v.findings, func(i int, j int) bool {
+		if v.findings[i].Node != v.findings[j].Node {
+			return v.findings[i].Node < v.findings[j].Node
+		}
+		return v.findings[i].Offset < v.findings[j].Offset
+	})
+	return v.findings, nil
+}
+
+// record a finding.
+func (v *Verifier) add(f Finding) {
+	logg.Debugf("verify finding", "finding", f)
+	v.findings = append(v.findings, f)
+}
+
+// retrieve and cache bytecode for a node.
+func (v *Verifier) getCode(ctx context.Context, sym string) ([]byte, error) {
+	code, ok := v.code[sym]
+	if ok {
+		return code, nil
+	}
+	code, err := v.rs.GetCode(ctx, sym)
+	if err != nil {
+		return nil, err
+	}
+	v.code[sym] = code
+	return code, nil
+}
+
+// check whether a navigation target refers to a node.
+func isNode(sym string) bool {
+	switch sym {
+	case "_", ">", "<", "^", ".":
+		return false
+	}
+	return true
+}
+
+// verify a single node, and return the nodes it refers to.
+func (v *Verifier) node(ctx context.Context, node string) []string {
+	var next []string
+	var halt bool
+	var move bool
+
+	ni := &nodeInfo{}
+	v.nodes[node] = ni
+
+	code, _ := v.getCode(ctx, node)
+	b := code
+	logg.TraceCtxf(ctx, "verify node", "node", node, "code", code)
+
+	target := func(off int, op vm.Opcode, sym string) {
+		if !isNode(sym) {
+			return
+		}
+		_, err := v.getCode(ctx, sym)
+		if err != nil {
+			v.add(Finding{Issue: ISSUE_NODE, Node: node, Offset: off, Op: op, Sym: sym, Err: err})
+			return
+		}
+		next = append(next, sym)
+	}
+
+	for len(b) > 0 {
+		var sym string
+		off := len(code) - len(b)
+		op, bb, err := vm.ParseOp(b)
+		if err == nil {
+			switch op {
+			case vm.CATCH:
+				sym, _, _, bb, err = vm.ParseCatch(bb)
+				if err == nil {
+					target(off, op, sym)
+				}
+			case vm.CROAK:
+				_, _, bb, err = vm.ParseCroak(bb)
+			case vm.LOAD:
+				var sz uint32
+				sym, sz, bb, err = vm.ParseLoad(bb)
+				if err == nil {
+					v.checkFunc(ctx, node, off, op, sym)
+					v.addLoad(sym, sz)
+				}
+			case vm.RELOAD:
+				sym, bb, err = vm.ParseReload(bb)
+				if err == nil {
+					v.checkFunc(ctx, node, off, op, sym)
+					ni.maps = append(ni.maps, sym)
+				}
+			case vm.MAP:
+				sym, bb, err = vm.ParseMap(bb)
+				if err == nil {
+					ni.maps = append(ni.maps, sym)
+				}
+			case vm.MOVE:
+				sym, bb, err = vm.ParseMove(bb)
+				if err == nil {
+					move = true
+					target(off, op, sym)
+				}
+			case vm.HALT:
+				bb, err = vm.ParseHalt(bb)
+				halt = true
+			case vm.INCMP:
+				sym, _, bb, err = vm.ParseInCmp(bb)
+				if err == nil {
+					target(off, op, sym)
+				}
+			case vm.INPAT:
+				var class string
+				sym, class, bb, err = vm.ParseInPat(bb)
+				if err == nil {
+					target(off, op, sym)
+					_, perr := vm.InputPattern(class)
+					if perr != nil {
+						v.add(Finding{Issue: ISSUE_PATTERN, Node: node, Offset: off, Op: op, Sym: class, Err: perr})
+					}
+				}
+			case vm.MSINK:
+				bb, err = vm.ParseMSink(bb)
+				ni.msink = true
+			case vm.MOUT:
+				var sel string
+				sym, sel, bb, err = vm.ParseMOut(bb)
+				if err == nil {
+					v.checkMenu(ctx, node, off, op, sym)
+					ni.menu = append(ni.menu, [2]string{sel, sym})
+				}
+			case vm.MNEXT:
+				var sel string
+				sym, sel, bb, err = vm.ParseMNext(bb)
+				if err == nil {
+					ni.browse = append(ni.browse, [2]string{sel, sym})
+				}
+			case vm.MPREV:
+				var sel string
+				sym, sel, bb, err = vm.ParseMPrev(bb)
+				if err == nil {
+					ni.browse = append(ni.browse, [2]string{sel, sym})
+				}
+			case vm.JUMP:
+				var jmp uint32
+				jmp, bb, err = vm.ParseJump(bb)
+				if err == nil && int(jmp) > len(bb) {
+					err = fmt.Errorf("jump offset %d past end of code", jmp)
+				}
+			case vm.JUMPIF:
+				var jmp uint32
+				jmp, _, _, bb, err = vm.ParseJumpIf(bb)
+				if err == nil && int(jmp) > len(bb) {
+					err = fmt.Errorf("jump offset %d past end of code", jmp)
+				}
+			case vm.CALL:
+				sym, bb, err = vm.ParseCall(bb)
+				if err == nil {
+					target(off, op, sym)
+				}
+			case vm.RET:
+				bb, err = vm.ParseRet(bb)
+				move = true
+			case vm.FSET, vm.FRESET:
+				var flag uint32
+				flag, bb, err = vm.ParseFSet(bb)
+				if err == nil && !state.IsWriteableFlag(flag) {
+					err = fmt.Errorf("flag %d is not writeable", flag)
+				}
+			default:
+				err = fmt.Errorf("unhandled opcode: %v", op)
+			}
+		}
+		if err != nil {
+			v.add(Finding{Issue: ISSUE_PARSE, Node: node, Offset: off, Op: op, Err: err})
+			return next
+		}
+		b = bb
+	}
+	if !halt && !move {
+		v.add(Finding{Issue: ISSUE_DEADEND, Node: node, Offset: -1})
+	}
+	return next
+}
+
+// check that a LOAD or RELOAD symbol resolves to an external function.
+func (v *Verifier) checkFunc(ctx context.Context, node string, off int, op vm.Opcode, sym string) {
+	_, err := v.rs.FuncFor(ctx, sym)
+	if err != nil {
+		v.add(Finding{Issue: ISSUE_FUNC, Node: node, Offset: off, Op: op, Sym: sym, Err: err})
+	}
+}
+
+// check that a MOUT symbol resolves to a menu resource.
+func (v *Verifier) checkMenu(ctx context.Context, node string, off int, op vm.Opcode, sym string) {
+	r, err := v.rs.GetMenu(ctx, sym)
+	if err == nil && v.strictMenu && r == sym {
+		err = fmt.Errorf("no menu resource")
+	}
+	if err != nil {
+		v.add(Finding{Issue: ISSUE_MENU, Node: node, Offset: off, Op: op, Sym: sym, Err: err})
+	}
+}
@@ -0,0 +1,170 @@
+package verify
+
+import (
+	"context"
+	"testing"
+
+	"git.defalsify.org/vise.git/internal/resourcetest"
+	"git.defalsify.org/vise.git/resource"
+	"git.defalsify.org/vise.git/vm"
+)
+
+func noop(ctx context.Context, nodeSym string, input []byte) (resource.Result, error) {
+	return resource.Result{}, nil
+}
+
+func newTestResource(ctx context.Context) *resourcetest.TestResource {
+	var b []byte
+	rs := resourcetest.NewTestResource()
+
+	b = vm.NewLine(nil, vm.LOAD, []string{"foo"}, []byte{0x00}, nil)
+	b = vm.NewLine(b, vm.LOAD, []string{"bar"}, []byte{0x00}, nil)
+	b = vm.NewLine(b, vm.MOUT, []string{"one", "0"}, nil, nil)
+	b = vm.NewLine(b, vm.MOUT, []string{"two", "1"}, nil, nil)
+	b = vm.NewLine(b, vm.HALT, nil, nil, nil)
+	b = vm.NewLine(b, vm.INCMP, []string{"inky", "0"}, nil, nil)
+	b = vm.NewLine(b, vm.INCMP, []string{"pinky", "1"}, nil, nil)
+	b = vm.NewLine(b, vm.INCMP, []string{"_", "*"}, nil, nil)
+	rs.AddBytecode(ctx, "root", b)
+
+	b = vm.NewLine(nil, vm.CATCH, []string{"blinky"}, []byte{0x08}, []uint8{0x01})
+	b = vm.NewLine(b, vm.MOVE, []string{"^"}, nil, nil)
+	rs.AddBytecode(ctx, "inky", b)
+
+	b = vm.NewLine(nil, vm.MAP, []string{"foo"}, nil, nil)
+	rs.AddBytecode(ctx, "pinky", b)
+
+	b = vm.NewLine(nil, vm.HALT, nil, nil, nil)
+	rs.AddBytecode(ctx, "clyde", b)
+
+	rs.AddMenu(ctx, "one_menu", "Number one")
+	rs.AddFunc(ctx, "foo", noop)
+	rs.Lock()
+	return rs
+}
+
+func TestVerify(t *testing.T) {
+	ctx := context.Background()
+	rs := newTestResource(ctx)
+	v := NewVerifier(rs).WithSymbols([]string{"root", "inky", "pinky", "clyde"})
+	r, err := v.Verify(ctx, "root")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []struct {
+		issue Issue
+		node  string
+		sym   string
+	}{
+		{ISSUE_UNREACHABLE, "clyde", ""},
+		{ISSUE_NODE, "inky", "blinky"},
+		{ISSUE_DEADEND, "pinky", ""},
+		{ISSUE_FUNC, "root", "bar"},
+	}
+	if len(r) != len(expect) {
+		t.Fatalf("expected %d findings, got %d: %v", len(expect), len(r), r)
+	}
+	for i, x := range expect {
+		if r[i].Issue != x.issue || r[i].Node != x.node || r[i].Sym != x.sym {
+			t.Fatalf("finding %d: expected %v %s %s, got %v", i, x.issue, x.node, x.sym, r[i])
+		}
+	}
+	if r[1].Offset != 0 || r[1].Op != vm.CATCH {
+		t.Fatalf("expected location 0 CATCH, got %d %s", r[1].Offset, vm.OpcodeString[r[1].Op])
+	}
+	if r[3].Offset != 8 || r[3].Op != vm.LOAD {
+		t.Fatalf("expected location 8 LOAD, got %d %s", r[3].Offset, vm.OpcodeString[r[3].Op])
+	}
+}
+
+func TestVerifyStrictMenu(t *testing.T) {
+	ctx := context.Background()
+	rs := newTestResource(ctx)
+	v := NewVerifier(rs).WithStrictMenu()
+	r, err := v.Verify(ctx, "root")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var c int
+	for _, f := range r {
+		if f.Issue == ISSUE_MENU {
+			if f.Sym != "two" {
+				t.Fatalf("expected unresolved menu 'two', got %v", f)
+			}
+			c += 1
+		}
+	}
+	if c != 1 {
+		t.Fatalf("expected 1 menu finding, got %d: %v", c, r)
+	}
+}
+
+func TestVerifyParse(t *testing.T) {
+	ctx := context.Background()
+	rs := resourcetest.NewTestResource()
+	b := vm.NewLine(nil, vm.HALT, nil, nil, nil)
+	b = vm.NewLine(b, vm.MOVE, []string{"foo"}, nil, nil)
+	rs.AddBytecode(ctx, "root", b[:len(b)-1])
+	rs.Lock()
+
+	r, err := NewVerifier(rs).Verify(ctx, "root")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r) != 1 || r[0].Issue != ISSUE_PARSE || r[0].Offset != 2 {
+		t.Fatalf("expected parse error at offset 2, got %v", r)
+	}
+
+	r, err = NewVerifier(rs).Verify(ctx, "nonexistent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r) != 1 || r[0].Issue != ISSUE_NODE || r[0].Node != "nonexistent" {
+		t.Fatalf("expected unresolved root node, got %v", r)
+	}
+}
+
+func TestVerifyInputPattern(t *testing.T) {
+	ctx := context.Background()
+	err := vm.RegisterInputPattern("digits", "^[0-9]+$")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs := resourcetest.NewTestResource()
+	b := vm.NewLine(nil, vm.HALT, nil, nil, nil)
+	b = vm.NewLine(b, vm.INPAT, []string{"foo", "digits"}, nil, nil)
+	b = vm.NewLine(b, vm.INPAT, []string{"bar", "letters"}, nil, nil)
+	rs.AddBytecode(ctx, "root", b)
+	b = vm.NewLine(nil, vm.HALT, nil, nil, nil)
+	rs.AddBytecode(ctx, "foo", b)
+	rs.AddBytecode(ctx, "bar", b)
+	rs.Lock()
+
+	r, err := NewVerifier(rs).Verify(ctx, "root")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r) != 1 || r[0].Issue != ISSUE_PATTERN || r[0].Sym != "letters" || r[0].Op != vm.INPAT {
+		t.Fatalf("expected unresolved pattern 'letters', got %v", r)
+	}
+}
+
+func TestVerifyCall(t *testing.T) {
+	ctx := context.Background()
+	rs := resourcetest.NewTestResource()
+	b := vm.NewLine(nil, vm.CALL, []string{"sub"}, nil, nil)
+	b = vm.NewLine(b, vm.CALL, []string{"nosub"}, nil, nil)
+	b = vm.NewLine(b, vm.HALT, nil, nil, nil)
+	rs.AddBytecode(ctx, "root", b)
+	b = vm.NewLine(nil, vm.RET, nil, nil, nil)
+	rs.AddBytecode(ctx, "sub", b)
+	rs.Lock()
+
+	r, err := NewVerifier(rs).Verify(ctx, "root")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r) != 1 || r[0].Issue != ISSUE_NODE || r[0].Sym != "nosub" || r[0].Op != vm.CALL {
+		t.Fatalf("expected unresolved call 'nosub', got %v", r)
+	}
+}
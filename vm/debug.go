@@ -4,6 +4,14 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	// menu labels that can be written in assembly without quotes. Words starting with upper case letters are read as opcodes.
+	plainLabelRegex = regexp.MustCompile(`^[a-z0-9_\*\.\^\<\>][a-zA-Z0-9_]*$`)
 )
 
 type ParseHandler struct {
@@ -15,13 +23,23 @@ type ParseHandler struct {
 	Move   func(string) error
 	Halt   func() error
 	InCmp  func(string, string) error
+	InPat  func(string, string) error
 	MOut   func(string, string) error
 	MSink  func() error
 	MNext  func(string, string) error
 	MPrev  func(string, string) error
+	Jump   func(uint32) error
+	JumpIf func(uint32, uint32, bool) error
+	Call   func(string) error
+	Ret    func() error
+	FSet   func(uint32) error
+	FReset func(uint32) error
 	cur    string
 	n      int
 	w      io.Writer
+	pos    int
+	labels map[int]bool
+	sm     *SourceMap
 }
 
 func NewParseHandler() *ParseHandler {
@@ -41,10 +59,17 @@ func (ph *ParseHandler) WithDefaultHandlers() *ParseHandler {
 	ph.Move = ph.move
 	ph.Halt = ph.halt
 	ph.InCmp = ph.incmp
+	ph.InPat = ph.inpat
 	ph.MOut = ph.mout
 	ph.MSink = ph.msink
 	ph.MNext = ph.mnext
 	ph.MPrev = ph.mprev
+	ph.Jump = ph.jump
+	ph.JumpIf = ph.jumpif
+	ph.Call = ph.call
+	ph.Ret = ph.ret
+	ph.FSet = ph.fset
+	ph.FReset = ph.freset
 	return ph
 }
 
@@ -53,6 +78,24 @@ func (ph *ParseHandler) WithWriter(w io.Writer) *ParseHandler {
 	return ph
 }
 
+// WithSourceMap is a chainable function that appends the source position of each instruction as a comment to the written assembly code.
+func (ph *ParseHandler) WithSourceMap(sm *SourceMap) *ParseHandler {
+	ph.sm = sm
+	return ph
+}
+
+// append the source position of the instruction at the given offset to the current line.
+func (ph *ParseHandler) annotate(offset int) {
+	if ph.sm == nil || ph.cur == "" {
+		return
+	}
+	pos, ok := ph.sm.Lookup(offset)
+	if !ok {
+		return
+	}
+	ph.cur = strings.TrimSuffix(ph.cur, "\n") + " # " + pos.String() + "\n"
+}
+
 // TODO: output op sym
 func (ph *ParseHandler) flush() error {
 	if ph.w != nil {
@@ -118,6 +161,12 @@ func (ph *ParseHandler) incmp(sym string, sel string) error {
 	return nil
 }
 
+func (ph *ParseHandler) inpat(sym string, class string) error {
+	s := OpcodeString[INPAT]
+	ph.cur = fmt.Sprintf("%s %s %v\n", s, sym, class)
+	return nil
+}
+
 func (ph *ParseHandler) halt() error {
 	s := OpcodeString[HALT]
 	ph.cur = fmt.Sprintf("%s\n", s)
@@ -130,24 +179,86 @@ func (ph *ParseHandler) msink() error {
 	return nil
 }
 
+// quote a menu label if it cannot be written in assembly as a single symbol.
+func menuLabel(s string) string {
+	if plainLabelRegex.MatchString(s) {
+		return s
+	}
+	return strconv.Quote(s)
+}
+
 func (ph *ParseHandler) mout(sym string, sel string) error {
 	s := OpcodeString[MOUT]
-	ph.cur = fmt.Sprintf("%s %s %v\n", s, sym, sel)
+	ph.cur = fmt.Sprintf("%s %s %v\n", s, menuLabel(sym), sel)
 	return nil
 }
 
 func (ph *ParseHandler) mnext(sym string, sel string) error {
 	s := OpcodeString[MNEXT]
-	ph.cur = fmt.Sprintf("%s %s %s\n", s, sym, sel)
+	ph.cur = fmt.Sprintf("%s %s %s\n", s, menuLabel(sym), sel)
 	return nil
 }
 
 func (ph *ParseHandler) mprev(sym string, sel string) error {
 	s := OpcodeString[MPREV]
-	ph.cur = fmt.Sprintf("%s %s %s\n", s, sym, sel)
+	ph.cur = fmt.Sprintf("%s %s %s\n", s, menuLabel(sym), sel)
+	return nil
+}
+
+// label name for a jump offset relative to the end of the current instruction.
+func (ph *ParseHandler) label(offset uint32) string {
+	return fmt.Sprintf("l_%d", ph.pos+int(offset))
+}
+
+func (ph *ParseHandler) jump(offset uint32) error {
+	s := OpcodeString[JUMP]
+	ph.cur = fmt.Sprintf("%s %s\n", s, ph.label(offset))
+	return nil
+}
+
+func (ph *ParseHandler) jumpif(offset uint32, flag uint32, inv bool) error {
+	s := OpcodeString[JUMPIF]
+	vv := 0
+	if inv {
+		vv = 1
+	}
+	ph.cur = fmt.Sprintf("%s %s %v %v\n", s, ph.label(offset), flag, vv)
+	return nil
+}
+
+func (ph *ParseHandler) call(sym string) error {
+	s := OpcodeString[CALL]
+	ph.cur = fmt.Sprintf("%s %s\n", s, sym)
+	return nil
+}
+
+func (ph *ParseHandler) ret() error {
+	s := OpcodeString[RET]
+	ph.cur = fmt.Sprintf("%s\n", s)
 	return nil
 }
 
+func (ph *ParseHandler) fset(flag uint32) error {
+	s := OpcodeString[FSET]
+	ph.cur = fmt.Sprintf("%s %v\n", s, flag)
+	return nil
+}
+
+func (ph *ParseHandler) freset(flag uint32) error {
+	s := OpcodeString[FRESET]
+	ph.cur = fmt.Sprintf("%s %v\n", s, flag)
+	return nil
+}
+
+// write a label definition for the current position, if a jump targets it.
+func (ph *ParseHandler) flushLabel() error {
+	if !ph.labels[ph.pos] {
+		return nil
+	}
+	ph.cur = fmt.Sprintf("l_%d:\n", ph.pos)
+	return ph.flush()
+}
+
 // ToString verifies all instructions in bytecode and returns an assmebly code instruction for it.
 func (ph *ParseHandler) ToString(b []byte) (string, error) {
 	buf := bytes.NewBuffer(nil)
@@ -166,11 +277,23 @@ func (ph *ParseHandler) ToString(b []byte) (string, error) {
 //
 // Bytecode is consumed (and written) one instruction at a time.
 //
+// Jump targets are written as generated labels, named by the byte offset of the target in the bytecode.
+//
+// If a source map is set, the source position of each instruction is written as a comment on the same line.
+//
 // It fails on any parse error encountered before the bytecode EOF is reached.
 func (ph *ParseHandler) ParseAll(b []byte) (int, error) {
 	var s string
+	l := len(b)
+	ph.pos = 0
+	ph.labels = make(map[int]bool)
 	running := true
 	for running {
+		err := ph.flushLabel()
+		if err != nil {
+			return ph.Length(), err
+		}
+		start := ph.pos
 		op, bb, err := opSplit(b)
 		b = bb
 		if err != nil {
@@ -224,6 +347,12 @@ func (ph *ParseHandler) ParseAll(b []byte) (int, error) {
 			if err == nil {
 				err = ph.InCmp(r, v)
 			}
+		case INPAT:
+			r, v, bb, err := ParseInPat(b)
+			b = bb
+			if err == nil {
+				err = ph.InPat(r, v)
+			}
 		case HALT:
 			b, err = ParseHalt(b)
 			if err == nil {
@@ -252,16 +381,61 @@ func (ph *ParseHandler) ParseAll(b []byte) (int, error) {
 			if err == nil {
 				err = ph.MPrev(r, v)
 			}
+		case JUMP:
+			n, bb, err := ParseJump(b)
+			b = bb
+			if err == nil {
+				ph.pos = l - len(b)
+				ph.labels[ph.pos+int(n)] = true
+				err = ph.Jump(n)
+			}
+		case JUMPIF:
+			n, r, m, bb, err := ParseJumpIf(b)
+			b = bb
+			if err == nil {
+				ph.pos = l - len(b)
+				ph.labels[ph.pos+int(n)] = true
+				err = ph.JumpIf(n, r, m)
+			}
+		case CALL:
+			r, bb, err := ParseCall(b)
+			b = bb
+			if err == nil {
+				err = ph.Call(r)
+			}
+		case RET:
+			b, err = ParseRet(b)
+			if err == nil {
+				err = ph.Ret()
+			}
+		case FSET:
+			n, bb, err := ParseFSet(b)
+			b = bb
+			if err == nil {
+				err = ph.FSet(n)
+			}
+		case FRESET:
+			n, bb, err := ParseFReset(b)
+			b = bb
+			if err == nil {
+				err = ph.FReset(n)
+			}
 		}
 		if err != nil {
 			return ph.Length(), err
 		}
+		ph.annotate(start)
 		ph.flush()
+		ph.pos = l - len(b)
 
 		//rs += "\n"
 		if len(b) == 0 {
 			running = false
 		}
 	}
+	err := ph.flushLabel()
+	if err != nil {
+		return ph.Length(), err
+	}
 	return ph.Length(), nil
 }
@@ -91,6 +91,16 @@ func TestToString(t *testing.T) {
 		t.Fatalf("expected:\n\t%v\ngot:\n\t%v", expect, r)
 	}
 
+	b = NewLine(nil, INCMP, []string{"Foo", "1"}, nil, nil)
+	r, err = ph.ToString(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect = "INCMP Foo 1\n"
+	if r != expect {
+		t.Fatalf("expected:\n\t%v\ngot:\n\t%v", expect, r)
+	}
+
 	b = NewLine(nil, MNEXT, []string{"11", "nextmenu"}, nil, nil)
 	r, err = ph.ToString(b)
 	if err != nil {
@@ -134,6 +144,17 @@ func TestToString(t *testing.T) {
 	if r != expect {
 		t.Fatalf("expected:\n\t%v\ngot:\n\t%v", expect, r)
 	}
+
+	b = NewLine(nil, FSET, nil, []byte{0x08}, nil)
+	b = NewLine(b, FRESET, nil, []byte{0x09}, nil)
+	r, err = ph.ToString(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect = "FSET 8\nFRESET 9\n"
+	if r != expect {
+		t.Fatalf("expected:\n\t%v\ngot:\n\t%v", expect, r)
+	}
 }
 
 func TestToStringMultiple(t *testing.T) {
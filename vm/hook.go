@@ -0,0 +1,261 @@
+package vm
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"git.defalsify.org/vise.git/cache"
+	"git.defalsify.org/vise.git/state"
+)
+
+// Hook implementations are invoked before and after each instruction executed by the Vm.
+//
+// Hooks are called synchronously in the goroutine running the Vm. Changes made by a hook to the state or cache of the event take effect from the next instruction.
+type Hook interface {
+	// Before is called after the instruction has been decoded, and before it is executed.
+	Before(ctx context.Context, ev HookEvent)
+	// After is called after the instruction has been executed, before any error is handled.
+	After(ctx context.Context, ev HookEvent)
+}
+
+// CacheChange describes a single change to the cache made by an instruction.
+type CacheChange struct {
+	// Symbol whose content changed. Empty if the cache was reset.
+	Sym string
+	// New content of the symbol.
+	Value string
+	// Set if the content replaced a previously loaded value.
+	Update bool
+}
+
+// HookEvent describes an instruction executed by the Vm.
+type HookEvent struct {
+	// Sequence number of the instruction within the current Run, starting at 1.
+	Step uint32
+	// Opcode of the instruction.
+	Op Opcode
+	// Decoded arguments of the instruction, in the order used by the assembly language.
+	Args []string
+	// Node the instruction was executed in.
+	Node string
+	// Byte offset of the instruction in the bytecode of the node, or -1 if not known.
+	Offset int
+	// Position of the instruction in the assembly source, if a source map is available for the node.
+	Source *SourcePos
+	// Signal flags before execution.
+	FlagsBefore []byte
+	// Signal flags after execution. Nil in Before.
+	FlagsAfter []byte
+	// Cache changes made by the instruction. Nil in Before.
+	Changes []CacheChange
+	// Error returned by the instruction, if any. Always nil in Before.
+	Err error
+	// State of the Vm.
+	State *state.State
+	// Cache of the Vm.
+	Memory cache.Memory
+}
+
+// WithHook is a chainable function that adds a hook to be invoked on each instruction.
+//
+// Hooks are invoked in the order they were added.
+func (vmi *Vm) WithHook(h Hook) *Vm {
+	vmi.hooks = append(vmi.hooks, h)
+	return vmi
+}
+
+// create the event for an instruction about to be executed, where ib is the bytecode from the start of the instruction, and b is the argument portion of the instruction.
+func (vmi *Vm) hookBefore(ctx context.Context, step uint32, op Opcode, ib []byte, b []byte) HookEvent {
+	node, _ := vmi.st.Where()
+	args, err := decodeArgs(op, b)
+	if err != nil {
+		logg.DebugCtxf(ctx, "hook could not decode arguments", "op", op, "err", err)
+	}
+	ev := HookEvent{
+		Step:        step,
+		Op:          op,
+		Args:        args,
+		Node:        node,
+		Offset:      vmi.codeOffset(ctx, node, ib),
+		FlagsBefore: append([]byte{}, vmi.st.Flags...),
+		State:       vmi.st,
+		Memory:      vmi.ca,
+	}
+	pos, ok := vmi.sourcePos(ctx, ev.Offset)
+	if ok {
+		ev.Source = &pos
+	}
+	vmi.changes = nil
+	for _, h := range vmi.hooks {
+		h.Before(ctx, ev)
+	}
+	return ev
+}
+
+// complete the event for an executed instruction and pass it to the hooks.
+func (vmi *Vm) hookAfter(ctx context.Context, ev HookEvent, err error) {
+	ev.FlagsAfter = append([]byte{}, vmi.st.Flags...)
+	ev.Changes = vmi.changes
+	if ev.Changes == nil {
+		ev.Changes = []CacheChange{}
+	}
+	ev.Err = err
+	vmi.changes = nil
+	for _, h := range vmi.hooks {
+		h.After(ctx, ev)
+	}
+}
+
+// record a cache change for the hooks.
+func (vmi *Vm) cacheChange(sym string, val string, update bool) {
+	if len(vmi.hooks) == 0 {
+		return
+	}
+	vmi.changes = append(vmi.changes, CacheChange{
+		Sym:    sym,
+		Value:  val,
+		Update: update,
+	})
+}
+
+// decode the arguments of a single instruction, where b is the argument portion of the instruction.
+func decodeArgs(op Opcode, b []byte) ([]string, error) {
+	var err error
+	var sym string
+	var sel string
+	var n uint32
+	var m uint32
+	var inv bool
+	switch op {
+	case CATCH:
+		sym, n, inv, _, err = ParseCatch(b)
+		return []string{sym, fmt.Sprintf("%v", n), boolArg(inv)}, err
+	case CROAK:
+		n, inv, _, err = ParseCroak(b)
+		return []string{fmt.Sprintf("%v", n), boolArg(inv)}, err
+	case LOAD:
+		sym, n, _, err = ParseLoad(b)
+		return []string{sym, fmt.Sprintf("%v", n)}, err
+	case RELOAD:
+		sym, _, err = ParseReload(b)
+	case MAP:
+		sym, _, err = ParseMap(b)
+	case MOVE:
+		sym, _, err = ParseMove(b)
+	case CALL:
+		sym, _, err = ParseCall(b)
+	case INCMP:
+		sym, sel, _, err = ParseInCmp(b)
+		return []string{sym, sel}, err
+	case INPAT:
+		sym, sel, _, err = ParseInPat(b)
+		return []string{sym, sel}, err
+	case MOUT:
+		sym, sel, _, err = ParseMOut(b)
+		return []string{sym, sel}, err
+	case MNEXT:
+		sym, sel, _, err = ParseMNext(b)
+		return []string{sym, sel}, err
+	case MPREV:
+		sym, sel, _, err = ParseMPrev(b)
+		return []string{sym, sel}, err
+	case JUMP:
+		n, _, err = ParseJump(b)
+		return []string{fmt.Sprintf("%v", n)}, err
+	case JUMPIF:
+		n, m, inv, _, err = ParseJumpIf(b)
+		return []string{fmt.Sprintf("%v", n), fmt.Sprintf("%v", m), boolArg(inv)}, err
+	case FSET:
+		n, _, err = ParseFSet(b)
+		return []string{fmt.Sprintf("%v", n)}, err
+	case FRESET:
+		n, _, err = ParseFReset(b)
+		return []string{fmt.Sprintf("%v", n)}, err
+	case HALT, MSINK, RET:
+		return []string{}, nil
+	default:
+		return nil, fmt.Errorf("unknown opcode: %v", op)
+	}
+	return []string{sym}, err
+}
+
+// render a boolean instruction argument as in the assembly language.
+func boolArg(v bool) string {
+	if v {
+		return "1"
+	}
+	return "0"
+}
+
+// TraceHook is a Hook implementation that writes a line for each executed instruction.
+type TraceHook struct {
+	pfx string
+	w   io.Writer
+}
+
+// NewTraceHook creates a new TraceHook writing to w, or to standard error if w is nil.
+func NewTraceHook(w io.Writer) *TraceHook {
+	if w == nil {
+		w = os.Stderr
+	}
+	return &TraceHook{
+		w:   w,
+		pfx: "TRACE>",
+	}
+}
+
+// Before implements the Hook interface.
+func (th *TraceHook) Before(ctx context.Context, ev HookEvent) {
+}
+
+// After implements the Hook interface.
+//
+// The line format is "<step> <node> <opcode> <args...>", followed by the flags that changed, the cache symbols that changed, the source position and the error, if any.
+func (th *TraceHook) After(ctx context.Context, ev HookEvent) {
+	s := fmt.Sprintf("%s %d %s %s", th.pfx, ev.Step, ev.Node, OpcodeString[ev.Op])
+	if len(ev.Args) > 0 {
+		s += " " + strings.Join(ev.Args, " ")
+	}
+	for _, v := range changedFlags(ev.FlagsBefore, ev.FlagsAfter) {
+		if ev.FlagsAfter[v/8]&(1<<(v%8)) > 0 {
+			s += fmt.Sprintf(" +%d", v)
+		} else {
+			s += fmt.Sprintf(" -%d", v)
+		}
+	}
+	for _, v := range ev.Changes {
+		if v.Sym == "" {
+			s += " cache:reset"
+		} else {
+			s += " cache:" + v.Sym
+		}
+	}
+	if ev.Source != nil {
+		s += " @" + ev.Source.String()
+	}
+	if ev.Err != nil {
+		s += " error: " + ev.Err.Error()
+	}
+	fmt.Fprintln(th.w, s)
+}
+
+// indices of flags that differ between two flag sets.
+func changedFlags(before []byte, after []byte) []uint32 {
+	var r []uint32
+	for i := 0; i < len(after); i++ {
+		var b byte
+		if i < len(before) {
+			b = before[i]
+		}
+		d := b ^ after[i]
+		for j := 0; j < 8; j++ {
+			if d&(1<<j) > 0 {
+				r = append(r, uint32(i*8+j))
+			}
+		}
+	}
+	return r
+}
@@ -0,0 +1,135 @@
+package vm
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"git.defalsify.org/vise.git/cache"
+	"git.defalsify.org/vise.git/state"
+)
+
+type recordHook struct {
+	before []HookEvent
+	after  []HookEvent
+}
+
+func (h *recordHook) Before(ctx context.Context, ev HookEvent) {
+	h.before = append(h.before, ev)
+}
+
+func (h *recordHook) After(ctx context.Context, ev HookEvent) {
+	h.after = append(h.after, ev)
+}
+
+func TestHook(t *testing.T) {
+	st := state.NewState(5)
+	rs := newTestResource(st)
+	rs.Lock()
+	ca := cache.NewCache()
+	h := &recordHook{}
+	vm := NewVm(st, &rs, ca, nil).WithHook(h)
+	ctx := context.Background()
+	st.Down("bar")
+	ca.Push()
+
+	b := NewLine(nil, LOAD, []string{"two"}, []byte{0x0a}, nil)
+	b = NewLine(b, FSET, nil, []byte{state.FLAG_USERSTART}, nil)
+	b = NewLine(b, HALT, nil, nil, nil)
+	_, err := vm.Run(ctx, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(h.before) != 3 || len(h.after) != 3 {
+		t.Fatalf("expected 3 events each, got %d before and %d after", len(h.before), len(h.after))
+	}
+	expectOps := []Opcode{LOAD, FSET, HALT}
+	for i, ev := range h.after {
+		if ev.Op != expectOps[i] {
+			t.Fatalf("event %d: expected op %v, got %v", i, expectOps[i], ev.Op)
+		}
+		if ev.Step != uint32(i+1) {
+			t.Fatalf("event %d: expected step %d, got %d", i, i+1, ev.Step)
+		}
+		if ev.Node != "bar" {
+			t.Fatalf("event %d: expected node bar, got %s", i, ev.Node)
+		}
+		if h.before[i].FlagsAfter != nil || h.before[i].Changes != nil {
+			t.Fatalf("event %d: before event has results", i)
+		}
+	}
+
+	ev := h.after[0]
+	if !reflect.DeepEqual(ev.Args, []string{"two", "10"}) {
+		t.Fatalf("unexpected args: %v", ev.Args)
+	}
+	expectChanges := []CacheChange{{Sym: "two", Value: "two"}}
+	if !reflect.DeepEqual(ev.Changes, expectChanges) {
+		t.Fatalf("expected changes %v, got %v", expectChanges, ev.Changes)
+	}
+
+	ev = h.after[1]
+	if !reflect.DeepEqual(ev.Args, []string{"8"}) {
+		t.Fatalf("unexpected args: %v", ev.Args)
+	}
+	if len(ev.Changes) != 0 {
+		t.Fatalf("expected no changes, got %v", ev.Changes)
+	}
+	if !reflect.DeepEqual(changedFlags(ev.FlagsBefore, ev.FlagsAfter), []uint32{state.FLAG_USERSTART}) {
+		t.Fatalf("expected flag %d changed, before %v after %v", state.FLAG_USERSTART, ev.FlagsBefore, ev.FlagsAfter)
+	}
+
+	ev = h.after[2]
+	if !reflect.DeepEqual(changedFlags(ev.FlagsBefore, ev.FlagsAfter), []uint32{state.FLAG_WAIT}) {
+		t.Fatalf("expected wait flag changed, before %v after %v", ev.FlagsBefore, ev.FlagsAfter)
+	}
+}
+
+func TestHookError(t *testing.T) {
+	st := state.NewState(5)
+	rs := newTestResource(st)
+	rs.Lock()
+	ca := cache.NewCache()
+	h := &recordHook{}
+	vm := NewVm(st, &rs, ca, nil).WithHook(h)
+	ctx := context.Background()
+	st.Down("bar")
+
+	b := NewLine(nil, MOVE, []string{"nonexistent"}, nil, nil)
+	_, err := vm.Run(ctx, b)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if len(h.after) != 1 {
+		t.Fatalf("expected 1 event, got %d", len(h.after))
+	}
+	if h.after[0].Err == nil {
+		t.Fatal("expected error in event")
+	}
+}
+
+func TestTraceHook(t *testing.T) {
+	st := state.NewState(5)
+	rs := newTestResource(st)
+	rs.Lock()
+	ca := cache.NewCache()
+	w := bytes.NewBuffer(nil)
+	vm := NewVm(st, &rs, ca, nil).WithHook(NewTraceHook(w))
+	ctx := context.Background()
+	st.Down("bar")
+	ca.Push()
+
+	b := NewLine(nil, LOAD, []string{"two"}, []byte{0x0a}, nil)
+	b = NewLine(b, HALT, nil, nil, nil)
+	_, err := vm.Run(ctx, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := fmt.Sprintf("TRACE> 1 bar LOAD two 10 cache:two\nTRACE> 2 bar HALT +%d\n", state.FLAG_WAIT)
+	if w.String() != expect {
+		t.Fatalf("expected trace:\n%s\ngot:\n%s", expect, w.String())
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"context"
 	"fmt"
 	"regexp"
+	"strconv"
+	"sync"
 
 	"git.defalsify.org/vise.git/cache"
 	"git.defalsify.org/vise.git/state"
@@ -21,6 +23,8 @@ var (
 
 var (
 	preInputRegexStr = make(map[int]*regexp.Regexp)
+	inputPattern     = make(map[string]*regexp.Regexp)
+	inputPatternLock sync.RWMutex
 )
 
 // InvalidInputError indicates client input that was unhandled by the bytecode (INCMP fallthrough)
@@ -50,6 +54,47 @@ func RegisterInputValidator(k int, v string) error {
 	return err
 }
 
+// RegisterInputPattern registers a regular expression under a name, which may be used as the input class of an INPAT instruction.
+//
+// Patterns are registered for the whole process. Registering the same expression under the same name again has no effect, so that it is safe to do for every engine instance. Registering a different expression under an existing name fails.
+func RegisterInputPattern(name string, v string) error {
+	inputPatternLock.Lock()
+	defer inputPatternLock.Unlock()
+	re, ok := inputPattern[name]
+	if ok {
+		if re.String() == v {
+			return nil
+		}
+		return fmt.Errorf("input pattern with name '%s' already registered", name)
+	}
+	re, err := regexp.Compile(v)
+	if err != nil {
+		return err
+	}
+	inputPattern[name] = re
+	return nil
+}
+
+// InputPattern returns the regular expression for the given input class.
+//
+// The input class is either the name of a pattern registered with RegisterInputPattern, or the numeric key of an input validator registered with RegisterInputValidator.
+func InputPattern(name string) (*regexp.Regexp, error) {
+	inputPatternLock.RLock()
+	re, ok := inputPattern[name]
+	inputPatternLock.RUnlock()
+	if ok {
+		return re, nil
+	}
+	k, err := strconv.Atoi(name)
+	if err == nil {
+		re, ok = preInputRegexStr[k]
+		if ok {
+			return re, nil
+		}
+	}
+	return nil, fmt.Errorf("no input pattern registered for class '%s'", name)
+}
+
 // CheckInput validates the given byte string as client input.
 func ValidInput(input []byte) (int, error) {
 	if inputRegex.Match(input) {
@@ -176,3 +176,47 @@ func TestVmCustomInputValid(t *testing.T) {
 		t.Fatalf("expected 42, got %d", v)
 	}
 }
+
+func TestInputPatternLookup(t *testing.T) {
+	err := RegisterInputPattern("amount", "^[0-9]+(\\.[0-9]{1,2})?$")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = RegisterInputPattern("amount", "^[0-9]+(\\.[0-9]{1,2})?$")
+	if err != nil {
+		t.Fatalf("expected identical pattern to be accepted again, got %v", err)
+	}
+	err = RegisterInputPattern("amount", "^[0-9]+$")
+	if err == nil {
+		t.Fatal("expected error for duplicate pattern")
+	}
+	err = RegisterInputPattern("broken", "[0-9")
+	if err == nil {
+		t.Fatal("expected error for invalid pattern")
+	}
+
+	re, err := InputPattern("amount")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !re.MatchString("42.13") || re.MatchString("42.") {
+		t.Fatalf("unexpected match result for pattern %v", re)
+	}
+
+	err = RegisterInputValidator(13, "^[a-z]+$")
+	if err != nil {
+		t.Fatal(err)
+	}
+	re, err = InputPattern("13")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !re.MatchString("foo") {
+		t.Fatalf("expected match for validator %v", re)
+	}
+
+	_, err = InputPattern("14")
+	if err == nil {
+		t.Fatal("expected error for unregistered input class")
+	}
+}
@@ -0,0 +1,190 @@
+package vm
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"git.defalsify.org/vise.git/resource"
+)
+
+const (
+	// Status of an ExternalCodeError when the external function did not return within its time limit.
+	STATUS_TIMEOUT = -1
+)
+
+// LoadPolicy defines the time limit and retries for calls to the external function of a LOAD or RELOAD symbol.
+type LoadPolicy struct {
+	// Timeout is the maximum duration of a single call to the external function. If set to 0, no limit is imposed.
+	Timeout time.Duration
+	// Retries is the number of additional calls made if the external function fails or times out.
+	Retries int
+}
+
+// WithLoadPolicy is a chainable function that sets the load policy for all symbols that have no policy of their own.
+func (vmi *Vm) WithLoadPolicy(p LoadPolicy) *Vm {
+	vmi.loadPolicy = p
+	return vmi
+}
+
+// AddLoadPolicy sets the load policy for a single LOAD or RELOAD symbol.
+func (vmi *Vm) AddLoadPolicy(sym string, p LoadPolicy) {
+	if vmi.loadPolicies == nil {
+		vmi.loadPolicies = make(map[string]LoadPolicy)
+	}
+	vmi.loadPolicies[sym] = p
+}
+
+// SetDeadline sets the time after which no more external functions will be called, and running calls are abandoned.
+//
+// Loads that fail on the deadline set FLAG_LOADFAIL as any other load failure, so that the _catch node can be rendered in time.
+//
+// A zero time value removes the deadline.
+func (vmi *Vm) SetDeadline(t time.Time) {
+	vmi.deadline = t
+}
+
+// WithConcurrentLoad is a chainable function that enables concurrent calls to the external functions of consecutive LOAD instructions.
+//
+// When a LOAD is executed, the external functions of it and all LOAD instructions immediately following it are called concurrently. The results are still applied to the cache one instruction at a time in instruction order, with the same error and flag semantics as sequential execution. Results of instructions that are not reached, for example because a preceding LOAD failed, are discarded.
+//
+// If an applied result changes the language, remaining results are discarded and the external functions are called again with the new language.
+func (vmi *Vm) WithConcurrentLoad() *Vm {
+	vmi.concurrentLoad = true
+	return vmi
+}
+
+// result of an external function call made ahead of its LOAD instruction.
+type prefetchResult struct {
+	r   resource.Result
+	err error
+}
+
+// symbols of a run of consecutive LOAD instructions, where b is the argument portion of the first LOAD.
+func loadRun(b []byte) []string {
+	var r []string
+	for {
+		sym, _, bb, err := ParseLoad(b)
+		if err != nil {
+			return r
+		}
+		r = append(r, sym)
+		op, bb, err := opSplit(bb)
+		if err != nil || op != LOAD {
+			return r
+		}
+		b = bb
+	}
+}
+
+// call the external functions of a run of LOAD instructions concurrently.
+//
+// Symbols already in cache, and symbols for which no function can be resolved, are left to be handled by the instruction itself.
+func (vmi *Vm) prefetch(ctx context.Context, b []byte) {
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+
+	vmi.prefetched = make(map[string]prefetchResult)
+	syms := loadRun(b)
+	if len(syms) < 2 {
+		return
+	}
+	input, _ := vmi.st.GetInput()
+	seen := make(map[string]bool)
+	for _, sym := range syms {
+		if seen[sym] {
+			continue
+		}
+		seen[sym] = true
+		_, err := vmi.ca.Get(sym)
+		if err == nil {
+			continue
+		}
+		fn, err := vmi.rs.FuncFor(ctx, sym)
+		if err != nil || fn == nil {
+			continue
+		}
+		wg.Add(1)
+		go func(sym string, fn resource.EntryFunc) {
+			defer wg.Done()
+			r, err := vmi.callFunc(ctx, fn, sym, input)
+			mu.Lock()
+			vmi.prefetched[sym] = prefetchResult{r: r, err: err}
+			mu.Unlock()
+		}(sym, fn)
+	}
+	wg.Wait()
+	logg.DebugCtxf(ctx, "prefetched loads", "syms", syms, "count", len(vmi.prefetched))
+}
+
+// retrieve the load policy for a symbol.
+func (vmi *Vm) policyFor(sym string) LoadPolicy {
+	p, ok := vmi.loadPolicies[sym]
+	if ok {
+		return p
+	}
+	return vmi.loadPolicy
+}
+
+// call an external function according to the load policy of the symbol.
+//
+// Timeouts are reported with status STATUS_TIMEOUT.
+func (vmi *Vm) callFunc(ctx context.Context, fn resource.EntryFunc, key string, input []byte) (resource.Result, error) {
+	var r resource.Result
+	var err error
+	p := vmi.policyFor(key)
+	for i := 0; i <= p.Retries; i++ {
+		if i > 0 {
+			logg.WarnCtxf(ctx, "retry external function", "key", key, "attempt", i, "error", err)
+		}
+		r, err = vmi.callFuncOnce(ctx, fn, key, input, p.Timeout)
+		if err == nil {
+			return r, nil
+		}
+		if !vmi.deadline.IsZero() && !time.Now().Before(vmi.deadline) {
+			break
+		}
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		r.Status = STATUS_TIMEOUT
+	}
+	return r, err
+}
+
+// call an external function once, abandoning it if it has not returned within the timeout or the deadline.
+func (vmi *Vm) callFuncOnce(ctx context.Context, fn resource.EntryFunc, key string, input []byte, timeout time.Duration) (resource.Result, error) {
+	var cancel context.CancelFunc
+	if !vmi.deadline.IsZero() {
+		ctx, cancel = context.WithDeadline(ctx, vmi.deadline)
+		defer cancel()
+	}
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	if ctx.Done() == nil {
+		return fn(ctx, key, input)
+	}
+	err := ctx.Err()
+	if err != nil {
+		return resource.Result{}, err
+	}
+
+	type result struct {
+		r   resource.Result
+		err error
+	}
+	c := make(chan result, 1)
+	go func() {
+		r, err := fn(ctx, key, input)
+		c <- result{r, err}
+	}()
+	select {
+	case v := <-c:
+		return v.r, v.err
+	case <-ctx.Done():
+		logg.ErrorCtxf(ctx, "external function abandoned", "key", key, "error", ctx.Err())
+		return resource.Result{}, ctx.Err()
+	}
+}
@@ -0,0 +1,222 @@
+package vm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"git.defalsify.org/vise.git/cache"
+	"git.defalsify.org/vise.git/internal/resourcetest"
+	"git.defalsify.org/vise.git/resource"
+	"git.defalsify.org/vise.git/state"
+)
+
+type flakyFunc struct {
+	fails int
+	calls int
+}
+
+func (f *flakyFunc) get(ctx context.Context, sym string, input []byte) (resource.Result, error) {
+	f.calls += 1
+	if f.calls <= f.fails {
+		return resource.Result{}, fmt.Errorf("fail %d", f.calls)
+	}
+	return resource.Result{Content: "foo"}, nil
+}
+
+func getSlow(ctx context.Context, sym string, input []byte) (resource.Result, error) {
+	time.Sleep(time.Millisecond * 100)
+	return resource.Result{Content: "slow"}, nil
+}
+
+func newLoadTestVm(st *state.State, fn resource.EntryFunc) *Vm {
+	ctx := context.Background()
+	rs := resourcetest.NewTestResource()
+	rs.AddFunc(ctx, "foo", fn)
+	rs.AddFunc(ctx, "slow", getSlow)
+	rs.AddBytecode(ctx, "_catch", NewLine(nil, HALT, nil, nil, nil))
+	rs.Lock()
+	return NewVm(st, rs, cache.NewCache(), nil)
+}
+
+func TestLoadTimeout(t *testing.T) {
+	var e *ExternalCodeError
+	ctx := context.Background()
+	st := state.NewState(0)
+	st.Down("root")
+	vm := newLoadTestVm(st, getSlow)
+	vm.AddLoadPolicy("slow", LoadPolicy{Timeout: time.Millisecond * 10})
+
+	_, err := vm.refresh("slow", vm.rs, ctx)
+	if !errors.As(err, &e) {
+		t.Fatalf("expected external code error, got %v", err)
+	}
+	if e.Status() != STATUS_TIMEOUT {
+		t.Fatalf("expected timeout status, got %d", e.Status())
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+	if !st.MatchFlag(state.FLAG_LOADFAIL, true) {
+		t.Fatal("expected loadfail flag set")
+	}
+
+	r, err := vm.refresh("foo", vm.rs, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r != "slow" {
+		t.Fatalf("expected 'slow', got '%s'", r)
+	}
+}
+
+func TestLoadRetry(t *testing.T) {
+	ctx := context.Background()
+	st := state.NewState(0)
+	st.Down("root")
+	f := &flakyFunc{fails: 2}
+	vm := newLoadTestVm(st, f.get).WithLoadPolicy(LoadPolicy{Retries: 1})
+	_, err := vm.refresh("foo", vm.rs, ctx)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if f.calls != 2 {
+		t.Fatalf("expected 2 calls, got %d", f.calls)
+	}
+
+	f = &flakyFunc{fails: 2}
+	vm = newLoadTestVm(st, f.get).WithLoadPolicy(LoadPolicy{Retries: 2})
+	r, err := vm.refresh("foo", vm.rs, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r != "foo" {
+		t.Fatalf("expected 'foo', got '%s'", r)
+	}
+}
+
+func TestLoadDeadline(t *testing.T) {
+	ctx := context.Background()
+	st := state.NewState(0)
+	st.Down("root")
+	f := &flakyFunc{}
+	vm := newLoadTestVm(st, f.get).WithLoadPolicy(LoadPolicy{Retries: 3})
+	vm.SetDeadline(time.Now())
+
+	b := NewLine(nil, LOAD, []string{"foo"}, []byte{0x0a}, nil)
+	b = NewLine(b, HALT, nil, nil, nil)
+	_, err := vm.Run(ctx, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.calls != 0 {
+		t.Fatalf("expected no calls after deadline, got %d", f.calls)
+	}
+	location, _ := st.Where()
+	if location != "_catch" {
+		t.Fatalf("expected _catch, got %s", location)
+	}
+
+	vm.SetDeadline(time.Time{})
+	_, err = vm.refresh("foo", vm.rs, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+type barrierFunc struct {
+	mu    sync.Mutex
+	n     int
+	c     chan struct{}
+	fails string
+}
+
+func newBarrierFunc(n int) *barrierFunc {
+	return &barrierFunc{
+		n: n,
+		c: make(chan struct{}),
+	}
+}
+
+// returns only when n calls are in progress at the same time.
+func (f *barrierFunc) get(ctx context.Context, sym string, input []byte) (resource.Result, error) {
+	f.mu.Lock()
+	f.n -= 1
+	if f.n == 0 {
+		close(f.c)
+	}
+	f.mu.Unlock()
+	select {
+	case <-f.c:
+	case <-time.After(time.Second):
+		return resource.Result{}, fmt.Errorf("not called concurrently")
+	}
+	if sym == f.fails {
+		return resource.Result{}, fmt.Errorf("fail %s", sym)
+	}
+	return resource.Result{
+		Content: sym,
+		FlagSet: []uint32{state.FLAG_USERSTART},
+	}, nil
+}
+
+func TestLoadConcurrent(t *testing.T) {
+	ctx := context.Background()
+	st := state.NewState(1)
+	st.Down("root")
+	f := newBarrierFunc(3)
+	rs := resourcetest.NewTestResource()
+	rs.AddFunc(ctx, "one", f.get)
+	rs.AddFunc(ctx, "two", f.get)
+	rs.AddFunc(ctx, "three", f.get)
+	rs.AddBytecode(ctx, "_catch", NewLine(nil, HALT, nil, nil, nil))
+	rs.Lock()
+	ca := cache.NewCache()
+	vm := NewVm(st, rs, ca, nil).WithConcurrentLoad()
+
+	b := NewLine(nil, LOAD, []string{"one"}, []byte{0x0a}, nil)
+	b = NewLine(b, LOAD, []string{"two"}, []byte{0x0a}, nil)
+	b = NewLine(b, LOAD, []string{"three"}, []byte{0x0a}, nil)
+	b = NewLine(b, HALT, nil, nil, nil)
+	_, err := vm.Run(ctx, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range []string{"one", "two", "three"} {
+		v, err := ca.Get(k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v != k {
+			t.Fatalf("expected '%s', got '%s'", k, v)
+		}
+	}
+	if !st.GetFlag(state.FLAG_USERSTART) {
+		t.Fatal("expected flag set")
+	}
+
+	st = state.NewState(1)
+	st.Down("root")
+	f = newBarrierFunc(3)
+	f.fails = "two"
+	rs.AddFunc(ctx, "one", f.get)
+	rs.AddFunc(ctx, "two", f.get)
+	rs.AddFunc(ctx, "three", f.get)
+	ca = cache.NewCache()
+	vm = NewVm(st, rs, ca, nil).WithConcurrentLoad()
+	_, err = vm.Run(ctx, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	location, _ := st.Where()
+	if location != "_catch" {
+		t.Fatalf("expected _catch, got %s", location)
+	}
+	_, err = ca.Get("three")
+	if err == nil {
+		t.Fatal("expected result after failed load discarded")
+	}
+}
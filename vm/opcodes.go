@@ -19,7 +19,14 @@ const (
 	MOUT   = 10
 	MNEXT  = 11
 	MPREV  = 12
-	_MAX   = 12
+	JUMP   = 13
+	JUMPIF = 14
+	FSET   = 15
+	FRESET = 16
+	INPAT  = 17
+	CALL   = 18
+	RET    = 19
+	_MAX   = 19
 )
 
 var (
@@ -37,6 +44,13 @@ var (
 		MOUT:   "MOUT",
 		MNEXT:  "MNEXT",
 		MPREV:  "MPREV",
+		JUMP:   "JUMP",
+		JUMPIF: "JUMPIF",
+		FSET:   "FSET",
+		FRESET: "FRESET",
+		INPAT:  "INPAT",
+		CALL:   "CALL",
+		RET:    "RET",
 	}
 
 	OpcodeIndex = map[string]Opcode{
@@ -53,5 +67,12 @@ var (
 		"MOUT":   MOUT,
 		"MNEXT":  MNEXT,
 		"MPREV":  MPREV,
+		"JUMP":   JUMP,
+		"JUMPIF": JUMPIF,
+		"FSET":   FSET,
+		"FRESET": FRESET,
+		"INPAT":  INPAT,
+		"CALL":   CALL,
+		"RET":    RET,
 	}
 )
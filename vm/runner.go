@@ -4,6 +4,8 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"strings"
+	"time"
 
 	"git.defalsify.org/vise.git/cache"
 	"git.defalsify.org/vise.git/render"
@@ -31,23 +33,72 @@ func (e *ExternalCodeError) WithCode(code int) *ExternalCodeError {
 	return e
 }
 
+// Status returns the application defined status code of the error, or STATUS_TIMEOUT if the external function timed out.
+func (e ExternalCodeError) Status() int {
+	return e.code
+}
+
+// Unwrap returns the error returned by the external function.
+func (e ExternalCodeError) Unwrap() error {
+	return e.err
+}
+
 // Error implements the Error interface.
 func (e ExternalCodeError) Error() string {
 	logg.Errorf("external code error", "err", e.err)
 	return fmt.Sprintf("error %v:%v", e.sym, e.code)
 }
 
+// LimitError indicates that a single Run exceeded the instruction or navigation move limit of the Vm.
+type LimitError struct {
+	what  string
+	limit uint32
+	path  []string
+}
+
+// NewLimitError creates a new LimitError for the given kind of limit and execution path.
+func NewLimitError(what string, limit uint32, path []string) *LimitError {
+	return &LimitError{
+		what:  what,
+		limit: limit,
+		path:  append([]string{}, path...),
+	}
+}
+
+// Path returns the execution path at the time the limit was exceeded.
+func (e LimitError) Path() []string {
+	return e.path
+}
+
+// Error implements the Error interface.
+func (e LimitError) Error() string {
+	return fmt.Sprintf("%s limit %d exceeded at path %s", e.what, e.limit, strings.Join(e.path, "/"))
+}
+
 // Vm holds sub-components mutated by the vm execution.
 // TODO: Renderer should be passed to avoid proxy methods not strictly related to vm operation
 type Vm struct {
-	st            *state.State      // Navigation and error states.
-	rs            resource.Resource // Retrieves content, code, and templates for symbols.
-	ca            cache.Memory      // Loaded content.
-	mn            *render.Menu      // Menu component of page.
-	sizer         *render.Sizer     // Apply size constraints to output.
-	pg            *render.Page      // Render outputs with menues to size constraints
-	menuSeparator string            // Passed to Menu.WithSeparator if not empty
-	last          string            // Last failed LOAD/RELOAD attempt
+	st             *state.State              // Navigation and error states.
+	rs             resource.Resource         // Retrieves content, code, and templates for symbols.
+	ca             cache.Memory              // Loaded content.
+	mn             *render.Menu              // Menu component of page.
+	sizer          *render.Sizer             // Apply size constraints to output.
+	pg             *render.Page              // Render outputs with menues to size constraints
+	menuSeparator  string                    // Passed to Menu.WithSeparator if not empty
+	last           string                    // Last failed LOAD/RELOAD attempt
+	stepLimit      uint32                    // Maximum number of instructions executed in a single Run, or 0 for no limit
+	moveLimit      uint32                    // Maximum number of navigation moves in a single Run, or 0 for no limit
+	loadPolicy     LoadPolicy                // Load policy for symbols without a policy of their own
+	loadPolicies   map[string]LoadPolicy     // Load policies for individual symbols
+	deadline       time.Time                 // Time after which external functions are no longer called
+	concurrentLoad bool                      // Call external functions of consecutive LOAD instructions concurrently
+	prefetched     map[string]prefetchResult // Results of external functions called ahead of their LOAD instruction
+	hooks          []Hook                    // Invoked before and after each instruction
+	changes        []CacheChange             // Cache changes made by the current instruction, if hooks are set
+	srcNode        string                    // Node of the bytecode and source map used for source positions
+	srcCode        []byte                    // Bytecode of srcNode
+	srcMap         *SourceMap                // Source map of srcNode, if available
+	srcLoaded      bool                      // Set if retrieval of the source map of srcNode has been attempted
 }
 
 // NewVm creates a new Vm.
@@ -79,6 +130,22 @@ func (vmi *Vm) WithMenuSeparator(sep string) *Vm {
 	return vmi
 }
 
+// WithStepLimit is a chainable function that sets the maximum number of instructions that may be executed in a single Run.
+//
+// If set to 0, no limit is imposed.
+func (vmi *Vm) WithStepLimit(limit uint32) *Vm {
+	vmi.stepLimit = limit
+	return vmi
+}
+
+// WithMoveLimit is a chainable function that sets the maximum number of instructions that may change the navigation state in a single Run.
+//
+// If set to 0, no limit is imposed.
+func (vmi *Vm) WithMoveLimit(limit uint32) *Vm {
+	vmi.moveLimit = limit
+	return vmi
+}
+
 // Reset re-initializes sub-components for output rendering.
 func (vmi *Vm) Reset() {
 	vmi.mn = render.NewMenu()
@@ -116,11 +183,26 @@ func Rewind(sym string, st *state.State, ca cache.Memory) (string, error) {
 // Each step may update the state.
 //
 // On error, the remaining instructions will be returned. State will not be rolled back.
+//
+// If the step or move limit of the Vm is exceeded, a LimitError is returned.
+//
+// Hooks added with WithHook are invoked before and after each instruction.
+//
+// If the resource provides a source map for the node, errors from instructions are returned as SourceError.
 func (vm *Vm) Run(ctx context.Context, b []byte) ([]byte, error) {
+	var steps uint32
+	var moves uint32
 	logg.Tracef("new vm run")
 	running := true
 	vm.last = ""
+	vm.prefetched = nil
 	for running {
+		steps += 1
+		if vm.stepLimit > 0 && steps > vm.stepLimit {
+			logg.ErrorCtxf(ctx, "step limit exceeded", "limit", vm.stepLimit, "state", vm.st)
+			return b, NewLimitError("step", vm.stepLimit, vm.st.ExecPath)
+		}
+
 		r := vm.st.MatchFlag(state.FLAG_TERMINATE, true)
 		if r {
 			logg.InfoCtxf(ctx, "terminate set! bailing")
@@ -144,6 +226,8 @@ func (vm *Vm) Run(ctx context.Context, b []byte) ([]byte, error) {
 		}
 
 		_ = vm.st.SetFlag(state.FLAG_DIRTY)
+		node, _ := vm.st.Where()
+		ib := b
 		op, bb, err := opSplit(b)
 		if err != nil {
 			return b, err
@@ -151,6 +235,16 @@ func (vm *Vm) Run(ctx context.Context, b []byte) ([]byte, error) {
 		b = bb
 		logg.DebugCtxf(ctx, "execute code", "opcode", op, "op", OpcodeString[op], "code", b)
 		logg.DebugCtxf(ctx, "", "state", vm.st)
+		stMoves := vm.st.Moves
+		if op != LOAD {
+			vm.prefetched = nil
+		} else if vm.concurrentLoad && vm.prefetched == nil {
+			vm.prefetch(ctx, b)
+		}
+		var ev HookEvent
+		if len(vm.hooks) > 0 {
+			ev = vm.hookBefore(ctx, steps, op, ib, b)
+		}
 		switch op {
 		case CATCH:
 			b, err = vm.runCatch(ctx, b)
@@ -166,6 +260,8 @@ func (vm *Vm) Run(ctx context.Context, b []byte) ([]byte, error) {
 			b, err = vm.runMove(ctx, b)
 		case INCMP:
 			b, err = vm.runInCmp(ctx, b)
+		case INPAT:
+			b, err = vm.runInPat(ctx, b)
 		case MSINK:
 			b, err = vm.runMSink(ctx, b)
 		case MOUT:
@@ -174,15 +270,43 @@ func (vm *Vm) Run(ctx context.Context, b []byte) ([]byte, error) {
 			b, err = vm.runMNext(ctx, b)
 		case MPREV:
 			b, err = vm.runMPrev(ctx, b)
+		case JUMP:
+			b, err = vm.runJump(ctx, b)
+		case JUMPIF:
+			b, err = vm.runJumpIf(ctx, b)
+		case CALL:
+			b, err = vm.runCall(ctx, b)
+		case RET:
+			b, err = vm.runRet(ctx, b)
+		case FSET:
+			b, err = vm.runFSet(ctx, b)
+		case FRESET:
+			b, err = vm.runFReset(ctx, b)
 		case HALT:
 			b, err = vm.runHalt(ctx, b)
-			return b, err
 		default:
 			err = fmt.Errorf("Unhandled state: %v", op)
 		}
+		if len(vm.hooks) > 0 {
+			vm.hookAfter(ctx, ev, err)
+		}
+		if op == HALT {
+			return b, vm.sourceError(ctx, node, ib, err)
+		}
+		if vm.st.Moves != stMoves {
+			moves += 1
+			if vm.moveLimit > 0 && moves > vm.moveLimit {
+				logg.ErrorCtxf(ctx, "move limit exceeded", "limit", vm.moveLimit, "state", vm.st)
+				return b, NewLimitError("move", vm.moveLimit, vm.st.ExecPath)
+			}
+		}
+		var serr error
+		if err != nil {
+			serr = vm.sourceError(ctx, node, ib, err)
+		}
 		b, err = vm.runErrCheck(ctx, b, err)
 		if err != nil {
-			return b, err
+			return b, serr
 		}
 		if len(b) == 0 {
 			b, err = vm.runDeadCheck(ctx, b)
@@ -279,6 +403,7 @@ func (vm *Vm) runCatch(ctx context.Context, b []byte) ([]byte, error) {
 		if err != nil {
 			return b, err
 		}
+		vm.st.ResetCalls()
 		b = bh
 	}
 	return b, nil
@@ -295,6 +420,8 @@ func (vm *Vm) runCroak(ctx context.Context, b []byte) ([]byte, error) {
 		logg.InfoCtxf(ctx, "croak! purging and moving to top", "signal", sig)
 		vm.Reset()
 		vm.ca.Reset()
+		vm.cacheChange("", "", false)
+		vm.st.ResetCalls()
 		b = []byte{}
 	}
 	return b, nil
@@ -321,6 +448,8 @@ func (vm *Vm) runLoad(ctx context.Context, b []byte) ([]byte, error) {
 			logg.DebugCtxf(ctx, "Ignoring load request on frame that has symbol already loaded", "sym", sym)
 			err = nil
 		}
+	} else {
+		vm.cacheChange(sym, r, false)
 	}
 	return b, err
 }
@@ -337,6 +466,7 @@ func (vm *Vm) runReload(ctx context.Context, b []byte) ([]byte, error) {
 		return b, err
 	}
 	vm.ca.Update(sym, r)
+	vm.cacheChange(sym, r, true)
 	if vm.pg != nil {
 		err := vm.pg.Map(sym)
 		if err != nil {
@@ -363,6 +493,7 @@ func (vm *Vm) runMove(ctx context.Context, b []byte) ([]byte, error) {
 	logg.DebugCtxf(ctx, "loaded code", "sym", sym, "code", code)
 	b = append(b, code...)
 	vm.Reset()
+	vm.st.ResetCalls()
 	return b, nil
 }
 
@@ -401,6 +532,45 @@ func (vm *Vm) runInCmp(ctx context.Context, b []byte) ([]byte, error) {
 		}
 		logg.InfoCtxf(ctx, "input match", "input", input, "next", sym)
 	}
+	return vm.inputMove(ctx, b, sym)
+}
+
+// executes the INPAT opcode
+func (vm *Vm) runInPat(ctx context.Context, b []byte) ([]byte, error) {
+	sym, class, b, err := ParseInPat(b)
+	if err != nil {
+		return b, err
+	}
+	re, err := InputPattern(class)
+	if err != nil {
+		return b, err
+	}
+
+	reading := vm.st.GetFlag(state.FLAG_READIN)
+	have := vm.st.GetFlag(state.FLAG_INMATCH)
+	if have {
+		if reading {
+			logg.DebugCtxf(ctx, "ignoring input - already have match", "input", sym)
+			return b, nil
+		}
+	} else {
+		vm.st.SetFlag(state.FLAG_READIN)
+	}
+	input, err := vm.st.GetInput()
+	if err != nil {
+		return b, err
+	}
+	logg.TraceCtxf(ctx, "testing pattern", "sym", sym, "class", class, "input", input)
+
+	if !re.Match(input) {
+		return b, nil
+	}
+	logg.InfoCtxf(ctx, "input pattern match", "input", input, "class", class, "next", sym)
+	return vm.inputMove(ctx, b, sym)
+}
+
+// moves to the target of a matched INCMP or INPAT, and appends its bytecode.
+func (vm *Vm) inputMove(ctx context.Context, b []byte, sym string) ([]byte, error) {
 	vm.st.SetFlag(state.FLAG_INMATCH)
 	vm.st.ResetFlag(state.FLAG_READIN)
 
@@ -425,6 +595,7 @@ func (vm *Vm) runInCmp(ctx context.Context, b []byte) ([]byte, error) {
 	}
 	logg.DebugCtxf(ctx, "loaded additional code", "next", sym, "code", code)
 	b = append(b, code...)
+	vm.st.ResetCalls()
 	return b, err
 }
 
@@ -488,6 +659,117 @@ func (vm *Vm) runMPrev(ctx context.Context, b []byte) ([]byte, error) {
 	return b, nil
 }
 
+// skip the given number of bytes of remaining bytecode.
+func jump(b []byte, offset uint32) ([]byte, error) {
+	if int(offset) > len(b) {
+		return b, fmt.Errorf("jump offset %v beyond end of code (%v)", offset, len(b))
+	}
+	return b[offset:], nil
+}
+
+// executes the JUMP opcode
+func (vm *Vm) runJump(ctx context.Context, b []byte) ([]byte, error) {
+	offset, b, err := ParseJump(b)
+	if err != nil {
+		return b, err
+	}
+	logg.DebugCtxf(ctx, "jump", "offset", offset)
+	return jump(b, offset)
+}
+
+// executes the JUMPIF opcode
+func (vm *Vm) runJumpIf(ctx context.Context, b []byte) ([]byte, error) {
+	offset, sig, mode, b, err := ParseJumpIf(b)
+	if err != nil {
+		return b, err
+	}
+	r := vm.st.MatchFlag(sig, mode)
+	if !r {
+		return b, nil
+	}
+	logg.DebugCtxf(ctx, "jump on flag", "offset", offset, "flag", sig, "mode", mode)
+	return jump(b, offset)
+}
+
+// executes the CALL opcode
+func (vm *Vm) runCall(ctx context.Context, b []byte) ([]byte, error) {
+	sym, b, err := ParseCall(b)
+	if err != nil {
+		return b, err
+	}
+	err = ValidSym([]byte(sym))
+	if err != nil {
+		return b, err
+	}
+	code, err := vm.rs.GetCode(ctx, sym)
+	if err != nil {
+		return b, err
+	}
+	err = vm.st.PushCall(b)
+	if err != nil {
+		return b, err
+	}
+	logg.DebugCtxf(ctx, "call", "sym", sym, "depth", vm.st.CallDepth())
+	return append([]byte{}, code...), nil
+}
+
+// executes the RET opcode
+//
+// Any remaining bytecode of the subroutine is discarded.
+func (vm *Vm) runRet(ctx context.Context, b []byte) ([]byte, error) {
+	b, err := ParseRet(b)
+	if err != nil {
+		return b, err
+	}
+	code, err := vm.st.PopCall()
+	if err != nil {
+		return b, err
+	}
+	logg.DebugCtxf(ctx, "return", "depth", vm.st.CallDepth())
+	return code, nil
+}
+
+// check that a flag may be changed by bytecode.
+func (vm *Vm) checkWriteableFlag(flag uint32) error {
+	if !state.IsWriteableFlag(flag) {
+		return fmt.Errorf("flag %v is not writeable", flag)
+	}
+	if flag >= vm.st.FlagBitSize() {
+		return fmt.Errorf("flag %v is out of range of bitfield size %v", flag, vm.st.FlagBitSize())
+	}
+	return nil
+}
+
+// executes the FSET opcode
+func (vm *Vm) runFSet(ctx context.Context, b []byte) ([]byte, error) {
+	flag, b, err := ParseFSet(b)
+	if err != nil {
+		return b, err
+	}
+	err = vm.checkWriteableFlag(flag)
+	if err != nil {
+		return b, err
+	}
+	logg.DebugCtxf(ctx, "set flag", "flag", flag)
+	vm.st.SetFlag(flag)
+	return b, nil
+}
+
+// executes the FRESET opcode
+func (vm *Vm) runFReset(ctx context.Context, b []byte) ([]byte, error) {
+	flag, b, err := ParseFReset(b)
+	if err != nil {
+		return b, err
+	}
+	err = vm.checkWriteableFlag(flag)
+	if err != nil {
+		return b, err
+	}
+	logg.DebugCtxf(ctx, "reset flag", "flag", flag)
+	vm.st.ResetFlag(flag)
+	return b, nil
+}
+
 // Render wraps output rendering, and handles error when attempting to browse beyond the rendered page count.
 func (vm *Vm) Render(ctx context.Context) (string, error) {
 	changed := vm.st.ResetFlag(state.FLAG_DIRTY)
@@ -525,8 +807,15 @@ func (vm *Vm) refresh(key string, rs resource.Resource, ctx context.Context) (st
 	if fn == nil {
 		return "", fmt.Errorf("no retrieve function for external symbol %v", key)
 	}
-	input, _ := vm.st.GetInput()
-	r, err := fn(ctx, key, input)
+	var r resource.Result
+	pr, ok := vm.prefetched[key]
+	if ok {
+		delete(vm.prefetched, key)
+		r, err = pr.r, pr.err
+	} else {
+		input, _ := vm.st.GetInput()
+		r, err = vm.callFunc(ctx, fn, key, input)
+	}
 	if err != nil {
 		logg.Errorf("external function load fail", "key", key, "error", err)
 		_ = vm.st.SetFlag(state.FLAG_LOADFAIL)
@@ -548,6 +837,7 @@ func (vm *Vm) refresh(key string, rs resource.Resource, ctx context.Context) (st
 	haveLang := vm.st.MatchFlag(state.FLAG_LANG, true)
 	if haveLang {
 		vm.st.SetLanguage(r.Content)
+		vm.prefetched = nil
 	}
 
 	return r.Content, err
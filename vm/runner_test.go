@@ -3,8 +3,10 @@ package vm
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"reflect"
 	"strings"
 	"testing"
 
@@ -662,6 +664,53 @@ func TestInputIgnoreWildcard(t *testing.T) {
 	}
 }
 
+func TestInputPattern(t *testing.T) {
+	err := RegisterInputPattern("pin", "^[0-9]{4}$")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	b := NewLine(nil, INPAT, []string{"one", "pin"}, nil, nil)
+	b = NewLine(b, INCMP, []string{"ouf", "*"}, nil, nil)
+
+	for _, v := range []struct {
+		input  string
+		expect string
+	}{
+		{"1234", "one"},
+		{"12345", "ouf"},
+	} {
+		st := state.NewState(5)
+		rs := newTestResource(st)
+		rs.Lock()
+		ca := cache.NewCache()
+		vm := NewVm(st, &rs, ca, nil)
+		st.Down("root")
+		st.SetInput([]byte(v.input))
+		_, err = vm.Run(ctx, b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		location, _ := st.Where()
+		if location != v.expect {
+			t.Fatalf("input %s: expected '%s', got %s", v.input, v.expect, location)
+		}
+	}
+
+	st := state.NewState(5)
+	rs := newTestResource(st)
+	rs.Lock()
+	vm := NewVm(st, &rs, cache.NewCache(), nil)
+	st.Down("root")
+	st.SetInput([]byte("1234"))
+	b = NewLine(nil, INPAT, []string{"one", "nonexistent"}, nil, nil)
+	_, err = vm.Run(ctx, b)
+	if err == nil {
+		t.Fatal("expected error for unregistered input pattern")
+	}
+}
+
 func TestCatchCleanMenu(t *testing.T) {
 	st := state.NewState(5)
 	rs := newTestResource(st)
@@ -874,3 +923,247 @@ func TestErrorOut(t *testing.T) {
 		t.Fatalf("expected error")
 	}
 }
+
+func TestRunJump(t *testing.T) {
+	st := state.NewState(5)
+	rs := newTestResource(st)
+	rs.Lock()
+	ca := cache.NewCache()
+	vm := NewVm(st, &rs, ca, nil)
+	ctx := context.Background()
+	st.Down("bar")
+
+	b := NewLine(nil, JUMPIF, nil, []byte{0x00, 0x08}, nil)
+	b = append(b, 0x01, state.FLAG_USERSTART, 0x01)
+	b = NewLine(b, LOAD, []string{"two"}, []byte{0x0a}, nil)
+	b = NewLine(b, JUMP, nil, []byte{0x00, 0x08}, nil)
+	b = NewLine(b, LOAD, []string{"one"}, []byte{0x0a}, nil)
+	b = NewLine(b, HALT, nil, nil, nil)
+
+	_, err := vm.Run(ctx, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ca.Get("two")
+	if err != nil {
+		t.Fatalf("expected 'two' loaded: %v", err)
+	}
+	_, err = ca.Get("one")
+	if err == nil {
+		t.Fatal("expected 'one' skipped")
+	}
+
+	ca = cache.NewCache()
+	vm = NewVm(st, &rs, ca, nil)
+	st.SetFlag(state.FLAG_USERSTART)
+	_, err = vm.Run(ctx, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ca.Get("two")
+	if err == nil {
+		t.Fatal("expected 'two' skipped")
+	}
+
+	b = NewLine(nil, JUMP, nil, []byte{0x00, 0x08}, nil)
+	b = NewLine(b, HALT, nil, nil, nil)
+	_, err = vm.Run(ctx, b)
+	if err == nil {
+		t.Fatal("expected error for jump beyond end of code")
+	}
+}
+
+func TestRunFlag(t *testing.T) {
+	st := state.NewState(1)
+	rs := newTestResource(st)
+	rs.Lock()
+	ca := cache.NewCache()
+	vm := NewVm(st, &rs, ca, nil)
+	ctx := context.Background()
+	st.Down("bar")
+
+	b := NewLine(nil, FSET, nil, []byte{state.FLAG_USERSTART}, nil)
+	b = NewLine(b, HALT, nil, nil, nil)
+	_, err := vm.Run(ctx, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !st.GetFlag(state.FLAG_USERSTART) {
+		t.Fatal("expected flag set")
+	}
+
+	b = NewLine(nil, FRESET, nil, []byte{state.FLAG_USERSTART}, nil)
+	b = NewLine(b, HALT, nil, nil, nil)
+	_, err = vm.Run(ctx, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.GetFlag(state.FLAG_USERSTART) {
+		t.Fatal("expected flag reset")
+	}
+
+	b = NewLine(nil, FSET, nil, []byte{state.FLAG_LOADFAIL}, nil)
+	b = NewLine(b, HALT, nil, nil, nil)
+	_, err = vm.Run(ctx, b)
+	if err == nil {
+		t.Fatal("expected error for non-writeable flag")
+	}
+	if st.GetFlag(state.FLAG_LOADFAIL) {
+		t.Fatal("expected non-writeable flag unchanged")
+	}
+
+	b = NewLine(nil, FSET, nil, []byte{0x42}, nil)
+	b = NewLine(b, HALT, nil, nil, nil)
+	_, err = vm.Run(ctx, b)
+	if err == nil {
+		t.Fatal("expected error for flag out of range")
+	}
+}
+
+func TestRunCall(t *testing.T) {
+	st := state.NewState(5)
+	rs := newTestResource(st)
+	ctx := context.Background()
+	b := NewLine(nil, LOAD, []string{"two"}, []byte{0x0a}, nil)
+	b = NewLine(b, RET, nil, nil, nil)
+	b = NewLine(b, LOAD, []string{"dyn"}, []byte{0x0a}, nil)
+	rs.AddBytecode(ctx, "sub", b)
+	b = NewLine(nil, CALL, []string{"loop"}, nil, nil)
+	rs.AddBytecode(ctx, "loop", b)
+	rs.Lock()
+	ca := cache.NewCache()
+	vm := NewVm(st, &rs, ca, nil)
+	st.Down("bar")
+
+	b = NewLine(nil, CALL, []string{"sub"}, nil, nil)
+	b = NewLine(b, LOAD, []string{"one"}, []byte{0x0a}, nil)
+	b = NewLine(b, HALT, nil, nil, nil)
+	_, err := vm.Run(ctx, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range []string{"one", "two"} {
+		_, err = ca.Get(k)
+		if err != nil {
+			t.Fatalf("expected '%s' loaded: %v", k, err)
+		}
+	}
+	_, err = ca.Get("dyn")
+	if err == nil {
+		t.Fatal("expected 'dyn' skipped after return")
+	}
+	if st.CallDepth() != 0 {
+		t.Fatalf("expected call depth 0, got %d", st.CallDepth())
+	}
+
+	b = NewLine(nil, CALL, []string{"loop"}, nil, nil)
+	_, err = vm.Run(ctx, b)
+	if err == nil {
+		t.Fatal("expected error on max call depth")
+	}
+	st.ResetCalls()
+
+	b = NewLine(nil, RET, nil, nil, nil)
+	_, err = vm.Run(ctx, b)
+	if err == nil {
+		t.Fatal("expected error on return without call")
+	}
+}
+
+func TestRunCallMove(t *testing.T) {
+	st := state.NewState(5)
+	rs := newTestResource(st)
+	ctx := context.Background()
+	rs.AddBytecode(ctx, "away", NewLine(nil, HALT, nil, nil, nil))
+	rs.AddBytecode(ctx, "walk", NewLine(nil, MOVE, []string{"away"}, nil, nil))
+	b := NewLine(nil, HALT, nil, nil, nil)
+	b = NewLine(b, INCMP, []string{"away", "1"}, nil, nil)
+	rs.AddBytecode(ctx, "ask", b)
+	rs.Lock()
+	ca := cache.NewCache()
+	vm := NewVm(st, &rs, ca, nil)
+	st.Down("bar")
+
+	for i := 0; i <= state.MaxCallDepth; i++ {
+		b = NewLine(nil, CALL, []string{"walk"}, nil, nil)
+		b = NewLine(b, LOAD, []string{"one"}, []byte{0x0a}, nil)
+		_, err := vm.Run(ctx, b)
+		if err != nil {
+			t.Fatalf("call %d: %v", i, err)
+		}
+		if st.CallDepth() != 0 {
+			t.Fatalf("expected call depth 0 after move, got %d", st.CallDepth())
+		}
+		st.Up()
+	}
+	_, err := ca.Get("one")
+	if err == nil {
+		t.Fatal("expected caller bytecode discarded after move")
+	}
+
+	b = NewLine(nil, CALL, []string{"ask"}, nil, nil)
+	b = NewLine(b, HALT, nil, nil, nil)
+	_, err = vm.Run(ctx, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.CallDepth() != 1 {
+		t.Fatalf("expected call depth 1 on halt, got %d", st.CallDepth())
+	}
+	st.SetInput([]byte("1"))
+	b = NewLine(nil, INCMP, []string{"away", "1"}, nil, nil)
+	_, err = vm.Run(ctx, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.CallDepth() != 0 {
+		t.Fatalf("expected call depth 0 after input move, got %d", st.CallDepth())
+	}
+
+	b = NewLine(nil, RET, nil, nil, nil)
+	_, err = vm.Run(ctx, b)
+	if err == nil {
+		t.Fatal("expected error on return after move")
+	}
+}
+
+func TestRunLimit(t *testing.T) {
+	st := state.NewState(5)
+	rs := newTestResource(st)
+	ctx := context.Background()
+	b := NewLine(nil, MOVE, []string{"pong"}, nil, nil)
+	rs.AddBytecode(ctx, "ping", b)
+	b = NewLine(nil, MOVE, []string{"ping"}, nil, nil)
+	rs.AddBytecode(ctx, "pong", b)
+	rs.Lock()
+	ca := cache.NewCache()
+	vm := NewVm(st, &rs, ca, nil).WithStepLimit(4)
+	st.Down("bar")
+
+	b = NewLine(nil, FSET, nil, []byte{state.FLAG_USERSTART}, nil)
+	b = NewLine(b, FSET, nil, []byte{state.FLAG_USERSTART}, nil)
+	b = NewLine(b, FSET, nil, []byte{state.FLAG_USERSTART}, nil)
+	b = NewLine(b, HALT, nil, nil, nil)
+	_, err := vm.Run(ctx, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b = append(NewLine(nil, FSET, nil, []byte{state.FLAG_USERSTART}, nil), b...)
+	_, err = vm.Run(ctx, b)
+	var e *LimitError
+	if !errors.As(err, &e) {
+		t.Fatalf("expected limit error, got %v", err)
+	}
+
+	vm = NewVm(st, &rs, ca, nil).WithMoveLimit(3)
+	b = NewLine(nil, MOVE, []string{"ping"}, nil, nil)
+	_, err = vm.Run(ctx, b)
+	if !errors.As(err, &e) {
+		t.Fatalf("expected limit error, got %v", err)
+	}
+	expect := []string{"bar", "ping", "pong", "ping", "pong"}
+	if !reflect.DeepEqual(e.Path(), expect) {
+		t.Fatalf("expected path %v, got %v", expect, e.Path())
+	}
+}
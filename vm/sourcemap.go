@@ -0,0 +1,229 @@
+package vm
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"git.defalsify.org/vise.git/resource"
+)
+
+// SourcePos is a position in an assembly source file.
+type SourcePos struct {
+	File   string
+	Line   int
+	Column int
+}
+
+// String implements the String interface.
+//
+// The format is "<file>:<line>:<column>".
+func (p SourcePos) String() string {
+	return fmt.Sprintf("%s:%d:%d", p.File, p.Line, p.Column)
+}
+
+// SourceMap maps byte offsets of instructions in the bytecode of a node to their positions in the assembly source.
+type SourceMap struct {
+	offsets []int
+	pos     map[int]SourcePos
+}
+
+// NewSourceMap creates a new, empty SourceMap.
+func NewSourceMap() *SourceMap {
+	return &SourceMap{
+		pos: make(map[int]SourcePos),
+	}
+}
+
+// Add records the source position of the instruction at the given bytecode offset.
+func (sm *SourceMap) Add(offset int, pos SourcePos) {
+	_, ok := sm.pos[offset]
+	if !ok {
+		i := sort.SearchInts(sm.offsets, offset)
+		sm.offsets = append(sm.offsets, 0)
+		copy(sm.offsets[i+1:], sm.offsets[i:])
+		sm.offsets[i] = offset
+	}
+	sm.pos[offset] = pos
+}
+
+// Lookup returns the source position of the instruction at the given bytecode offset.
+//
+// If the offset has no entry, the position of the nearest preceding instruction is returned. The second return value is false if there is no such instruction.
+func (sm *SourceMap) Lookup(offset int) (SourcePos, bool) {
+	i := sort.SearchInts(sm.offsets, offset+1)
+	if i == 0 {
+		return SourcePos{}, false
+	}
+	return sm.pos[sm.offsets[i-1]], true
+}
+
+// Len returns the number of entries in the source map.
+func (sm *SourceMap) Len() int {
+	return len(sm.offsets)
+}
+
+// Bytes serializes the source map.
+//
+// The format is one line per instruction, "<offset> <file>:<line>:<column>", ordered by offset.
+func (sm *SourceMap) Bytes() []byte {
+	b := bytes.NewBuffer(nil)
+	for _, v := range sm.offsets {
+		fmt.Fprintf(b, "%d %s\n", v, sm.pos[v])
+	}
+	return b.Bytes()
+}
+
+// ParseSourceMap deserializes a source map serialized with SourceMap.Bytes.
+func ParseSourceMap(b []byte) (*SourceMap, error) {
+	sm := NewSourceMap()
+	for i, l := range strings.Split(string(b), "\n") {
+		if l == "" {
+			continue
+		}
+		offset, pos, err := parseSourceLine(l)
+		if err != nil {
+			return nil, fmt.Errorf("source map line %d: %v", i+1, err)
+		}
+		sm.Add(offset, pos)
+	}
+	return sm, nil
+}
+
+// parse a single serialized source map entry.
+//
+// The file name may contain colons and spaces, so line and column are split off from the end.
+func parseSourceLine(l string) (int, SourcePos, error) {
+	var pos SourcePos
+	s, rest, ok := strings.Cut(l, " ")
+	if !ok {
+		return 0, pos, fmt.Errorf("missing position")
+	}
+	offset, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, pos, err
+	}
+	i := strings.LastIndex(rest, ":")
+	if i < 0 {
+		return 0, pos, fmt.Errorf("missing column")
+	}
+	pos.Column, err = strconv.Atoi(rest[i+1:])
+	if err != nil {
+		return 0, pos, err
+	}
+	rest = rest[:i]
+	i = strings.LastIndex(rest, ":")
+	if i < 0 {
+		return 0, pos, fmt.Errorf("missing line")
+	}
+	pos.Line, err = strconv.Atoi(rest[i+1:])
+	if err != nil {
+		return 0, pos, err
+	}
+	pos.File = rest[:i]
+	return offset, pos, nil
+}
+
+// SourceError is an error from executing an instruction, annotated with the location of the instruction.
+type SourceError struct {
+	node   string
+	offset int
+	pos    SourcePos
+	err    error
+}
+
+// Node returns the symbol of the node the instruction was executed in.
+func (e SourceError) Node() string {
+	return e.node
+}
+
+// Offset returns the byte offset of the instruction in the bytecode of the node.
+func (e SourceError) Offset() int {
+	return e.offset
+}
+
+// Pos returns the source position of the instruction.
+func (e SourceError) Pos() SourcePos {
+	return e.pos
+}
+
+// Unwrap returns the error of the instruction.
+func (e SourceError) Unwrap() error {
+	return e.err
+}
+
+// Error implements the Error interface.
+func (e SourceError) Error() string {
+	return fmt.Sprintf("%s (%s+%d): %v", e.pos, e.node, e.offset, e.err)
+}
+
+// byte offset of an instruction in the bytecode of a node, where b is the bytecode from the start of the instruction.
+//
+// Returns -1 if b is not part of the bytecode of the node, for example when code of several nodes is queued.
+func (vm *Vm) codeOffset(ctx context.Context, node string, b []byte) int {
+	if node == "" {
+		return -1
+	}
+	if node != vm.srcNode {
+		vm.srcNode = node
+		vm.srcCode, _ = vm.rs.GetCode(ctx, node)
+		vm.srcMap = nil
+		vm.srcLoaded = false
+	}
+	if len(b) > len(vm.srcCode) || !bytes.HasSuffix(vm.srcCode, b) {
+		return -1
+	}
+	return len(vm.srcCode) - len(b)
+}
+
+// source position of the instruction at the given offset of the node last passed to codeOffset.
+func (vm *Vm) sourcePos(ctx context.Context, offset int) (SourcePos, bool) {
+	if offset < 0 {
+		return SourcePos{}, false
+	}
+	if !vm.srcLoaded {
+		vm.srcLoaded = true
+		mp, ok := vm.rs.(resource.SourceMapper)
+		if !ok {
+			return SourcePos{}, false
+		}
+		b, err := mp.GetSourceMap(ctx, vm.srcNode)
+		if err != nil {
+			logg.TraceCtxf(ctx, "no source map", "node", vm.srcNode, "err", err)
+			return SourcePos{}, false
+		}
+		vm.srcMap, err = ParseSourceMap(b)
+		if err != nil {
+			logg.WarnCtxf(ctx, "invalid source map", "node", vm.srcNode, "err", err)
+			return SourcePos{}, false
+		}
+	}
+	if vm.srcMap == nil {
+		return SourcePos{}, false
+	}
+	return vm.srcMap.Lookup(offset)
+}
+
+// annotate an instruction error with the source position of the instruction, if available.
+//
+// Errors without a source position are returned unchanged.
+func (vm *Vm) sourceError(ctx context.Context, node string, b []byte, err error) error {
+	if err == nil {
+		return nil
+	}
+	offset := vm.codeOffset(ctx, node, b)
+	pos, ok := vm.sourcePos(ctx, offset)
+	if !ok {
+		return err
+	}
+	logg.ErrorCtxf(ctx, "instruction failed", "source", pos, "node", node, "offset", offset, "err", err)
+	return SourceError{
+		node:   node,
+		offset: offset,
+		pos:    pos,
+		err:    err,
+	}
+}
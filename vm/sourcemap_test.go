@@ -0,0 +1,99 @@
+package vm
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"git.defalsify.org/vise.git/cache"
+	"git.defalsify.org/vise.git/internal/resourcetest"
+	"git.defalsify.org/vise.git/state"
+)
+
+func TestSourceMap(t *testing.T) {
+	sm := NewSourceMap()
+	sm.Add(12, SourcePos{File: "c:/foo bar.vis", Line: 3, Column: 2})
+	sm.Add(0, SourcePos{File: "c:/foo bar.vis", Line: 1, Column: 1})
+	b := sm.Bytes()
+	expect := "0 c:/foo bar.vis:1:1\n12 c:/foo bar.vis:3:2\n"
+	if string(b) != expect {
+		t.Fatalf("expected:\n%s\ngot:\n%s", expect, b)
+	}
+
+	sm, err := ParseSourceMap(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sm.Len() != 2 {
+		t.Fatalf("expected 2 entries, got %d", sm.Len())
+	}
+	pos, ok := sm.Lookup(12)
+	if !ok || pos.Line != 3 || pos.Column != 2 || pos.File != "c:/foo bar.vis" {
+		t.Fatalf("unexpected position %v", pos)
+	}
+	pos, ok = sm.Lookup(11)
+	if !ok || pos.Line != 1 {
+		t.Fatalf("expected nearest preceding position, got %v", pos)
+	}
+	_, ok = sm.Lookup(-1)
+	if ok {
+		t.Fatal("expected no position")
+	}
+
+	_, err = ParseSourceMap([]byte("0 foo.vis:1\n"))
+	if err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestRunSourceError(t *testing.T) {
+	ctx := context.Background()
+	st := state.NewState(0)
+	rs := resourcetest.NewTestResource()
+	b := NewLine(nil, MAP, []string{"foo"}, nil, nil)
+	b = NewLine(b, MOVE, []string{"nonexistent"}, nil, nil)
+	rs.AddBytecode(ctx, "root", b)
+	rs.AddTemplate(ctx, "root", "root")
+	sm := NewSourceMap()
+	sm.Add(0, SourcePos{File: "root.vis", Line: 1, Column: 1})
+	sm.Add(6, SourcePos{File: "root.vis", Line: 2, Column: 1})
+	rs.AddSourceMap(ctx, "root", sm.Bytes())
+	rs.AddBytecode(ctx, "_catch", NewLine(nil, HALT, nil, nil, nil))
+	rs.Lock()
+	ca := cache.NewCache()
+	vm := NewVm(st, rs, ca, nil)
+	st.Down("root")
+
+	_, err := vm.Run(ctx, b[6:])
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	var e SourceError
+	if !errors.As(err, &e) {
+		t.Fatalf("expected source error, got %v", err)
+	}
+	if e.Node() != "root" || e.Offset() != 6 || e.Pos().Line != 2 {
+		t.Fatalf("unexpected source error: %v", e)
+	}
+	if !strings.HasPrefix(err.Error(), "root.vis:2:1 (root+6): ") {
+		t.Fatalf("unexpected error string: %v", err)
+	}
+}
+
+func TestParseHandlerSourceMap(t *testing.T) {
+	b := NewLine(nil, MAP, []string{"foo"}, nil, nil)
+	b = NewLine(b, HALT, nil, nil, nil)
+	sm := NewSourceMap()
+	sm.Add(0, SourcePos{File: "root.vis", Line: 1, Column: 1})
+	sm.Add(6, SourcePos{File: "root.vis", Line: 3, Column: 1})
+	ph := NewParseHandler().WithDefaultHandlers().WithSourceMap(sm)
+	r, err := ph.ToString(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := "MAP foo # root.vis:1:1\nHALT # root.vis:3:1\n"
+	if r != expect {
+		t.Fatalf("expected:\n%s\ngot:\n%s", expect, r)
+	}
+}
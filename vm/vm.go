@@ -75,6 +75,11 @@ func ParseInCmp(b []byte) (string, string, []byte, error) {
 	return parseTwoSym(b)
 }
 
+// ParseInPat parses and extracts the expected argument portion of a INPAT instruction
+func ParseInPat(b []byte) (string, string, []byte, error) {
+	return parseTwoSym(b)
+}
+
 // ParseMPrev parses and extracts the expected argument portion of a MPREV instruction
 func ParseMPrev(b []byte) (string, string, []byte, error) {
 	return parseTwoSym(b)
@@ -103,6 +108,40 @@ func ParseMOut(b []byte) (string, string, []byte, error) {
 	return parseTwoSym(b)
 }
 
+// ParseJump parses and extracts the expected argument portion of a JUMP instruction.
+//
+// The returned offset is the number of bytes to skip after the instruction.
+func ParseJump(b []byte) (uint32, []byte, error) {
+	return intSplit(b)
+}
+
+// ParseJumpIf parses and extracts the expected argument portion of a JUMPIF instruction.
+//
+// The returned offset is the number of bytes to skip after the instruction.
+func ParseJumpIf(b []byte) (uint32, uint32, bool, []byte, error) {
+	return parseOffsetSig(b)
+}
+
+// ParseFSet parses and extracts the expected argument portion of a FSET instruction
+func ParseFSet(b []byte) (uint32, []byte, error) {
+	return intSplit(b)
+}
+
+// ParseFReset parses and extracts the expected argument portion of a FRESET instruction
+func ParseFReset(b []byte) (uint32, []byte, error) {
+	return intSplit(b)
+}
+
+// ParseCall parses and extracts the expected argument portion of a CALL instruction
+func ParseCall(b []byte) (string, []byte, error) {
+	return parseSym(b)
+}
+
+// ParseRet parses and extracts the expected argument portion of a RET instruction
+func ParseRet(b []byte) ([]byte, error) {
+	return parseNoArg(b)
+}
+
 // noop
 func parseNoArg(b []byte) ([]byte, error) {
 	return b, nil
@@ -162,6 +201,19 @@ func parseSymSig(b []byte) (string, uint32, bool, []byte, error) {
 	return sym, sig, matchmode, b, nil
 }
 
+// parse and extract one length-prefixed integer offset value, one length-prefixed integer value, and one single byte of integer
+func parseOffsetSig(b []byte) (uint32, uint32, bool, []byte, error) {
+	offset, b, err := intSplit(b)
+	if err != nil {
+		return 0, 0, false, b, err
+	}
+	sig, matchmode, b, err := parseSig(b)
+	if err != nil {
+		return 0, 0, false, b, err
+	}
+	return offset, sig, matchmode, b, nil
+}
+
 // parse and extract one single byte of integer
 func parseSig(b []byte) (uint32, bool, []byte, error) {
 	sig, b, err := intSplit(b)
@@ -179,9 +231,18 @@ func parseSig(b []byte) (uint32, bool, []byte, error) {
 
 // split bytecode into head and b using length-prefixed integer
 func intSplit(b []byte) (uint32, []byte, error) {
+	if len(b) == 0 {
+		return 0, b, fmt.Errorf("argument is empty")
+	}
 	l := uint8(b[0])
 	sz := uint32(l)
 	b = b[1:]
+	if l > 4 {
+		return 0, b, fmt.Errorf("integer argument length %v too long", l)
+	}
+	if len(b) < int(l) {
+		return 0, b, fmt.Errorf("corrupt instruction, len %v less than integer length: %v", len(b), l)
+	}
 	if l > 0 {
 		r := []byte{0, 0, 0, 0}
 		c := 0
@@ -208,7 +269,7 @@ func instructionSplit(b []byte) (string, []byte, error) {
 	if sz == 0 {
 		return "", nil, fmt.Errorf("zero-length argument")
 	}
-	bSz := len(b)
+	bSz := len(b) - 1
 	if bSz < int(sz) {
 		return "", nil, fmt.Errorf("corrupt instruction, len %v less than symbol length: %v", bSz, sz)
 	}
@@ -72,6 +72,23 @@ func TestParseSym(t *testing.T) {
 	}
 }
 
+func TestParseTruncated(t *testing.T) {
+	b := NewLine(nil, LOAD, []string{"foo"}, []byte{0x0a}, nil)
+	_, b, _ = opSplit(b)
+	_, _, _, err := ParseLoad(b[:len(b)-1])
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	_, _, _, err = ParseLoad(b[:len(b)-2])
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	_, _, err = ParseMove(b[:3])
+	if err == nil {
+		t.Fatal("expected error")
+	}
+}
+
 func TestParseTwoSym(t *testing.T) {
 	b := NewLine(nil, INCMP, []string{"foo", "bar"}, nil, nil)
 	_, b, _ = opSplit(b)